@@ -0,0 +1,148 @@
+// api/accounts.go
+// Points accounts of the users: the ledger and the loyalty tiers are kept in memory and rebuilt at startup from the storage
+// (the receipts, their reversals and the redemptions), and the redemptions are saved to the storage as they change.
+
+package api
+
+import (
+    "fmt"
+    "sort"
+    "time"
+
+    "receipt-processor/services"
+    "receipt-processor/storage"
+)
+
+// RestoreAccounts
+// @Description    Rebuild the points ledger and the loyalty tiers from the storage: one credit per receipt of a user,
+//                 one reversal entry per reversal of the receipt, and the redemptions with their confirmation or cancellation,
+//                 in the order they happened. The ledger records nothing, see WithLedger.
+// @Param          store: storage.ReceiptStore, tiers: *services.TierTracker
+// @Return         ledger: *services.Ledger, error: error
+func RestoreAccounts(store storage.ReceiptStore, tiers *services.TierTracker) (*services.Ledger, error) {
+    receipts, err := store.ListReceipts()
+    if err != nil {
+        return nil, fmt.Errorf("[RestoreAccounts] Failed to list the receipts: %w", err)
+    }
+    redemptions, err := store.ListRedemptions()
+    if err != nil {
+        return nil, fmt.Errorf("[RestoreAccounts] Failed to list the redemptions: %w", err)
+    }
+
+    // The processing and the reversals of the receipts of the users, and the reservations and settlements of the redemptions,
+    // oldest first
+    type accountEvent struct {
+        at    time.Time
+        apply func() error
+    }
+    ledger := services.NewLedger()
+    events := []accountEvent{}
+    for i := range receipts {
+        data := &receipts[i]
+        if data.Receipt.UserID == "" {
+            continue
+        }
+        events = append(events, accountEvent{at: data.ProcessedAt, apply: func() error {
+            accruedAt := services.AccrualDate(&data.Receipt, data.ProcessedAt)
+            if _, _, err := ledger.Credit(data.Receipt.UserID, data.ID, data.Points, accruedAt, data.ProcessedAt); err != nil {
+                return fmt.Errorf("Failed to credit receipt %v: %w", data.ID, err)
+            }
+            tiers.Restore(data.Receipt.UserID, data.ID, accruedAt, services.PointsResult{Points: data.Points, Breakdown: data.Breakdown, RuleVersion: data.RuleVersion})
+            return nil
+        }})
+        for j, reversal := range data.Reversals {
+            events = append(events, accountEvent{at: reversal.ReversedAt, apply: func() error {
+                if _, _, err := ledger.Reverse(data.Receipt.UserID, data.ID, j, reversal.Points, reversal.ReversedAt); err != nil {
+                    return fmt.Errorf("Failed to reverse receipt %v: %w", data.ID, err)
+                }
+                tiers.Reverse(data.Receipt.UserID, data.ID, reversal.BasePoints)
+                return nil
+            }})
+        }
+    }
+    for _, stored := range redemptions {
+        redemption := ledgerRedemption(stored)
+        events = append(events, accountEvent{at: redemption.CreatedAt, apply: func() error {
+            if err := ledger.RestoreRedemption(redemption); err != nil {
+                return fmt.Errorf("Failed to restore redemption %v: %w", redemption.ID, err)
+            }
+            return nil
+        }})
+        if redemption.SettledAt == nil {
+            continue
+        }
+        events = append(events, accountEvent{at: *redemption.SettledAt, apply: func() error {
+            var err error
+            switch redemption.Status {
+            case services.RedemptionConfirmed:
+                _, err = ledger.Confirm(redemption.UserID, redemption.ID, *redemption.SettledAt)
+            case services.RedemptionCancelled:
+                _, err = ledger.Cancel(redemption.UserID, redemption.ID, *redemption.SettledAt)
+            }
+            if err != nil {
+                return fmt.Errorf("Failed to settle redemption %v: %w", redemption.ID, err)
+            }
+            return nil
+        }})
+    }
+    sort.SliceStable(events, func(i, j int) bool {
+        return events[i].at.Before(events[j].at)
+    })
+
+    for _, event := range events {
+        if err := event.apply(); err != nil {
+            return nil, fmt.Errorf("[RestoreAccounts] %w", err)
+        }
+    }
+    return ledger, nil
+}
+
+// redemptionRecorder
+// @Description    Get a recorder saving the redemptions of a ledger to the storage.
+// @Param          store: storage.ReceiptStore
+// @Return         recorder: services.RedemptionRecorder
+func redemptionRecorder(store storage.ReceiptStore) services.RedemptionRecorder {
+    return services.RedemptionRecorderFunc(func(redemption services.Redemption) error {
+        return store.SaveRedemption(storedRedemption(redemption))
+    })
+}
+
+// storedRedemption
+// @Description    Convert a redemption of the ledger to its stored form.
+// @Param          redemption: services.Redemption
+// @Return         stored redemption: storage.Redemption
+func storedRedemption(redemption services.Redemption) storage.Redemption {
+    stored := storage.Redemption{
+        ID:        redemption.ID,
+        UserID:    redemption.UserID,
+        Points:    redemption.Points,
+        Status:    string(redemption.Status),
+        Lots:      make([]storage.RedemptionLot, 0, len(redemption.Consumed)),
+        CreatedAt: redemption.CreatedAt,
+        SettledAt: redemption.SettledAt,
+    }
+    for _, consumed := range redemption.Consumed {
+        stored.Lots = append(stored.Lots, storage.RedemptionLot{ReceiptID: consumed.ReceiptID, Points: consumed.Points})
+    }
+    return stored
+}
+
+// ledgerRedemption
+// @Description    Convert a stored redemption back to a redemption of the ledger.
+// @Param          stored: storage.Redemption
+// @Return         redemption: services.Redemption
+func ledgerRedemption(stored storage.Redemption) services.Redemption {
+    redemption := services.Redemption{
+        ID:        stored.ID,
+        UserID:    stored.UserID,
+        Points:    stored.Points,
+        Status:    services.RedemptionStatus(stored.Status),
+        Consumed:  make([]services.LotConsumption, 0, len(stored.Lots)),
+        CreatedAt: stored.CreatedAt,
+        SettledAt: stored.SettledAt,
+    }
+    for _, lot := range stored.Lots {
+        redemption.Consumed = append(redemption.Consumed, services.LotConsumption{ReceiptID: lot.ReceiptID, Points: lot.Points})
+    }
+    return redemption
+}
@@ -0,0 +1,152 @@
+// api/accounts_test.go
+// Tests for the points accounts rebuilt from the storage.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"receipt-processor/services"
+	"receipt-processor/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// openAccountsRouter opens the file storage in dir and sets up a router with the accounts restored from it.
+func openAccountsRouter(t *testing.T, dir string) (*mux.Router, *storage.FileStore) {
+	store, err := storage.OpenFileStore(dir, storage.FileStoreOptions{})
+	assert.NoError(t, err)
+	tiers := services.NewTierTracker(services.DefaultTierPolicy(), nil)
+	ledger, err := RestoreAccounts(store, tiers)
+	assert.NoError(t, err)
+	router := mux.NewRouter()
+	SetupRouter(router, NewHandler(store).WithLedger(ledger).WithTiers(tiers))
+	return router, store
+}
+
+// Test on restarting on the file storage after redemptions
+// expected: the confirmed redemptions stay spent, the reserved ones stay held and can be settled, the cancelled ones are released
+func TestRestoreAccounts_Redemptions(t *testing.T) {
+	dir := t.TempDir()
+	router, store := openAccountsRouter(t, dir)
+	var total int64
+	for i := 0; i < 2; i++ {
+		receipt := batchReceipt(i)
+		receipt.UserID = "alice"
+		data, _, _ := store.GetReceiptData(processReceipt(t, router, receipt))
+		total += data.Points
+	}
+	rr, confirmed := postRedemption(t, router, "/users/alice/redemptions", `{"points": 10}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	_, reserved := postRedemption(t, router, "/users/alice/redemptions", `{"points": 5, "reserve": true}`)
+	_, cancelled := postRedemption(t, router, "/users/alice/redemptions", `{"points": 3, "reserve": true}`)
+	rr, _ = postRedemption(t, router, "/users/alice/redemptions/"+cancelled.ID+"/cancel", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, store.Close())
+
+	// 1. restart: the balance is the same, the spent points cannot be spent again
+	router, store = openAccountsRouter(t, dir)
+	defer store.Close()
+	var balance BalanceResponse
+	getJSON(t, router, "/users/alice/balance", &balance)
+	assert.Equal(t, BalanceResponse{UserID: "alice", Balance: total - 10, Available: total - 15, Held: 5}, balance)
+	rr, _ = postRedemption(t, router, "/users/alice/redemptions", fmt.Sprintf(`{"points": %d}`, total-14))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	var redemption services.Redemption
+	assert.Equal(t, http.StatusOK, getJSON(t, router, "/users/alice/redemptions/"+confirmed.ID, &redemption))
+	assert.Equal(t, services.RedemptionConfirmed, redemption.Status)
+	assert.Equal(t, confirmed.Consumed, redemption.Consumed)
+	assert.Equal(t, http.StatusOK, getJSON(t, router, "/users/alice/redemptions/"+cancelled.ID, &redemption))
+	assert.Equal(t, services.RedemptionCancelled, redemption.Status)
+
+	// 2. the reservation made before the restart is confirmed after it
+	rr, redemption = postRedemption(t, router, "/users/alice/redemptions/"+reserved.ID+"/confirm", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, services.RedemptionConfirmed, redemption.Status)
+	getJSON(t, router, "/users/alice/balance", &balance)
+	assert.Equal(t, BalanceResponse{UserID: "alice", Balance: total - 15, Available: total - 15, Held: 0}, balance)
+
+	// 3. erasing the user erases their stored redemptions
+	req, _ := http.NewRequest("DELETE", "/users/alice/receipts", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	redemptions, err := store.ListRedemptions()
+	assert.NoError(t, err)
+	assert.Empty(t, redemptions)
+}
+
+// Test on cancelling, after a restart, a reservation of points of a receipt deleted before the restart
+// expected: the held points are released, the cancellation survives the next restart
+func TestRestoreAccounts_CancelDeletedLot(t *testing.T) {
+	dir := t.TempDir()
+	router, store := openAccountsRouter(t, dir)
+	ids := []string{}
+	points := []int64{}
+	for i := 0; i < 2; i++ {
+		receipt := batchReceipt(i)
+		receipt.UserID = "alice"
+		id := processReceipt(t, router, receipt)
+		data, _, _ := store.GetReceiptData(id)
+		ids = append(ids, id)
+		points = append(points, data.Points)
+	}
+	_, reserved := postRedemption(t, router, "/users/alice/redemptions", `{"points": 5, "reserve": true}`)
+	assert.Equal(t, ids[0], reserved.Consumed[0].ReceiptID)
+	req, _ := http.NewRequest("DELETE", "/receipts/"+ids[0], nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.NoError(t, store.Close())
+
+	// 1. restart, then cancel
+	router, store = openAccountsRouter(t, dir)
+	rr, cancelled := postRedemption(t, router, "/users/alice/redemptions/"+reserved.ID+"/cancel", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, services.RedemptionCancelled, cancelled.Status)
+	var balance BalanceResponse
+	getJSON(t, router, "/users/alice/balance", &balance)
+	assert.Equal(t, BalanceResponse{UserID: "alice", Balance: points[1], Available: points[1], Held: 0}, balance)
+	assert.NoError(t, store.Close())
+
+	// 2. the cancellation is replayed on the next restart
+	router, store = openAccountsRouter(t, dir)
+	defer store.Close()
+	getJSON(t, router, "/users/alice/balance", &balance)
+	assert.Equal(t, BalanceResponse{UserID: "alice", Balance: points[1], Available: points[1], Held: 0}, balance)
+}
+
+// Test on restoring a cancelled redemption of points of an erased receipt
+// expected: the accounts are restored, the redemption holds no points
+func TestRestoreAccounts_CancelledErasedLot(t *testing.T) {
+	store := storage.NewMemoryStore()
+	receipt := batchReceipt(0)
+	receipt.UserID = "alice"
+	processedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, store.SaveReceipt("kept", storage.ReceiptData{Receipt: receipt, Points: 20, ProcessedAt: processedAt}))
+	settledAt := processedAt.Add(2 * time.Hour)
+	assert.NoError(t, store.SaveRedemption(storage.Redemption{
+		ID:        "cancelled",
+		UserID:    "alice",
+		Points:    5,
+		Status:    string(services.RedemptionCancelled),
+		Lots:      []storage.RedemptionLot{{ReceiptID: "erased", Points: 5}},
+		CreatedAt: processedAt.Add(time.Hour),
+		SettledAt: &settledAt,
+	}))
+
+	ledger, err := RestoreAccounts(store, services.NewTierTracker(services.DefaultTierPolicy(), nil))
+	assert.NoError(t, err)
+	available, held := ledger.Available("alice")
+	assert.Equal(t, int64(20), available)
+	assert.Equal(t, int64(0), held)
+	redemption, err := ledger.Redemption("alice", "cancelled")
+	assert.NoError(t, err)
+	assert.Equal(t, services.RedemptionCancelled, redemption.Status)
+}
@@ -0,0 +1,79 @@
+// api/admin_handlers.go
+// Handling the admin API requests (rule management).
+
+package api
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+
+    "receipt-processor/services"
+    "receipt-processor/storage"
+)
+
+// maxRuleConfigSize is the maximum size of a rule configuration sent to the admin endpoints.
+const maxRuleConfigSize = 1 << 20
+
+// SimulateRulesHandler
+// @Description    Handle the POST /admin/rules/simulate endpoint.
+//                 The body is a candidate rule configuration (YAML or JSON, same format as the rule configuration file).
+//                 Every stored receipt is re-scored under the current and the candidate rules, nothing is stored nor activated.
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) SimulateRulesHandler(w http.ResponseWriter, r *http.Request) {
+    defer r.Body.Close()
+
+    body, err := io.ReadAll(io.LimitReader(r.Body, maxRuleConfigSize))
+    if err != nil {
+        http.Error(w, "Failed to read the rule configuration", http.StatusBadRequest)
+        return
+    }
+
+    // Parse and validate the candidate rules
+    config, err := services.ParseRuleConfig(body)
+    if err != nil {
+        http.Error(w, fmt.Sprintf("The rule configuration is invalid: %v", err), http.StatusBadRequest)
+        return
+    }
+    candidate, err := config.BuildRuleSet()
+    if err != nil {
+        http.Error(w, fmt.Sprintf("The rule configuration is invalid: %v", err), http.StatusBadRequest)
+        return
+    }
+
+    // Re-score all the stored receipts under both rule sets
+    stored, err := h.store.ListReceipts()
+    if err != nil {
+        http.Error(w, "Error accessing the storage", http.StatusInternalServerError)
+        return
+    }
+    receipts := make([]services.SimulationReceipt, 0, len(stored))
+    for _, data := range stored {
+        receipts = append(receipts, services.SimulationReceipt{ID: data.ID, Receipt: data.Receipt})
+    }
+    report := services.SimulateRules(receipts, services.GetRuleRegistry().Active(), candidate)
+
+    // Return the report
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(report)
+}
+
+
+// ListTombstonesHandler
+// @Description    Handle the GET /admin/tombstones endpoint: audit trail of the erased receipts (ID, reason, time, no personal data).
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) ListTombstonesHandler(w http.ResponseWriter, r *http.Request) {
+    tombstones, err := h.store.ListTombstones()
+    if err != nil {
+        http.Error(w, "Error accessing the storage", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(map[string][]storage.Tombstone{"tombstones": tombstones})
+}
\ No newline at end of file
@@ -0,0 +1,72 @@
+// api/admin_handlers_test.go
+// Tests for the admin API handlers.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"receipt-processor/models"
+	"receipt-processor/services"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests on SimulateRulesHandler function
+func TestSimulateRulesHandler(t *testing.T) {
+	router := setupRouter()
+
+	// make sure at least one receipt is stored
+	receipt := models.Receipt{
+		Retailer:     "Simulation Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "10:00",
+		Total:        "2.25",
+		Items: []models.Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+	}
+	requestBody, _ := json.Marshal(receipt)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(requestBody))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// 1. general case - 200 OK, candidate rules doubling the retailer name points
+	candidate := `{"rules": [{"type": "retailer-name", "params": {"points_per_character": 2}}]}`
+	req, _ = http.NewRequest("POST", "/admin/rules/simulate", bytes.NewBufferString(candidate))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var report services.SimulationReport
+	err := json.Unmarshal(rr.Body.Bytes(), &report)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, report.ReceiptCount, 1)
+	assert.Equal(t, services.GetRuleRegistry().Active().Version, report.CurrentVersion)
+
+	var sum int64 = 0
+	found := false
+	for _, delta := range report.Receipts {
+		sum += delta.Delta
+		if delta.CurrentPoints == 41 { // 16 (retailer) + 25 (multiple of 0.25)
+			found = true
+			assert.Equal(t, int64(32), delta.CandidatePoints)
+		}
+	}
+	assert.True(t, found)
+	assert.Equal(t, report.CandidateTotalPoints-report.CurrentTotalPoints, sum)
+
+	// 2. the candidate rules are not activated
+	assert.NotEqual(t, report.CandidateVersion, services.GetRuleRegistry().Active().Version)
+
+	// 3. invalid rule configuration - 400 Bad Request
+	req, _ = http.NewRequest("POST", "/admin/rules/simulate", bytes.NewBufferString(`{"rules": [{"type": "unknown"}]}`))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -0,0 +1,168 @@
+// api/batch_handlers.go
+// Handling the batch receipt submission requests.
+
+package api
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "sync"
+
+    "receipt-processor/models"
+)
+
+const (
+    // maxBatchSize is the maximum number of receipts in a batch.
+    maxBatchSize = 1000
+    // maxBatchBodySize is the maximum size of a batch request body.
+    maxBatchBodySize = 16 << 20
+    // batchWorkers is the number of receipts of a batch processed concurrently.
+    batchWorkers = 8
+)
+
+// BatchResult is the outcome of a single receipt of a batch.
+type BatchResult struct {
+    Index  int                `json:"index"`            // position of the receipt in the batch
+    Status int                `json:"status"`           // HTTP status the receipt would get from POST /receipts/process
+    ID     string             `json:"id,omitempty"`     // set on success
+    Error  string             `json:"error,omitempty"`  // set on failure
+    Errors models.FieldErrors `json:"errors,omitempty"` // invalid fields, if the receipt failed the validation
+}
+
+// BatchResponse is the response body of the POST /receipts/batch endpoint.
+type BatchResponse struct {
+    Succeeded int           `json:"succeeded"`
+    Failed    int           `json:"failed"`
+    Results   []BatchResult `json:"results"` // one result per receipt, in the batch order
+}
+
+// ProcessBatchHandler
+// @Description    Handle the POST /receipts/batch endpoint: process many receipts in a single request.
+//                 The body is a JSON array of receipts, or NDJSON (one receipt per line) with the application/x-ndjson content type.
+//                 The receipts are processed concurrently, each of them is processed like POST /receipts/process,
+//                 and a failing receipt does not abort the rest of the batch.
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) ProcessBatchHandler(w http.ResponseWriter, r *http.Request) {
+    defer r.Body.Close()
+
+    body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBodySize))
+    if err != nil {
+        var tooLarge *http.MaxBytesError
+        if errors.As(err, &tooLarge) {
+            http.Error(w, fmt.Sprintf("The batch must not exceed %d bytes", maxBatchBodySize), http.StatusRequestEntityTooLarge)
+            return
+        }
+        http.Error(w, "Failed to read the batch", http.StatusBadRequest)
+        return
+    }
+
+    // Split the batch, each receipt is decoded on its own so a malformed receipt only fails itself
+    var raws []json.RawMessage
+    if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-ndjson") {
+        raws, err = splitNDJSON(body)
+    } else {
+        err = json.Unmarshal(body, &raws)
+    }
+    if err != nil {
+        http.Error(w, "Invalid batch format, expected a JSON array or NDJSON", http.StatusBadRequest)
+        return
+    }
+    if len(raws) == 0 {
+        http.Error(w, "The batch is empty", http.StatusBadRequest)
+        return
+    }
+    if len(raws) > maxBatchSize {
+        http.Error(w, fmt.Sprintf("The batch must not have more than %d receipts", maxBatchSize), http.StatusRequestEntityTooLarge)
+        return
+    }
+
+    results := h.processBatch(raws)
+
+    response := BatchResponse{Results: results}
+    for _, result := range results {
+        if result.Error == "" {
+            response.Succeeded++
+        } else {
+            response.Failed++
+        }
+    }
+
+    // Return the per receipt results
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(response)
+}
+
+// processBatch
+// @Description    Decode and process the receipts of a batch with a bounded pool of workers.
+// @Param          raws: []json.RawMessage
+// @Return         results, in the batch order: []BatchResult
+func (h *Handler) processBatch(raws []json.RawMessage) []BatchResult {
+    results := make([]BatchResult, len(raws))
+    indexes := make(chan int)
+
+    var wg sync.WaitGroup
+    for worker := 0; worker < batchWorkers && worker < len(raws); worker++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            // each worker writes distinct elements of results, no lock needed
+            for index := range indexes {
+                results[index] = h.processBatchReceipt(index, raws[index])
+            }
+        }()
+    }
+    for index := range raws {
+        indexes <- index
+    }
+    close(indexes)
+    wg.Wait()
+
+    return results
+}
+
+// processBatchReceipt
+// @Description    Decode and process a single receipt of a batch.
+// @Param          index: int, raw: json.RawMessage
+// @Return         result: BatchResult
+func (h *Handler) processBatchReceipt(index int, raw json.RawMessage) BatchResult {
+    var receipt models.Receipt
+    if err := json.Unmarshal(raw, &receipt); err != nil {
+        return BatchResult{Index: index, Status: http.StatusBadRequest, Error: "Invalid JSON format"}
+    }
+
+    id, processErr := h.processReceipt(receipt, "")
+    if processErr != nil {
+        result := BatchResult{Index: index, Status: processErr.status, Error: processErr.message}
+        if processErr.validation != nil {
+            result.Errors = processErr.validation.Fields
+        }
+        return result
+    }
+    return BatchResult{Index: index, Status: http.StatusOK, ID: id}
+}
+
+// splitNDJSON
+// @Description    Split an NDJSON body into its lines, skipping the blank lines.
+// @Param          body: []byte
+// @Return         lines: []json.RawMessage, error: error
+func splitNDJSON(body []byte) ([]json.RawMessage, error) {
+    lines := []json.RawMessage{}
+    scanner := bufio.NewScanner(bytes.NewReader(body))
+    scanner.Buffer(make([]byte, 64*1024), maxBatchBodySize)
+    for scanner.Scan() {
+        line := bytes.TrimSpace(scanner.Bytes())
+        if len(line) == 0 {
+            continue
+        }
+        lines = append(lines, json.RawMessage(bytes.Clone(line)))
+    }
+    return lines, scanner.Err()
+}
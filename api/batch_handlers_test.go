@@ -0,0 +1,118 @@
+// api/batch_handlers_test.go
+// Tests for the batch receipt submission handler.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"receipt-processor/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// batchReceipt is a valid receipt for the batch tests, distinct for each n.
+func batchReceipt(n int) models.Receipt {
+	return models.Receipt{
+		Retailer:     fmt.Sprintf("Store %d", n),
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Total:        "1.25",
+		Items:        []models.Item{{ShortDescription: "Pepsi - 12-oz", Price: "1.25"}},
+	}
+}
+
+// postBatch sends a POST /receipts/batch request, and decodes the response on success.
+func postBatch(t *testing.T, handler http.Handler, contentType string, body string) (*httptest.ResponseRecorder, BatchResponse) {
+	req, _ := http.NewRequest("POST", "/receipts/batch", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", contentType)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var response BatchResponse
+	if rr.Code == http.StatusOK {
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	}
+	return rr, response
+}
+
+// Tests on ProcessBatchHandler function with a JSON array
+// expected: one result per receipt in the batch order, failures do not abort the batch
+func TestProcessBatchHandler(t *testing.T) {
+	router, store := setupRouterWithStore()
+
+	invalid := batchReceipt(1)
+	invalid.PurchaseTime = "1pm"
+	items := []string{}
+	for _, receipt := range []models.Receipt{batchReceipt(0), invalid, batchReceipt(2)} {
+		encoded, _ := json.Marshal(receipt)
+		items = append(items, string(encoded))
+	}
+	items = append(items, `"not a receipt"`, items[0]) // malformed receipt, and a duplicate
+
+	rr, response := postBatch(t, router, "application/json", "["+strings.Join(items, ",")+"]")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 3, response.Succeeded)
+	assert.Equal(t, 2, response.Failed)
+	assert.Len(t, response.Results, 5)
+	for i, result := range response.Results {
+		assert.Equal(t, i, result.Index)
+	}
+
+	id, _ := generateReceiptID(batchReceipt(0))
+	assert.Equal(t, BatchResult{Index: 0, Status: http.StatusOK, ID: id}, response.Results[0])
+	assert.Equal(t, http.StatusBadRequest, response.Results[1].Status)
+	assert.Contains(t, response.Results[1].Error, "The receipt is invalid")
+	if assert.Len(t, response.Results[1].Errors, 1) {
+		assert.Equal(t, "purchaseTime", response.Results[1].Errors[0].Field)
+		assert.Equal(t, models.CodeInvalidTime, response.Results[1].Errors[0].Code)
+	}
+	assert.Equal(t, http.StatusOK, response.Results[2].Status)
+	assert.Equal(t, BatchResult{Index: 3, Status: http.StatusBadRequest, Error: "Invalid JSON format"}, response.Results[3])
+	assert.Equal(t, id, response.Results[4].ID)
+
+	list, _ := store.ListReceipts()
+	assert.Len(t, list, 2)
+	data, _, _ := store.GetReceiptData(response.Results[2].ID)
+	assert.Equal(t, "Store 2", data.Receipt.Retailer)
+}
+
+// Tests on ProcessBatchHandler function with NDJSON
+// expected: every receipt is processed, even more receipts than workers
+func TestProcessBatchHandler_NDJSON(t *testing.T) {
+	router, store := setupRouterWithStore()
+
+	lines := []string{}
+	for i := 0; i < 3*batchWorkers; i++ {
+		encoded, _ := json.Marshal(batchReceipt(i))
+		lines = append(lines, string(encoded))
+	}
+	rr, response := postBatch(t, router, "application/x-ndjson", strings.Join(lines, "\n")+"\n\n")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 3*batchWorkers, response.Succeeded)
+	assert.Equal(t, 0, response.Failed)
+
+	list, _ := store.ListReceipts()
+	assert.Len(t, list, 3*batchWorkers)
+}
+
+// Tests on ProcessBatchHandler function with invalid batches
+// expected: the whole request is rejected
+func TestProcessBatchHandler_InvalidBatch(t *testing.T) {
+	router := setupRouter()
+
+	rr, _ := postBatch(t, router, "application/json", `{"retailer": "Target"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr, _ = postBatch(t, router, "application/json", `[{"retailer": "Target"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr, _ = postBatch(t, router, "application/json", `[]`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr, _ = postBatch(t, router, "application/json", "["+strings.Repeat("{},", maxBatchSize)+"{}]")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+}
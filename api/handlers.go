@@ -9,9 +9,12 @@ import (
     "crypto/sha256"
     "encoding/hex"
     "encoding/json"
+    "errors"
     "fmt"
     "net/http"
     "strings"
+    "sync"
+    "time"
 
     "receipt-processor/models"
     "receipt-processor/services"
@@ -21,9 +24,96 @@ import (
 )
 
 
+// Handler holds the dependencies of the API handlers.
+type Handler struct {
+    store         storage.ReceiptStore
+    normalization models.NormalizationPolicy // canonical form of the submitted receipts
+    ids           IDGenerator
+    idempotency   *IdempotencyStore     // Idempotency-Key of the submissions
+    ledger        *services.Ledger      // points of the users
+    tiers         *services.TierTracker // loyalty tiers of the users
+    changeMu      sync.Mutex            // serializes the reversals, deletions and erasures of the stored receipts
+}
+
+// NewHandler
+// @Description    Create the API handlers on top of the given receipt storage, with the default normalization policy.
+// @Param          store: storage.ReceiptStore
+// @Return         pointer to the handlers: *Handler
+func NewHandler(store storage.ReceiptStore) *Handler {
+    return &Handler{
+        store: store,
+        normalization: models.DefaultNormalizationPolicy(),
+        ids: SHA256IDGenerator{},
+        idempotency: NewIdempotencyStore(DefaultIdempotencyTTL),
+        ledger: services.NewLedger().WithRedemptionRecorder(redemptionRecorder(store)),
+        tiers: services.NewTierTracker(services.DefaultTierPolicy(), nil),
+    }
+}
+
+// WithLedger
+// @Description    Set the points ledger of the users (e.g. restored by RestoreAccounts).
+//                 The redemptions of the ledger are saved to the storage of the handlers from then on.
+// @Param          ledger: *services.Ledger
+// @Return         pointer to the handlers: *Handler
+func (h *Handler) WithLedger(ledger *services.Ledger) *Handler {
+    h.ledger = ledger.WithRedemptionRecorder(redemptionRecorder(h.store))
+    return h
+}
+
+// WithTiers
+// @Description    Set the loyalty tier tracker of the users (e.g. with a custom policy, or restored from the stored receipts).
+// @Param          tiers: *services.TierTracker
+// @Return         pointer to the handlers: *Handler
+func (h *Handler) WithTiers(tiers *services.TierTracker) *Handler {
+    h.tiers = tiers
+    return h
+}
+
+// WithNormalization
+// @Description    Set the normalization policy of the submitted receipts.
+//                 Changing the policy changes the IDs of the receipts that are not already in canonical form.
+// @Param          policy: models.NormalizationPolicy
+// @Return         pointer to the handlers: *Handler
+func (h *Handler) WithNormalization(policy models.NormalizationPolicy) *Handler {
+    h.normalization = policy
+    return h
+}
+
+// WithIDGenerator
+// @Description    Set the generator of the receipt IDs (e.g. to force hash collisions in tests).
+// @Param          ids: IDGenerator
+// @Return         pointer to the handlers: *Handler
+func (h *Handler) WithIDGenerator(ids IDGenerator) *Handler {
+    h.ids = ids
+    return h
+}
+
+// WithIdempotencyTTL
+// @Description    Set the time an idempotency key is kept after its first request (the known keys are dropped).
+// @Param          ttl: time.Duration
+// @Return         pointer to the handlers: *Handler
+func (h *Handler) WithIdempotencyTTL(ttl time.Duration) *Handler {
+    h.idempotency = NewIdempotencyStore(ttl)
+    return h
+}
+
+
 // ValidationError represents an error that occurs during validation.
 type ValidationError struct {
     Message string
+    Fields  models.FieldErrors // invalid fields of the receipt
+}
+
+// invalidReceiptProblemType identifies the RFC 7807 problems reporting invalid receipts.
+const invalidReceiptProblemType = "/problems/invalid-receipt"
+
+// Problem is an RFC 7807 problem details response body (application/problem+json).
+type Problem struct {
+    Type   string             `json:"type"`
+    Title  string             `json:"title"`
+    Status int                `json:"status"`
+    Detail string             `json:"detail,omitempty"`
+    Errors models.FieldErrors `json:"errors,omitempty"` // extension member: the invalid fields
 }
 
 // Error
@@ -34,12 +124,80 @@ func (e *ValidationError) Error() string {
     return e.Message
 }
 
+// validateReceipt
+// @Description    Check every field of a receipt up front.
+// @Param          receipt: *models.Receipt
+// @Return         error: *ValidationError (nil if the receipt is valid)
+func validateReceipt(receipt *models.Receipt) *ValidationError {
+    fields := receipt.Validate()
+    if fields == nil {
+        return nil
+    }
+    return &ValidationError{Message: "The receipt is invalid", Fields: fields}
+}
+
+// scoringValidationError
+// @Description    Get the validation error of a scoring error, if the receipt was refused by the checks run before the rules
+//                 (e.g. a total not matching the sum of the item prices).
+// @Param          err: error
+// @Return         error: *ValidationError (nil if the scoring failed for another reason)
+func scoringValidationError(err error) *ValidationError {
+    var fields models.FieldErrors
+    if !errors.As(err, &fields) {
+        return nil
+    }
+    return &ValidationError{Message: "The receipt is invalid", Fields: fields}
+}
+
+// writeValidationProblem
+// @Description    Write a validation error as an RFC 7807 problem+json response (400 Bad Request), listing the invalid fields.
+// @Param          w: http.ResponseWriter, validationErr: *ValidationError
+// @Return         none
+func writeValidationProblem(w http.ResponseWriter, validationErr *ValidationError) {
+    w.Header().Set("Content-Type", "application/problem+json")
+    w.WriteHeader(http.StatusBadRequest)
+    json.NewEncoder(w).Encode(Problem{
+        Type:   invalidReceiptProblemType,
+        Title:  validationErr.Message,
+        Status: http.StatusBadRequest,
+        Detail: validationErr.Fields.Error(),
+        Errors: validationErr.Fields,
+    })
+}
+
+// PointsResponse is the response body of the GET /receipts/{id}/points endpoint.
+type PointsResponse struct {
+    Points         int64  `json:"points"`         // net points, the same as netPoints
+    OriginalPoints int64  `json:"originalPoints"` // points when the receipt was processed
+    NetPoints      int64  `json:"netPoints"`      // original points less the points taken back by the reversals
+    RuleVersion    string `json:"ruleVersion"`
+}
+
+// BreakdownResponse is the response body of the GET /receipts/{id}/breakdown endpoint.
+type BreakdownResponse struct {
+    Points      int64                         `json:"points"`
+    RuleVersion string                        `json:"ruleVersion"`
+    Breakdown   []models.PointsBreakdownEntry `json:"breakdown"`
+}
+
+// ReceiptResponse is the response body of the GET /receipts/{id} endpoint: the stored copy of the receipt.
+type ReceiptResponse struct {
+    ID          string             `json:"id"`
+    Receipt     models.Receipt     `json:"receipt"`
+    Points      int64              `json:"points"` // original points, see the reversals
+    RuleVersion string             `json:"ruleVersion"`
+    ProcessedAt time.Time          `json:"processedAt"`
+    Reversals   []storage.Reversal `json:"reversals,omitempty"`
+}
+
 
 // ProcessReceiptHandler
 // @Description    Handle the POST /receipts/process endpoint.
+//                 With an Idempotency-Key header, the receipt is deduplicated on the key instead of its content,
+//                 and the requests repeating the key get the original response (see idempotency.go).
 // @Param          w: http.ResponseWriter, r: *http.Request
 // @Return         none
-func ProcessReceiptHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) ProcessReceiptHandler(w http.ResponseWriter, r *http.Request) {
     defer r.Body.Close()
 
 	// Decode the JSON request body
@@ -50,87 +208,347 @@ func ProcessReceiptHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Generate receipt ID based on content
-    id, err := generateReceiptID(receipt)
-    if err != nil {
-        http.Error(w, "Error generating receipt ID", http.StatusInternalServerError)
+    key := r.Header.Get(IdempotencyKeyHeader)
+    if key == "" {
+        h.writeProcessResult(w, receipt, "")
+        return
+    }
+    if !validIdempotencyKey(key) {
+        http.Error(w, fmt.Sprintf("The Idempotency-Key must have 1 to %d printable ASCII characters", maxIdempotencyKeyLength), http.StatusBadRequest)
         return
     }
+    h.processIdempotent(w, receipt, key)
+}
+
+// writeProcessResult
+// @Description    Process a receipt and write the response of the POST /receipts/process endpoint.
+// @Param          w: http.ResponseWriter, receipt: models.Receipt, key: string (idempotency key, optional)
+// @Return         receipt ID (empty on failure): string
+func (h *Handler) writeProcessResult(w http.ResponseWriter, receipt models.Receipt, key string) string {
+    // Process the receipt
+    id, processErr := h.processReceipt(receipt, key)
+    if processErr != nil {
+        if processErr.validation != nil {
+            writeValidationProblem(w, processErr.validation)
+            return ""
+        }
+        http.Error(w, processErr.message, processErr.status)
+        return ""
+    }
+
+    // Return the ID
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(map[string]string{"id": id})
+    return id
+}
+
 
-    store := storage.GetStorageInstance()
+// processError is an error of processReceipt, with the HTTP status it maps to.
+type processError struct {
+    status     int
+    message    string
+    validation *ValidationError // set if the receipt failed the validation
+}
+
+// processReceipt
+// @Description    Process a receipt: generate its ID, score it and store it, unless it is already stored.
+//                 The receipt is validated, scored and stored as submitted; only its canonical form (see models.Receipt.Normalize)
+//                 is hashed to the ID and compared, so that formatting differences do not change the points.
+//                 With an idempotency key, the ID is derived from the key as well, so that identical contents submitted
+//                 with distinct keys are distinct receipts.
+//                 Shared by the single and the batch submission endpoints.
+// @Param          receipt: models.Receipt, key: string (idempotency key, optional)
+// @Return         receipt ID: string, error: *processError (nil on success)
+func (h *Handler) processReceipt(receipt models.Receipt, key string) (string, *processError) {
+    // Check every field up front
+    if validationErr := validateReceipt(&receipt); validationErr != nil {
+        return "", &processError{status: http.StatusBadRequest, message: validationErr.Error(), validation: validationErr}
+    }
 
-    // Check if the receipt already exists - avoiding duplicate processing
-    existingReceipt, exists := store.GetReceiptData(id)
-	// If the receipt already exists, return the existing ID
+    // Generate receipt ID based on content, resolving the hash collisions
+    ids := h.ids
+    if key != "" {
+        ids = keyedIDGenerator{base: h.ids, key: key}
+    }
+    id, exists, processErr := h.resolveReceiptID(receipt, ids)
+    if processErr != nil {
+        return "", processErr
+    }
+    // If the receipt already exists, return the existing ID - avoiding duplicate processing
     if exists {
-		// if ID exists but the receipt data is different, return an conflict (hash collision) error
-		// TODO: rare, might not be necessary.
-		if !existingReceipt.Receipt.Equals(&receipt) {
-			http.Error(w, "Hash collision detected, please try again", http.StatusConflict)
-			return
-		}
-
-        // Receipt already processed, return existing ID
-        w.Header().Set("Content-Type", "application/json")
-        w.WriteHeader(http.StatusOK)
-        json.NewEncoder(w).Encode(map[string]string{"id": id})
-        return
+        return id, nil
     }
 
     // If the receipt does not exist, calculate the points
-    points, err := services.CalculateTotalPoints(&receipt)
+    result, err := services.ScoreReceipt(&receipt)
     if err != nil {
+        if validationErr := scoringValidationError(err); validationErr != nil {
+            return "", &processError{status: http.StatusBadRequest, message: validationErr.Error(), validation: validationErr}
+        }
         // If calculation fails, assume receipt is invalid
+        return "", &processError{status: http.StatusBadRequest, message: fmt.Sprintf("The receipt is invalid: %v", err)}
+    }
+
+    // Apply the loyalty tier of the user on top of the base points, the receipt counts for the tier once it is stored
+    processedAt := time.Now().UTC()
+    accruedAt := services.AccrualDate(&receipt, processedAt)
+    if receipt.UserID != "" {
+        if result, err = h.tiers.Quote(receipt.UserID, id, accruedAt, result); err != nil {
+            return "", &processError{status: http.StatusInternalServerError, message: "Error applying the loyalty tier"}
+        }
+    }
+
+    // Store the receipt and points
+    err = h.store.SaveReceipt(id, storage.ReceiptData{
+        Receipt: receipt,
+        Points: result.Points,
+        Breakdown: result.Breakdown,
+        RuleVersion: result.RuleVersion,
+        ProcessedAt: processedAt,
+    })
+    if err != nil {
+        return "", &processError{status: http.StatusInternalServerError, message: "Error saving the receipt"}
+    }
+
+    // Count the receipt for the tier and credit the points to the user, at most once per receipt (concurrent duplicates included)
+    if receipt.UserID != "" {
+        h.tiers.Record(receipt.UserID, id, accruedAt, result, processedAt)
+        if _, _, err := h.ledger.Credit(receipt.UserID, id, result.Points, accruedAt, processedAt); err != nil {
+            return "", &processError{status: http.StatusInternalServerError, message: "Error crediting the points"}
+        }
+    }
+    return id, nil
+}
+
+
+// ScoreReceiptHandler
+// @Description    Handle the POST /receipts/score endpoint: validate and score a receipt without storing it (dry run).
+//                 Used to preview the points before submitting a receipt, or to test rule changes.
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) ScoreReceiptHandler(w http.ResponseWriter, r *http.Request) {
+    defer r.Body.Close()
+
+    // Decode the JSON request body
+    var receipt models.Receipt
+    err := json.NewDecoder(r.Body).Decode(&receipt)
+    if err != nil {
+        http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+        return
+    }
+
+    // Check every field up front
+    if validationErr := validateReceipt(&receipt); validationErr != nil {
+        writeValidationProblem(w, validationErr)
+        return
+    }
+
+    // Calculate the points, the receipt is not saved to the storage
+    result, err := services.ScoreReceipt(&receipt)
+    if err != nil {
+        if validationErr := scoringValidationError(err); validationErr != nil {
+            writeValidationProblem(w, validationErr)
+            return
+        }
         http.Error(w, fmt.Sprintf("The receipt is invalid: %v", err), http.StatusBadRequest)
         return
     }
 
-    // Store the receipt and points
-    store.SaveReceipt(id, receipt, points)
+    // Return the points and their breakdown
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(BreakdownResponse{
+        Points: result.Points,
+        RuleVersion: result.RuleVersion,
+        Breakdown: result.Breakdown,
+    })
+}
 
-    // Return the ID
+
+// GetReceiptHandler
+// @Description    Handle the GET /receipts/{id} endpoint: return the stored receipt with its points.
+//                 The response has an ETag, a request with a matching If-None-Match header gets 304 Not Modified.
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) GetReceiptHandler(w http.ResponseWriter, r *http.Request) {
+    // Retrieve the receipt data
+    data, ok := h.lookupReceipt(w, mux.Vars(r)["id"])
+    if !ok {
+        return
+    }
+
+    body, err := json.Marshal(ReceiptResponse{
+        ID: data.ID,
+        Receipt: data.Receipt,
+        Points: data.Points,
+        RuleVersion: data.RuleVersion,
+        ProcessedAt: data.ProcessedAt,
+        Reversals: data.Reversals,
+    })
+    if err != nil {
+        http.Error(w, "Error encoding the receipt", http.StatusInternalServerError)
+        return
+    }
+
+    // The ETag is a hash of the response, it changes whenever the stored copy changes (e.g. re-scored)
+    hash := sha256.Sum256(body)
+    etag := `"` + hex.EncodeToString(hash[:16]) + `"`
+    w.Header().Set("ETag", etag)
+    if matchesETag(r.Header.Get("If-None-Match"), etag) {
+        w.WriteHeader(http.StatusNotModified)
+        return
+    }
+
+    // Return the receipt
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(http.StatusOK)
-    json.NewEncoder(w).Encode(map[string]string{"id": id})
+    w.Write(append(body, '\n'))
 }
 
 
 // GetPointsHandler
-// @Description    Handle the GET /receipts/{id}/points endpoint.
+// @Description    Handle the GET /receipts/{id}/points endpoint: the original points of the receipt, and its net points after its reversals.
 // @Param          w: http.ResponseWriter, r: *http.Request
 // @Return         none
-func GetPointsHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) GetPointsHandler(w http.ResponseWriter, r *http.Request) {
 	// vars returns the route variables for the current request, if any.
     vars := mux.Vars(r)
-    id := vars["id"]
 
-    if id == "" || strings.TrimSpace(id) == "" {
-        http.Error(w, "The ID of the receipt is required", http.StatusBadRequest)
+    // Retrieve the receipt data
+    data, ok := h.lookupReceipt(w, vars["id"])
+    if !ok {
         return
     }
 
+    // Return the points
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(PointsResponse{
+        Points: data.NetPoints(),
+        OriginalPoints: data.Points,
+        NetPoints: data.NetPoints(),
+        RuleVersion: data.RuleVersion,
+    })
+}
+
+
+// GetBreakdownHandler
+// @Description    Handle the GET /receipts/{id}/breakdown endpoint, explaining the points per rule.
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) GetBreakdownHandler(w http.ResponseWriter, r *http.Request) {
     // Retrieve the receipt data
-    data, exists := storage.GetStorageInstance().GetReceiptData(id)
-    if !exists {
-        http.Error(w, "No receipt found for that id", http.StatusNotFound)
+    data, ok := h.lookupReceipt(w, mux.Vars(r)["id"])
+    if !ok {
         return
     }
 
-    // Return the points
+    // Return the points and their breakdown
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(http.StatusOK)
-    json.NewEncoder(w).Encode(map[string]int64{"points": data.Points})
+    json.NewEncoder(w).Encode(BreakdownResponse{
+        Points: data.Points,
+        RuleVersion: data.RuleVersion,
+        Breakdown: data.Breakdown,
+    })
+}
+
+// DeleteReceiptHandler
+// @Description    Handle the DELETE /receipts/{id} endpoint: erase the receipt, leaving a tombstone without personal data.
+//                 The points of the receipt are taken back from its user (a deletion entry of the ledger), and it no longer
+//                 counts for their tier.
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) DeleteReceiptHandler(w http.ResponseWriter, r *http.Request) {
+    // A reversal in progress is saved before the receipt is erased, not after
+    h.changeMu.Lock()
+    defer h.changeMu.Unlock()
+
+    // Check that the receipt exists (or report that it is already deleted)
+    data, ok := h.lookupReceipt(w, mux.Vars(r)["id"])
+    if !ok {
+        return
+    }
+
+    // Erase the receipt. The storage may report an error after erasing it (e.g. a failed compaction of the file
+    // storage): the receipt is then cleaned up before reporting it, as a retry no longer finds it
+    tombstones, err := h.store.EraseReceipts([]string{data.ID}, storage.TombstoneDeleted, time.Now())
+    if len(tombstones) > 0 {
+        h.idempotency.ForgetReceipts(data.ID)
+
+        // Take the points of the receipt back from its user, as the accounts rebuilt at startup no longer count it
+        if data.Receipt.UserID != "" {
+            h.ledger.RemoveReceipt(data.Receipt.UserID, data.ID, time.Now().UTC())
+            h.tiers.Forget(data.Receipt.UserID, data.ID)
+        }
+    }
+    if err != nil {
+        http.Error(w, "Error deleting the receipt", http.StatusInternalServerError)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+
+// lookupReceipt
+// @Description    Retrieve a stored receipt for the /receipts/{id} endpoints, or write the error response:
+//                 400 for an empty ID, 410 for an erased receipt, 404 for an unknown receipt, 500 for a storage error.
+// @Param          w: http.ResponseWriter, id: string
+// @Return         receipt data: storage.ReceiptData, true if found (false if the error response is written): bool
+func (h *Handler) lookupReceipt(w http.ResponseWriter, id string) (storage.ReceiptData, bool) {
+    if id == "" || strings.TrimSpace(id) == "" {
+        http.Error(w, "The ID of the receipt is required", http.StatusBadRequest)
+        return storage.ReceiptData{}, false
+    }
+
+    data, exists, err := h.store.GetReceiptData(id)
+    if err != nil {
+        http.Error(w, "Error accessing the storage", http.StatusInternalServerError)
+        return data, false
+    }
+    if exists {
+        return data, true
+    }
+
+    _, deleted, err := h.store.GetTombstone(id)
+    if err != nil {
+        http.Error(w, "Error accessing the storage", http.StatusInternalServerError)
+        return data, false
+    }
+    if deleted {
+        http.Error(w, "The receipt has been deleted", http.StatusGone)
+        return data, false
+    }
+    http.Error(w, "No receipt found for that id", http.StatusNotFound)
+    return data, false
+}
+
+// matchesETag
+// @Description    Check an If-None-Match header against the ETag of the current response (weak comparison, RFC 9110).
+// @Param          header: string (list of entity tags, or *), etag: string
+// @Return         true if the client copy is up to date, false otherwise: bool
+func matchesETag(header string, etag string) bool {
+    for _, candidate := range strings.Split(header, ",") {
+        candidate = strings.TrimSpace(candidate)
+        if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+            return true
+        }
+    }
+    return false
 }
 
 // generateReceiptID
 // @Description    Generates a unique ID for the receipt based on its content.
 //                 The ID is generated by hashing (SHA256) the receipt content to prevent duplicates. (instead of using UUID)
+//                 The receipt must already be normalized (see models.Receipt.Normalize), so that formatting differences
+//                 (whitespace, item order...) do not produce different IDs.
 // @Param          receipt: models.Receipt
 // @Return         receipt ID: string, error: error
 //generates a unique ID for the receipt based on its content.
 func generateReceiptID(receipt models.Receipt) (string, error) {
-    // Marshal the receipt to JSON bytes
-	// TODO: can preprocess the receipt like normalizing before hashing if needed
+    // Marshal the (normalized) receipt to JSON bytes
     receiptBytes, err := json.Marshal(receipt)
     if err != nil {
         return "", err
@@ -6,22 +6,91 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"receipt-processor/models"
+	"receipt-processor/services"
+	"receipt-processor/storage"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// setupRouter sets up the router on top of a new, empty in-memory storage.
 func setupRouter() *mux.Router {
-    router := mux.NewRouter()
-    SetupRouter(router)
+    router, _ := setupRouterWithStore()
     return router
 }
 
+// setupRouterWithStore sets up the router and returns its storage, for tests that need to inspect or seed it.
+func setupRouterWithStore() (*mux.Router, *storage.MemoryStore) {
+    store := storage.NewMemoryStore()
+    router := mux.NewRouter()
+    SetupRouter(router, NewHandler(store))
+    return router, store
+}
+
+// failingStore is a memory storage whose saves fail while fail is set.
+type failingStore struct {
+	*storage.MemoryStore
+	mu   sync.Mutex
+	fail bool
+}
+
+func (s *failingStore) setFail(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = fail
+}
+
+func (s *failingStore) SaveReceipt(id string, data storage.ReceiptData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("disk full")
+	}
+	return s.MemoryStore.SaveReceipt(id, data)
+}
+
+func (s *failingStore) SaveRedemption(redemption storage.Redemption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("disk full")
+	}
+	return s.MemoryStore.SaveRedemption(redemption)
+}
+
+// EraseReceipts erases the receipts, then reports an error while fail is set (as a failed compaction of the file storage).
+func (s *failingStore) EraseReceipts(ids []string, reason string, at time.Time) ([]storage.Tombstone, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tombstones, err := s.MemoryStore.EraseReceipts(ids, reason, at)
+	if err == nil && s.fail {
+		err = errors.New("disk full")
+	}
+	return tombstones, err
+}
+
+// processReceipt submits a receipt to POST /receipts/process and returns its ID.
+func processReceipt(t *testing.T, router *mux.Router, receipt models.Receipt) string {
+    requestBody, _ := json.Marshal(receipt)
+    req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(requestBody))
+    rr := httptest.NewRecorder()
+    router.ServeHTTP(rr, req)
+    assert.Equal(t, http.StatusOK, rr.Code)
+
+    var response map[string]string
+    assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+    return response["id"]
+}
+
 // Test on ProcessReceiptHandler function
 // 1. general case (using example receipt) - 200 OK
 func TestProcessReceiptHandler(t *testing.T) {
@@ -70,8 +139,41 @@ func TestProcessReceiptHandlerInvalidJSON(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, rr.Code)
 }
 
-// 3. hash collision - 409 Conflict
-// TODO: hard to test seperately if not refactoring the code
+// 3. hash collision - resolved with the next ID of the receipt (see ids_test.go)
+func TestProcessReceiptHandlerHashCollision(t *testing.T) {
+	router, store := setupRouterWithStore()
+
+	receipt := models.Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Total:        "2.25",
+		Items: []models.Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+	}
+
+	// seed a different receipt under the ID of the submitted receipt
+	id, err := generateReceiptID(receipt)
+	assert.NoError(t, err)
+	other := receipt
+	other.Retailer = "Target"
+	assert.NoError(t, store.SaveReceipt(id, storage.ReceiptData{Receipt: other, Points: 1}))
+
+	// the receipt is stored under its salted ID, and keeps it when submitted again
+	salted, err := SHA256IDGenerator{}.GenerateID(receipt, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, salted, processReceipt(t, router, receipt))
+	assert.Equal(t, salted, processReceipt(t, router, receipt))
+
+	// the stored receipt is left untouched
+	data, found, err := store.GetReceiptData(id)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "Target", data.Receipt.Retailer)
+	data, _, _ = store.GetReceiptData(salted)
+	assert.Equal(t, "M&M Corner Market", data.Receipt.Retailer)
+}
 
 // 4. invalid receipt - 400 Bad Request
 func TestProcessReceiptHandlerInvalidReceipt(t *testing.T) {
@@ -112,6 +214,167 @@ func TestProcessReceiptHandlerInvalidReceipt(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, rr.Code)
 }
 
+// Tests on ProcessReceiptHandler function with many invalid fields
+// expected: 400 with an application/problem+json body listing every invalid field
+func TestProcessReceiptHandler_ValidationProblem(t *testing.T) {
+	router := setupRouter()
+
+	receipt := models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-02-30",
+		PurchaseTime: "13:01",
+		Total:        "6.49",
+		Items: []models.Item{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+			{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+			{ShortDescription: "Knorr Creamy Chicken", Price: "1.2"},
+		},
+	}
+	requestBody, _ := json.Marshal(receipt)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+
+	var problem Problem
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+	assert.Equal(t, invalidReceiptProblemType, problem.Type)
+	assert.Equal(t, http.StatusBadRequest, problem.Status)
+	assert.Equal(t, models.FieldErrors{
+		{Field: "purchaseDate", Code: models.CodeInvalidDate, Message: "the purchase date must be a date formatted as YYYY-MM-DD"},
+		{Field: "items[2].price", Code: models.CodeInvalidAmount, Message: "the amount must have 2 decimals, e.g. 6.49"},
+	}, problem.Errors)
+
+	// the score endpoint reports the same problem
+	req, _ = http.NewRequest("POST", "/receipts/score", bytes.NewBuffer(requestBody))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+}
+
+// Tests on ProcessReceiptHandler function with the strict total check enabled
+// expected: a total not matching the sum of the item prices is refused with a total_mismatch problem
+func TestProcessReceiptHandler_TotalMismatch(t *testing.T) {
+	registry := services.GetRuleRegistry()
+	previous := registry.Active()
+	config := services.DefaultRuleConfig()
+	config.Version = "test-total-check"
+	config.TotalCheck = &services.TotalCheckConfig{Mode: "strict"}
+	set, err := config.BuildRuleSet()
+	assert.NoError(t, err)
+	assert.NoError(t, registry.Activate(set))
+	t.Cleanup(func() {
+		require.NoError(t, registry.Activate(previous))
+	})
+
+	router := setupRouter()
+	receipt := models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Total:        "2.00", // the item costs 1.25
+		Items:        []models.Item{{ShortDescription: "Pepsi - 12-oz", Price: "1.25"}},
+	}
+	requestBody, _ := json.Marshal(receipt)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(requestBody))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+	var problem Problem
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+	if assert.Len(t, problem.Errors, 1) {
+		assert.Equal(t, "total", problem.Errors[0].Field)
+		assert.Equal(t, models.CodeTotalMismatch, problem.Errors[0].Code)
+	}
+
+	receipt.Total = "1.25"
+	processReceipt(t, router, receipt)
+}
+
+// Tests on ProcessReceiptHandler function with receipts differing only by their formatting
+// expected: the same ID, and the receipt is stored as first submitted
+func TestProcessReceiptHandler_Normalization(t *testing.T) {
+	router, store := setupRouterWithStore()
+
+	receipt := models.Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Total:        "4.50",
+		Items: []models.Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Dasani", Price: "2.25"},
+		},
+	}
+	id := processReceipt(t, router, receipt)
+
+	reformatted := receipt
+	reformatted.Retailer = " M&M  Corner Market"
+	reformatted.Items = []models.Item{
+		{ShortDescription: "Dasani ", Price: "02.25"},
+		{ShortDescription: " Gatorade", Price: "2.25"},
+	}
+	assert.Equal(t, id, processReceipt(t, router, reformatted))
+
+	data, _, _ := store.GetReceiptData(id)
+	assert.Equal(t, "M&M Corner Market", data.Receipt.Retailer)
+	assert.Equal(t, receipt.Items, data.Receipt.Items)
+
+	// the formatting of a receipt submitted first is kept as well
+	later := reformatted
+	later.PurchaseTime = "14:34"
+	data, _, _ = store.GetReceiptData(processReceipt(t, router, later))
+	assert.Equal(t, later.Retailer, data.Receipt.Retailer)
+	assert.Equal(t, later.Items, data.Receipt.Items)
+
+	// with the case policy, the case does not change the ID either
+	store = storage.NewMemoryStore()
+	router = mux.NewRouter()
+	policy := models.DefaultNormalizationPolicy()
+	policy.Case = models.CaseLower
+	SetupRouter(router, NewHandler(store).WithNormalization(policy))
+	reformatted.Retailer = "m&m corner MARKET"
+	assert.Equal(t, processReceipt(t, router, receipt), processReceipt(t, router, reformatted))
+}
+
+// Tests on the points of a receipt whose normalization would change them
+// expected: the receipt is scored as submitted, collapsing "Mountain  Dew 12PK" (19 characters) to 18 characters
+// would earn the item description points
+func TestProcessReceiptHandler_NormalizationKeepsPoints(t *testing.T) {
+	router, store := setupRouterWithStore()
+	receipt := models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Total:        "6.49",
+		Items:        []models.Item{{ShortDescription: "Mountain  Dew 12PK", Price: "6.49"}},
+	}
+	expected, err := services.CalculateTotalPoints(&receipt)
+	assert.NoError(t, err)
+	normalized := receipt.Normalize(models.DefaultNormalizationPolicy())
+	collapsed, err := services.CalculateTotalPoints(&normalized)
+	assert.NoError(t, err)
+	assert.NotEqual(t, expected, collapsed)
+
+	data, _, _ := store.GetReceiptData(processReceipt(t, router, receipt))
+	assert.Equal(t, expected, data.Points)
+	assert.Equal(t, receipt.Items, data.Receipt.Items)
+
+	body, _ := json.Marshal(receipt)
+	req, _ := http.NewRequest("POST", "/receipts/score", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var response BreakdownResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, expected, response.Points)
+}
+
 // Tests on GetReceiptHandler function
 // simplifying by using the same processed receipt
 func TestGetReceiptHandler(t *testing.T) {
@@ -149,12 +412,15 @@ func TestGetReceiptHandler(t *testing.T) {
     router.ServeHTTP(rr, req)
     assert.Equal(t, http.StatusOK, rr.Code)
 
-    var pointsResponse map[string]int64
+    var pointsResponse map[string]interface{}
     err := json.Unmarshal(rr.Body.Bytes(), &pointsResponse)
     assert.NoError(t, err)
     points, exists := pointsResponse["points"]
     assert.True(t, exists)
-    assert.Equal(t, int64(109), points)
+    assert.Equal(t, float64(109), points)
+    ruleVersion, exists := pointsResponse["ruleVersion"]
+    assert.True(t, exists)
+    assert.Equal(t, services.GetRuleRegistry().Active().Version, ruleVersion)
 
 	// 2. unexisting ID - 404 Not Found
 	req, _ = http.NewRequest("GET", "/receipts/0/points", nil)
@@ -167,4 +433,219 @@ func TestGetReceiptHandler(t *testing.T) {
 	rr = httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
 	assert.Equal(t, http.StatusNotFound, rr.Code)
-}
\ No newline at end of file
+}
+
+// Tests on GetBreakdownHandler function
+func TestGetBreakdownHandler(t *testing.T) {
+	router := setupRouter()
+
+	receipt := models.Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Total:        "9.00",
+		Items: []models.Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+	}
+
+	requestBody, _ := json.Marshal(receipt)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	var response map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	id := response["id"]
+
+	// 1. general case - 200 OK
+	req, _ = http.NewRequest("GET", "/receipts/"+id+"/breakdown", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var breakdownResponse BreakdownResponse
+	err := json.Unmarshal(rr.Body.Bytes(), &breakdownResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(109), breakdownResponse.Points)
+	assert.Equal(t, services.GetRuleRegistry().Active().Version, breakdownResponse.RuleVersion)
+
+	var sum int64 = 0
+	for _, entry := range breakdownResponse.Breakdown {
+		assert.NotEmpty(t, entry.RuleID)
+		sum += entry.Points
+	}
+	assert.Equal(t, int64(109), sum)
+
+	// 2. unexisting ID - 404 Not Found
+	req, _ = http.NewRequest("GET", "/receipts/0/breakdown", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+// Tests on ScoreReceiptHandler function
+func TestScoreReceiptHandler(t *testing.T) {
+	router := setupRouter()
+
+	// a receipt not used by the other tests, so that it cannot already be stored
+	receipt := models.Receipt{
+		Retailer:     "Dry Run Market",
+		PurchaseDate: "2022-03-21",
+		PurchaseTime: "10:00",
+		Total:        "4.50",
+		Items: []models.Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+	}
+
+	// 1. general case - 200 OK with points and breakdown
+	requestBody, _ := json.Marshal(receipt)
+	req, _ := http.NewRequest("POST", "/receipts/score", bytes.NewBuffer(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var scoreResponse BreakdownResponse
+	err := json.Unmarshal(rr.Body.Bytes(), &scoreResponse)
+	assert.NoError(t, err)
+	// 12 (retailer) + 25 (multiple of 0.25) + 5 (one pair) + 6 (odd day)
+	assert.Equal(t, int64(48), scoreResponse.Points)
+	assert.NotEmpty(t, scoreResponse.RuleVersion)
+	assert.NotEmpty(t, scoreResponse.Breakdown)
+
+	// 2. the receipt is not stored
+	id, _ := generateReceiptID(receipt)
+	req, _ = http.NewRequest("GET", "/receipts/"+id+"/points", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// 3. invalid JSON format - 400 Bad Request
+	req, _ = http.NewRequest("POST", "/receipts/score", bytes.NewBuffer([]byte("invalid json")))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// 4. invalid receipt - 400 Bad Request
+	requestBody, _ = json.Marshal(models.Receipt{})
+	req, _ = http.NewRequest("POST", "/receipts/score", bytes.NewBuffer(requestBody))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+// Tests on GetReceiptHandler function (stored copy of the receipt)
+func TestGetFullReceiptHandler(t *testing.T) {
+	router, store := setupRouterWithStore()
+
+	receipt := models.Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Total:        "9.00",
+		Items: []models.Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+	}
+	requestBody, _ := json.Marshal(receipt)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(requestBody))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var processResponse map[string]string
+	json.Unmarshal(rr.Body.Bytes(), &processResponse)
+	id := processResponse["id"]
+
+	// 1. general case - 200 OK with the stored receipt and an ETag
+	req, _ = http.NewRequest("GET", "/receipts/"+id, nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	etag := rr.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	var response ReceiptResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, id, response.ID)
+	assert.Equal(t, receipt, response.Receipt)
+	assert.Equal(t, int64(109), response.Points)
+	assert.Equal(t, services.GetRuleRegistry().Active().Version, response.RuleVersion)
+	assert.WithinDuration(t, time.Now(), response.ProcessedAt, time.Minute)
+
+	// 2. matching If-None-Match - 304 Not Modified without body
+	for _, header := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		req, _ = http.NewRequest("GET", "/receipts/"+id, nil)
+		req.Header.Set("If-None-Match", header)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotModified, rr.Code, header)
+		assert.Empty(t, rr.Body.String())
+		assert.Equal(t, etag, rr.Header().Get("ETag"))
+	}
+
+	// 3. the stored copy changes - 200 OK with a new ETag
+	data, _, _ := store.GetReceiptData(id)
+	data.Points = 110
+	store.SaveReceipt(id, data)
+	req, _ = http.NewRequest("GET", "/receipts/"+id, nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEqual(t, etag, rr.Header().Get("ETag"))
+
+	// 4. unknown receipt - 404 Not Found
+	req, _ = http.NewRequest("GET", "/receipts/unknown", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+// Tests on DeleteReceiptHandler function
+func TestDeleteReceiptHandler(t *testing.T) {
+	router, store := setupRouterWithStore()
+	id := processReceipt(t, router, models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Total:        "1.25",
+		Items:        []models.Item{{ShortDescription: "Pepsi - 12-oz", Price: "1.25"}},
+		UserID:       "user-1",
+	})
+
+	// 1. general case - 204 No Content, a tombstone is left
+	req, _ := http.NewRequest("DELETE", "/receipts/"+id, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	tombstone, found, _ := store.GetTombstone(id)
+	assert.True(t, found)
+	assert.Equal(t, storage.TombstoneDeleted, tombstone.Reason)
+
+	// 2. the receipt is gone - 410 Gone on every endpoint, including a second delete
+	for _, request := range []struct{ method, path string }{
+		{"GET", "/receipts/" + id}, {"GET", "/receipts/" + id + "/points"},
+		{"GET", "/receipts/" + id + "/breakdown"}, {"DELETE", "/receipts/" + id},
+	} {
+		req, _ = http.NewRequest(request.method, request.path, nil)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusGone, rr.Code, request.path)
+	}
+
+	// 3. unknown receipt - 404 Not Found
+	req, _ = http.NewRequest("DELETE", "/receipts/unknown", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,268 @@
+// api/idempotency.go
+// Idempotency-Key support of the receipt submission: deduplication keyed on a client key, and replay of the original response.
+
+package api
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "net/http"
+    "sync"
+    "time"
+
+    "receipt-processor/models"
+)
+
+const (
+    // IdempotencyKeyHeader is the request header carrying the idempotency key of a submission.
+    IdempotencyKeyHeader = "Idempotency-Key"
+    // IdempotentReplayedHeader is set to "true" on the responses replayed for an idempotency key.
+    IdempotentReplayedHeader = "Idempotent-Replayed"
+    // DefaultIdempotencyTTL is the default time an idempotency key is kept after the first request.
+    DefaultIdempotencyTTL = 24 * time.Hour
+    // maxIdempotencyKeyLength is the maximum length of an idempotency key.
+    maxIdempotencyKeyLength = 255
+    // idempotencySweepInterval is the minimum interval between two sweeps of the expired keys.
+    idempotencySweepInterval = time.Minute
+)
+
+// idempotencyState is the state of an idempotency key when a request starts.
+type idempotencyState int
+
+const (
+    idempotencyStarted    idempotencyState = iota // new key, the request must be processed
+    idempotencyReplay                             // key already used with the same receipt, the response must be replayed
+    idempotencyMismatch                           // key already used with a different receipt
+    idempotencyInProgress                         // key used by a request still being processed
+)
+
+// recordedResponse is a response kept for the replays of an idempotency key.
+type recordedResponse struct {
+    status int
+    header http.Header
+    body   []byte
+}
+
+// idempotencyEntry is the record of an idempotency key.
+type idempotencyEntry struct {
+    fingerprint string            // hash of the canonical receipt submitted with the key
+    receiptID   string            // ID of the stored receipt, empty if the request failed
+    response    *recordedResponse // nil while the request is in progress
+    expiresAt   time.Time
+}
+
+// IdempotencyStore keeps the idempotency keys and their responses in memory, for a limited time.
+type IdempotencyStore struct {
+    mu        sync.Mutex
+    ttl       time.Duration
+    entries   map[string]*idempotencyEntry
+    lastSweep time.Time
+    now       func() time.Time // clock, replaced in tests
+}
+
+// NewIdempotencyStore
+// @Description    Create an empty idempotency key store.
+// @Param          ttl: time.Duration (time a key is kept after its first request)
+// @Return         pointer to the store: *IdempotencyStore
+func NewIdempotencyStore(ttl time.Duration) *IdempotencyStore {
+    return &IdempotencyStore{
+        ttl: ttl,
+        entries: map[string]*idempotencyEntry{},
+        now: time.Now,
+    }
+}
+
+// begin
+// @Description    Start a request with an idempotency key, reserving the key if it is new (or expired).
+// @Param          key: string, fingerprint: string (of the submitted receipt)
+// @Return         state of the key: idempotencyState, recorded response (replay only): *recordedResponse
+func (s *IdempotencyStore) begin(key string, fingerprint string) (idempotencyState, *recordedResponse) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := s.now()
+    s.sweepLocked(now)
+    if entry, exists := s.entries[key]; exists && now.Before(entry.expiresAt) {
+        switch {
+        case entry.fingerprint != fingerprint:
+            return idempotencyMismatch, nil
+        case entry.response == nil:
+            return idempotencyInProgress, nil
+        default:
+            return idempotencyReplay, entry.response
+        }
+    }
+
+    s.entries[key] = &idempotencyEntry{fingerprint: fingerprint, expiresAt: now.Add(s.ttl)}
+    return idempotencyStarted, nil
+}
+
+// complete
+// @Description    Record the response of a request started with begin, so that it is replayed for the key.
+// @Param          key: string, receiptID: string (empty if no receipt was stored), response: *recordedResponse
+// @Return         none
+func (s *IdempotencyStore) complete(key string, receiptID string, response *recordedResponse) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if entry, exists := s.entries[key]; exists {
+        entry.receiptID = receiptID
+        entry.response = response
+    }
+}
+
+// abandon
+// @Description    Release a key reserved by begin without recording a response (e.g. server error), so that the request can be retried.
+// @Param          key: string
+// @Return         none
+func (s *IdempotencyStore) abandon(key string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if entry, exists := s.entries[key]; exists && entry.response == nil {
+        delete(s.entries, key)
+    }
+}
+
+// ForgetReceipts
+// @Description    Remove the keys of deleted receipts, so that no response refers to them anymore.
+// @Param          receiptIDs: ...string
+// @Return         none
+func (s *IdempotencyStore) ForgetReceipts(receiptIDs ...string) {
+    forgotten := make(map[string]bool, len(receiptIDs))
+    for _, id := range receiptIDs {
+        forgotten[id] = true
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for key, entry := range s.entries {
+        if entry.receiptID != "" && forgotten[entry.receiptID] {
+            delete(s.entries, key)
+        }
+    }
+}
+
+// sweepLocked
+// @Description    Remove the expired keys, at most once per idempotencySweepInterval. The caller must hold s.mu.
+// @Param          now: time.Time
+// @Return         none
+func (s *IdempotencyStore) sweepLocked(now time.Time) {
+    if now.Sub(s.lastSweep) < idempotencySweepInterval {
+        return
+    }
+    s.lastSweep = now
+    for key, entry := range s.entries {
+        if !now.Before(entry.expiresAt) && entry.response != nil {
+            delete(s.entries, key)
+        }
+    }
+}
+
+// validIdempotencyKey
+// @Description    Check an idempotency key: 1 to 255 printable ASCII characters.
+// @Param          key: string
+// @Return         true if the key is valid: bool
+func validIdempotencyKey(key string) bool {
+    if len(key) == 0 || len(key) > maxIdempotencyKeyLength {
+        return false
+    }
+    for _, c := range key {
+        if c < 0x21 || c > 0x7e {
+            return false
+        }
+    }
+    return true
+}
+
+// receiptFingerprint
+// @Description    Hash a canonical receipt, to detect an idempotency key reused with a different receipt.
+// @Param          receipt: models.Receipt (normalized)
+// @Return         fingerprint: string
+func receiptFingerprint(receipt models.Receipt) string {
+    receiptBytes, _ := json.Marshal(receipt)
+    hash := sha256.Sum256(receiptBytes)
+    return hex.EncodeToString(hash[:])
+}
+
+// keyedIDGenerator is an IDGenerator deriving the IDs from an idempotency key as well as from the receipt content,
+// so that distinct purchases with identical contents get distinct IDs when they are submitted with distinct keys.
+type keyedIDGenerator struct {
+    base IDGenerator
+    key  string
+}
+
+// GenerateID
+// @Description    Generate the ID of a receipt submitted with an idempotency key: the hash of the key and of the content ID.
+// @Param          receipt: models.Receipt, attempt: int
+// @Return         receipt ID: string, error: error
+func (g keyedIDGenerator) GenerateID(receipt models.Receipt, attempt int) (string, error) {
+    id, err := g.base.GenerateID(receipt, attempt)
+    if err != nil {
+        return "", err
+    }
+    hash := sha256.Sum256([]byte("idempotency-key:" + g.key + "\x00" + id))
+    return hex.EncodeToString(hash[:]), nil
+}
+
+// processIdempotent
+// @Description    Process a receipt submitted with an idempotency key: the first request is processed and its response recorded,
+//                 the next ones with the same receipt get the recorded response, until the key expires.
+// @Param          w: http.ResponseWriter, receipt: models.Receipt, key: string
+// @Return         none
+func (h *Handler) processIdempotent(w http.ResponseWriter, receipt models.Receipt, key string) {
+    state, recorded := h.idempotency.begin(key, receiptFingerprint(receipt.Normalize(h.normalization)))
+    switch state {
+    case idempotencyReplay:
+        w.Header().Set(IdempotentReplayedHeader, "true")
+        recorded.writeTo(w)
+        return
+    case idempotencyMismatch:
+        http.Error(w, "The Idempotency-Key was already used with a different receipt", http.StatusUnprocessableEntity)
+        return
+    case idempotencyInProgress:
+        http.Error(w, "A request with the same Idempotency-Key is being processed, please retry later", http.StatusConflict)
+        return
+    }
+
+    // release the key if the processing does not complete (e.g. panic)
+    completed := false
+    defer func() {
+        if !completed {
+            h.idempotency.abandon(key)
+        }
+    }()
+
+    capture := &responseCapture{header: http.Header{}, status: http.StatusOK}
+    id := h.writeProcessResult(capture, receipt, key)
+    response := &recordedResponse{status: capture.status, header: capture.header, body: capture.body.Bytes()}
+    if response.status < http.StatusInternalServerError {
+        // server errors are not recorded, the request can be retried with the same key
+        h.idempotency.complete(key, id, response)
+        completed = true
+    }
+    response.writeTo(w)
+}
+
+// writeTo
+// @Description    Write a recorded response.
+// @Param          w: http.ResponseWriter
+// @Return         none
+func (r *recordedResponse) writeTo(w http.ResponseWriter) {
+    for name, values := range r.header {
+        w.Header()[name] = values
+    }
+    w.WriteHeader(r.status)
+    w.Write(r.body)
+}
+
+// responseCapture is an http.ResponseWriter keeping the response in memory.
+type responseCapture struct {
+    header http.Header
+    status int
+    body   bytes.Buffer
+}
+
+func (c *responseCapture) Header() http.Header         { return c.header }
+func (c *responseCapture) Write(b []byte) (int, error) { return c.body.Write(b) }
+func (c *responseCapture) WriteHeader(status int)      { c.status = status }
@@ -0,0 +1,146 @@
+// api/idempotency_test.go
+// Tests for the Idempotency-Key support of the receipt submission.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"receipt-processor/models"
+	"receipt-processor/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupIdempotentRouter sets up the router and returns its handler, to control the clock of the idempotency keys.
+func setupIdempotentRouter() (*mux.Router, *Handler, *storage.MemoryStore) {
+	store := storage.NewMemoryStore()
+	handler := NewHandler(store).WithIdempotencyTTL(time.Hour)
+	router := mux.NewRouter()
+	SetupRouter(router, handler)
+	return router, handler, store
+}
+
+// postWithKey sends a POST /receipts/process request with an Idempotency-Key header.
+func postWithKey(router *mux.Router, receipt models.Receipt, key string) *httptest.ResponseRecorder {
+	requestBody, _ := json.Marshal(receipt)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(requestBody))
+	req.Header.Set(IdempotencyKeyHeader, key)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// Test on submitting identical receipts with idempotency keys
+// expected: one receipt per key, the repeated requests get the original response
+func TestProcessReceiptHandler_IdempotencyKey(t *testing.T) {
+	router, _, store := setupIdempotentRouter()
+	receipt := batchReceipt(0)
+
+	first := postWithKey(router, receipt, "purchase-1")
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Empty(t, first.Header().Get(IdempotentReplayedHeader))
+
+	second := postWithKey(router, receipt, "purchase-2")
+	assert.Equal(t, http.StatusOK, second.Code)
+	assert.NotEqual(t, first.Body.String(), second.Body.String())
+
+	replay := postWithKey(router, receipt, "purchase-1")
+	assert.Equal(t, http.StatusOK, replay.Code)
+	assert.Equal(t, "true", replay.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, "application/json", replay.Header().Get("Content-Type"))
+	assert.Equal(t, first.Body.String(), replay.Body.String())
+
+	// without a key, the receipt is deduplicated on its content
+	contentID := processReceipt(t, router, receipt)
+	assert.NotContains(t, first.Body.String(), contentID)
+	assert.Equal(t, contentID, processReceipt(t, router, receipt))
+
+	list, _ := store.ListReceipts()
+	assert.Len(t, list, 3)
+}
+
+// Test on reusing an idempotency key, and on invalid keys
+// expected: 422 for a different receipt, 400 for an invalid key, failed validations are replayed too
+func TestProcessReceiptHandler_IdempotencyKeyErrors(t *testing.T) {
+	router, _, _ := setupIdempotentRouter()
+
+	assert.Equal(t, http.StatusOK, postWithKey(router, batchReceipt(0), "key-1").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, postWithKey(router, batchReceipt(1), "key-1").Code)
+
+	// the canonical form is compared, formatting differences are the same receipt
+	reformatted := batchReceipt(0)
+	reformatted.Retailer = " Store  0 "
+	assert.Equal(t, "true", postWithKey(router, reformatted, "key-1").Header().Get(IdempotentReplayedHeader))
+
+	assert.Equal(t, http.StatusBadRequest, postWithKey(router, batchReceipt(0), strings.Repeat("k", maxIdempotencyKeyLength+1)).Code)
+	assert.Equal(t, http.StatusBadRequest, postWithKey(router, batchReceipt(0), "key with spaces").Code)
+
+	invalid := batchReceipt(2)
+	invalid.Total = "1"
+	rr := postWithKey(router, invalid, "key-2")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	replay := postWithKey(router, invalid, "key-2")
+	assert.Equal(t, rr.Body.String(), replay.Body.String())
+	assert.Equal(t, "application/problem+json", replay.Header().Get("Content-Type"))
+}
+
+// Test on the expiration and the removal of the idempotency keys
+// expected: expired keys and keys of deleted receipts can be used again
+func TestProcessReceiptHandler_IdempotencyKeyExpiration(t *testing.T) {
+	router, handler, _ := setupIdempotentRouter()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	handler.idempotency.now = func() time.Time { return now }
+
+	assert.Equal(t, http.StatusOK, postWithKey(router, batchReceipt(0), "key-1").Code)
+	now = now.Add(59 * time.Minute)
+	assert.Equal(t, http.StatusUnprocessableEntity, postWithKey(router, batchReceipt(1), "key-1").Code)
+	now = now.Add(time.Minute)
+	rr := postWithKey(router, batchReceipt(1), "key-1")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get(IdempotentReplayedHeader))
+
+	// deleting the receipt forgets its key
+	var response map[string]string
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	req, _ := http.NewRequest("DELETE", "/receipts/"+response["id"], nil)
+	deleted := httptest.NewRecorder()
+	router.ServeHTTP(deleted, req)
+	assert.Equal(t, http.StatusNoContent, deleted.Code)
+	assert.Empty(t, postWithKey(router, batchReceipt(1), "key-1").Header().Get(IdempotentReplayedHeader))
+
+	// the sweep removes the expired keys
+	now = now.Add(2 * time.Hour)
+	postWithKey(router, batchReceipt(2), "key-2")
+	assert.Len(t, handler.idempotency.entries, 1)
+}
+
+// Test on concurrent requests with the same key
+// expected: the key is reserved until the first request completes, server errors release it
+func TestIdempotencyStore_InProgress(t *testing.T) {
+	store := NewIdempotencyStore(time.Hour)
+
+	state, _ := store.begin("key", "fingerprint")
+	assert.Equal(t, idempotencyStarted, state)
+	state, _ = store.begin("key", "fingerprint")
+	assert.Equal(t, idempotencyInProgress, state)
+
+	store.abandon("key")
+	state, _ = store.begin("key", "fingerprint")
+	assert.Equal(t, idempotencyStarted, state)
+
+	store.complete("key", "id", &recordedResponse{status: http.StatusOK})
+	state, response := store.begin("key", "fingerprint")
+	assert.Equal(t, idempotencyReplay, state)
+	assert.Equal(t, http.StatusOK, response.status)
+	store.abandon("key") // completed keys are kept
+	state, _ = store.begin("key", "fingerprint")
+	assert.Equal(t, idempotencyReplay, state)
+}
@@ -0,0 +1,80 @@
+// api/ids.go
+// Generation of the receipt IDs, and resolution of the hash collisions.
+
+package api
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "net/http"
+
+    "receipt-processor/models"
+)
+
+// maxIDAttempts is the maximum number of IDs tried for a receipt before giving up on a collision.
+const maxIDAttempts = 16
+
+// IDGenerator generates the IDs of the receipts.
+// GenerateID must be deterministic: the same (normalized) receipt and attempt always give the same ID,
+// so that a receipt submitted again finds the ID it was stored under. The attempt starts at 0,
+// and is incremented each time the ID is already taken by a different receipt (hash collision).
+type IDGenerator interface {
+    GenerateID(receipt models.Receipt, attempt int) (string, error)
+}
+
+// SHA256IDGenerator is the default IDGenerator: the SHA-256 hash of the receipt content,
+// salted with the attempt number after a collision.
+type SHA256IDGenerator struct{}
+
+// GenerateID
+// @Description    Generate the ID of a receipt: generateReceiptID for the first attempt, then a salted re-hash
+//                 (SHA-256 of the receipt content followed by the attempt number) for the next ones.
+// @Param          receipt: models.Receipt, attempt: int
+// @Return         receipt ID: string, error: error
+func (SHA256IDGenerator) GenerateID(receipt models.Receipt, attempt int) (string, error) {
+    if attempt == 0 {
+        return generateReceiptID(receipt)
+    }
+
+    receiptBytes, err := json.Marshal(receipt)
+    if err != nil {
+        return "", err
+    }
+    // the salt cannot appear in a JSON document, so a salted hash never hashes another receipt content
+    salted := append(receiptBytes, fmt.Sprintf("\x00collision-attempt:%d", attempt)...)
+    hash := sha256.Sum256(salted)
+    return hex.EncodeToString(hash[:]), nil
+}
+
+// resolveReceiptID
+// @Description    Find the ID of a receipt: the first ID of its sequence that is either free, or taken by the same receipt.
+//                 The canonical form of the receipt (see models.Receipt.Normalize) is hashed and compared, so that formatting
+//                 differences do not make distinct receipts. Colliding receipts are stored under distinct deterministic IDs,
+//                 instead of being refused.
+// @Param          receipt: models.Receipt (as submitted), ids: IDGenerator
+// @Return         receipt ID: string, already stored: bool, error: *processError
+func (h *Handler) resolveReceiptID(receipt models.Receipt, ids IDGenerator) (string, bool, *processError) {
+    canonical := receipt.Normalize(h.normalization)
+    for attempt := 0; attempt < maxIDAttempts; attempt++ {
+        id, err := ids.GenerateID(canonical, attempt)
+        if err != nil {
+            return "", false, &processError{status: http.StatusInternalServerError, message: "Error generating receipt ID"}
+        }
+
+        existingReceipt, exists, err := h.store.GetReceiptData(id)
+        if err != nil {
+            return "", false, &processError{status: http.StatusInternalServerError, message: "Error accessing the storage"}
+        }
+        if !exists {
+            return id, false, nil
+        }
+        if existing := existingReceipt.Receipt.Normalize(h.normalization); existing.Equals(&canonical) {
+            // Receipt already processed
+            return id, true, nil
+        }
+        // the ID is taken by a different receipt (hash collision), try the next ID of the sequence
+    }
+    return "", false, &processError{status: http.StatusConflict, message: fmt.Sprintf("Hash collision could not be resolved after %d attempts", maxIDAttempts)}
+}
@@ -0,0 +1,97 @@
+// api/ids_test.go
+// Tests for the receipt ID generation and the hash collision resolution.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"receipt-processor/models"
+	"receipt-processor/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// collidingIDGenerator gives the same IDs to every receipt, to force hash collisions.
+type collidingIDGenerator struct {
+	distinctAttempts int // attempts with a distinct ID, the next ones all give the same ID
+}
+
+func (g collidingIDGenerator) GenerateID(receipt models.Receipt, attempt int) (string, error) {
+	if attempt >= g.distinctAttempts {
+		attempt = g.distinctAttempts
+	}
+	return fmt.Sprintf("id-%d", attempt), nil
+}
+
+// setupRouterWithIDs sets up the router with the given ID generator.
+func setupRouterWithIDs(ids IDGenerator) (*mux.Router, *storage.MemoryStore) {
+	store := storage.NewMemoryStore()
+	router := mux.NewRouter()
+	SetupRouter(router, NewHandler(store).WithIDGenerator(ids))
+	return router, store
+}
+
+// Test on the default ID generator
+// expected: deterministic IDs, distinct for each attempt, the first one being generateReceiptID
+func TestSHA256IDGenerator(t *testing.T) {
+	receipt := batchReceipt(0)
+	first, err := SHA256IDGenerator{}.GenerateID(receipt, 0)
+	assert.NoError(t, err)
+	id, _ := generateReceiptID(receipt)
+	assert.Equal(t, id, first)
+
+	seen := map[string]bool{first: true}
+	for attempt := 1; attempt < maxIDAttempts; attempt++ {
+		salted, err := SHA256IDGenerator{}.GenerateID(receipt, attempt)
+		assert.NoError(t, err)
+		again, _ := SHA256IDGenerator{}.GenerateID(receipt, attempt)
+		assert.Equal(t, salted, again)
+		assert.Len(t, salted, 64)
+		assert.False(t, seen[salted])
+		seen[salted] = true
+	}
+}
+
+// Test on processing receipts whose IDs collide
+// expected: each receipt gets the next free ID of the sequence, and keeps it when submitted again
+func TestProcessReceipt_ForcedCollisions(t *testing.T) {
+	router, store := setupRouterWithIDs(collidingIDGenerator{distinctAttempts: maxIDAttempts})
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, fmt.Sprintf("id-%d", i), processReceipt(t, router, batchReceipt(i)))
+	}
+	for i := 2; i >= 0; i-- {
+		assert.Equal(t, fmt.Sprintf("id-%d", i), processReceipt(t, router, batchReceipt(i)))
+	}
+
+	list, _ := store.ListReceipts()
+	assert.Len(t, list, 3)
+	data, _, _ := store.GetReceiptData("id-1")
+	assert.Equal(t, "Store 1", data.Receipt.Retailer)
+}
+
+// Test on processing receipts whose IDs always collide
+// expected: 409 Conflict once every attempt is taken, the stored receipts are left untouched
+func TestProcessReceipt_UnresolvedCollision(t *testing.T) {
+	router, store := setupRouterWithIDs(collidingIDGenerator{distinctAttempts: 1})
+
+	assert.Equal(t, "id-0", processReceipt(t, router, batchReceipt(0)))
+	assert.Equal(t, "id-1", processReceipt(t, router, batchReceipt(1)))
+
+	requestBody, _ := json.Marshal(batchReceipt(2))
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(requestBody))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "could not be resolved")
+
+	list, _ := store.ListReceipts()
+	assert.Len(t, list, 2)
+}
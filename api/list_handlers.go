@@ -0,0 +1,223 @@
+// api/list_handlers.go
+// Handling the receipt listing requests (filtering, sorting and pagination).
+
+package api
+
+import (
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strconv"
+    "time"
+
+    "receipt-processor/models"
+    "receipt-processor/storage"
+)
+
+const (
+    // defaultPageSize is the number of receipts per page if the limit is not given.
+    defaultPageSize = 20
+    // maxPageSize is the maximum number of receipts per page.
+    maxPageSize = 100
+)
+
+// ReceiptSummary is a receipt in the response body of the GET /receipts endpoint.
+type ReceiptSummary struct {
+    ID           string    `json:"id"`
+    Retailer     string    `json:"retailer"`
+    PurchaseDate string    `json:"purchaseDate"`
+    PurchaseTime string    `json:"purchaseTime"`
+    Total        string    `json:"total"`
+    ItemCount    int       `json:"itemCount"`
+    Points       int64     `json:"points"`
+    RuleVersion  string    `json:"ruleVersion"`
+    ProcessedAt  time.Time `json:"processedAt"`
+}
+
+// ListReceiptsResponse is the response body of the GET /receipts endpoint.
+type ListReceiptsResponse struct {
+    Receipts      []ReceiptSummary `json:"receipts"`
+    NextPageToken string           `json:"nextPageToken,omitempty"` // empty on the last page
+}
+
+// pageToken is the content of the opaque page token: the position of the last receipt of a page,
+// and the query it belongs to, so a token cannot be reused with a different filter or order.
+type pageToken struct {
+    Query  string                `json:"q"`
+    Cursor storage.ReceiptCursor `json:"c"`
+}
+
+// ListReceiptsHandler
+// @Description    Handle the GET /receipts endpoint: list the processed receipts, one page at a time.
+//                 Query params (all optional): userId, retailer, purchaseDateFrom, purchaseDateTo (YYYY-MM-DD),
+//                 minTotal, maxTotal (amounts), minPoints, maxPoints, sort (purchaseDate or points),
+//                 order (asc or desc, default desc), limit (1 to 100, default 20), pageToken (nextPageToken of the previous page).
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) ListReceiptsHandler(w http.ResponseWriter, r *http.Request) {
+    params := r.URL.Query()
+
+    query, err := parseReceiptQuery(params)
+    if err != nil {
+        http.Error(w, fmt.Sprintf("Invalid query: %v", err), http.StatusBadRequest)
+        return
+    }
+    fingerprint := queryFingerprint(query)
+    if token := params.Get("pageToken"); token != "" {
+        cursor, err := decodePageToken(token, fingerprint)
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Invalid query: %v", err), http.StatusBadRequest)
+            return
+        }
+        query.After = &cursor
+    }
+
+    // Retrieve the page
+    page, err := h.store.QueryReceipts(query)
+    if err != nil {
+        http.Error(w, "Error accessing the storage", http.StatusInternalServerError)
+        return
+    }
+
+    response := ListReceiptsResponse{Receipts: make([]ReceiptSummary, 0, len(page.Receipts))}
+    for _, data := range page.Receipts {
+        response.Receipts = append(response.Receipts, ReceiptSummary{
+            ID: data.ID,
+            Retailer: data.Receipt.Retailer,
+            PurchaseDate: data.Receipt.PurchaseDate,
+            PurchaseTime: data.Receipt.PurchaseTime,
+            Total: data.Receipt.Total,
+            ItemCount: len(data.Receipt.Items),
+            Points: data.Points,
+            RuleVersion: data.RuleVersion,
+            ProcessedAt: data.ProcessedAt,
+        })
+    }
+    if page.Next != nil {
+        response.NextPageToken = encodePageToken(fingerprint, *page.Next)
+    }
+
+    // Return the page
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(response)
+}
+
+// parseReceiptQuery
+// @Description    Parse and validate the query params of the GET /receipts endpoint (except the page token).
+// @Param          params: url.Values
+// @Return         storage query: storage.ReceiptQuery, error: error
+func parseReceiptQuery(params url.Values) (storage.ReceiptQuery, error) {
+    query := storage.ReceiptQuery{
+        Sort: storage.SortByPurchaseDate,
+        Descending: true,
+        Limit: defaultPageSize,
+    }
+    filter := &query.Filter
+    var err error
+
+    filter.UserID = params.Get("userId")
+    filter.Retailer = params.Get("retailer")
+    for name, value := range map[string]*string{"purchaseDateFrom": &filter.PurchaseDateFrom, "purchaseDateTo": &filter.PurchaseDateTo} {
+        *value = params.Get(name)
+        if *value == "" {
+            continue
+        }
+        if _, err := time.Parse("2006-01-02", *value); err != nil {
+            return query, fmt.Errorf("%v must be a date (YYYY-MM-DD)", name)
+        }
+    }
+    if filter.MinTotalCents, err = parseOptional(params, "minTotal", models.ParseCents); err != nil {
+        return query, err
+    }
+    if filter.MaxTotalCents, err = parseOptional(params, "maxTotal", models.ParseCents); err != nil {
+        return query, err
+    }
+    parseInt := func(value string) (int64, error) { return strconv.ParseInt(value, 10, 64) }
+    if filter.MinPoints, err = parseOptional(params, "minPoints", parseInt); err != nil {
+        return query, err
+    }
+    if filter.MaxPoints, err = parseOptional(params, "maxPoints", parseInt); err != nil {
+        return query, err
+    }
+
+    switch params.Get("sort") {
+    case "", string(storage.SortByPurchaseDate):
+    case string(storage.SortByPoints):
+        query.Sort = storage.SortByPoints
+    default:
+        return query, fmt.Errorf("sort must be purchaseDate or points")
+    }
+    switch params.Get("order") {
+    case "", "desc":
+    case "asc":
+        query.Descending = false
+    default:
+        return query, fmt.Errorf("order must be asc or desc")
+    }
+    if value := params.Get("limit"); value != "" {
+        query.Limit, err = strconv.Atoi(value)
+        if err != nil || query.Limit < 1 || query.Limit > maxPageSize {
+            return query, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+        }
+    }
+    return query, nil
+}
+
+// parseOptional
+// @Description    Parse an optional query param.
+// @Param          params: url.Values, name: string, parse: func(string) (int64, error)
+// @Return         parsed value (nil if absent): *int64, error: error
+func parseOptional(params url.Values, name string, parse func(string) (int64, error)) (*int64, error) {
+    value := params.Get(name)
+    if value == "" {
+        return nil, nil
+    }
+    parsed, err := parse(value)
+    if err != nil {
+        return nil, fmt.Errorf("%v is invalid: %q", name, value)
+    }
+    return &parsed, nil
+}
+
+// queryFingerprint
+// @Description    Hash the filter and the order of a query, to bind the page tokens to their query.
+// @Param          query: storage.ReceiptQuery
+// @Return         fingerprint: string
+func queryFingerprint(query storage.ReceiptQuery) string {
+    encoded, _ := json.Marshal([]any{query.Filter, query.Sort, query.Descending})
+    hash := sha256.Sum256(encoded)
+    return hex.EncodeToString(hash[:8])
+}
+
+// encodePageToken
+// @Description    Encode the position of the next page as an opaque token.
+// @Param          fingerprint: string, cursor: storage.ReceiptCursor
+// @Return         page token: string
+func encodePageToken(fingerprint string, cursor storage.ReceiptCursor) string {
+    encoded, _ := json.Marshal(pageToken{Query: fingerprint, Cursor: cursor})
+    return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// decodePageToken
+// @Description    Decode a page token, and check that it belongs to the query.
+// @Param          token: string, fingerprint: string
+// @Return         position of the page: storage.ReceiptCursor, error: error
+func decodePageToken(token string, fingerprint string) (storage.ReceiptCursor, error) {
+    var decoded pageToken
+    encoded, err := base64.RawURLEncoding.DecodeString(token)
+    if err == nil {
+        err = json.Unmarshal(encoded, &decoded)
+    }
+    if err != nil || decoded.Cursor.ID == "" {
+        return storage.ReceiptCursor{}, fmt.Errorf("pageToken is malformed")
+    }
+    if decoded.Query != fingerprint {
+        return storage.ReceiptCursor{}, fmt.Errorf("pageToken belongs to a different filter or order")
+    }
+    return decoded.Cursor, nil
+}
@@ -0,0 +1,102 @@
+// api/list_handlers_test.go
+// Tests for the receipt listing handler.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"receipt-processor/models"
+	"receipt-processor/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// listReceipts sends a GET /receipts request with the given query, and decodes the response on success.
+func listReceipts(t *testing.T, handler http.Handler, query string) (*httptest.ResponseRecorder, ListReceiptsResponse) {
+	req, _ := http.NewRequest("GET", "/receipts?"+query, nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var response ListReceiptsResponse
+	if rr.Code == http.StatusOK {
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	}
+	return rr, response
+}
+
+// Tests on ListReceiptsHandler function
+func TestListReceiptsHandler(t *testing.T) {
+	router, store := setupRouterWithStore()
+	for i := 0; i < 5; i++ {
+		store.SaveReceipt(fmt.Sprintf("r%d", i), storage.ReceiptData{
+			Receipt: models.Receipt{
+				Retailer:     []string{"Target", "Walmart"}[i%2],
+				PurchaseDate: fmt.Sprintf("2022-01-%02d", i+1),
+				PurchaseTime: "13:01",
+				Total:        fmt.Sprintf("%d.25", i),
+				Items:        []models.Item{{ShortDescription: "Gatorade", Price: fmt.Sprintf("%d.25", i)}},
+			},
+			Points:      int64(10 * (i % 3)),
+			RuleVersion: "v1",
+		})
+	}
+	ids := func(response ListReceiptsResponse) []string {
+		ids := []string{}
+		for _, receipt := range response.Receipts {
+			ids = append(ids, receipt.ID)
+		}
+		return ids
+	}
+
+	// 1. default - newest first, single page
+	rr, response := listReceipts(t, router, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []string{"r4", "r3", "r2", "r1", "r0"}, ids(response))
+	assert.Empty(t, response.NextPageToken)
+	assert.Equal(t, ReceiptSummary{
+		ID: "r4", Retailer: "Target", PurchaseDate: "2022-01-05", PurchaseTime: "13:01",
+		Total: "4.25", ItemCount: 1, Points: 10, RuleVersion: "v1",
+	}, response.Receipts[0])
+
+	// 2. pagination - the pages cover every receipt once
+	query := "sort=points&order=asc&limit=2"
+	rr, response = listReceipts(t, router, query)
+	assert.Equal(t, []string{"r0", "r3"}, ids(response))
+	assert.NotEmpty(t, response.NextPageToken)
+	_, response = listReceipts(t, router, query+"&pageToken="+response.NextPageToken)
+	assert.Equal(t, []string{"r1", "r4"}, ids(response))
+	_, response = listReceipts(t, router, query+"&pageToken="+response.NextPageToken)
+	assert.Equal(t, []string{"r2"}, ids(response))
+	assert.Empty(t, response.NextPageToken)
+
+	// 3. filters
+	_, response = listReceipts(t, router, "retailer=target&purchaseDateFrom=2022-01-02&order=asc")
+	assert.Equal(t, []string{"r2", "r4"}, ids(response))
+	_, response = listReceipts(t, router, "minTotal=1.25&maxTotal=3.00&minPoints=10&maxPoints=20")
+	assert.Equal(t, []string{"r2", "r1"}, ids(response))
+	_, response = listReceipts(t, router, "retailer=Aldi")
+	assert.Equal(t, []string{}, ids(response))
+
+	// 4. a page token cannot be used with a different query - 400 Bad Request
+	_, response = listReceipts(t, router, "limit=1")
+	rr, _ = listReceipts(t, router, "limit=1&order=asc&pageToken="+response.NextPageToken)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr, _ = listReceipts(t, router, "limit=2&pageToken="+response.NextPageToken) // a different limit is fine
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// 5. invalid params - 400 Bad Request
+	invalid := []string{
+		"purchaseDateFrom=01/01/2022", "minTotal=abc", "maxTotal=1.234", "minPoints=ten", "sort=retailer",
+		"order=up", "limit=0", "limit=101", "pageToken=garbage", "pageToken=" + url.QueryEscape("e30"),
+	}
+	for _, query := range invalid {
+		rr, _ := listReceipts(t, router, query)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+	}
+}
@@ -0,0 +1,136 @@
+// api/redemption_handlers.go
+// Handling the redemptions of points: a redemption is reserved, then confirmed or cancelled (two-phase, for checkout integrations),
+// or redeemed at once.
+
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "time"
+
+    "receipt-processor/services"
+
+    "github.com/gorilla/mux"
+)
+
+// RedemptionRequest is the request body of the POST /users/{id}/redemptions endpoint.
+type RedemptionRequest struct {
+    Points  int64 `json:"points"`
+    Reserve bool  `json:"reserve"` // hold the points until the redemption is confirmed or cancelled, instead of spending them at once
+}
+
+// CreateRedemptionHandler
+// @Description    Handle the POST /users/{id}/redemptions endpoint: redeem points of a user, taken from their oldest receipts first.
+//                 With "reserve": true the points are only held, and the redemption must then be confirmed or cancelled.
+//                 A redemption never overdraws the points available (balance less the held points).
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) CreateRedemptionHandler(w http.ResponseWriter, r *http.Request) {
+    userID, ok := userIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    var request RedemptionRequest
+    if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+        http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+        return
+    }
+    if request.Points <= 0 {
+        http.Error(w, "The points of the redemption must be a positive integer", http.StatusBadRequest)
+        return
+    }
+
+    now := time.Now().UTC()
+    redemption, err := h.ledger.Reserve(userID, request.Points, now)
+    if err == nil && !request.Reserve {
+        reservationID := redemption.ID
+        if redemption, err = h.ledger.Confirm(userID, reservationID, now); err != nil {
+            // the confirmation could not be saved, release the points it was holding
+            h.ledger.Cancel(userID, reservationID, now)
+        }
+    }
+    if err != nil {
+        h.writeRedemptionError(w, userID, err)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Location", fmt.Sprintf("/users/%s/redemptions/%s", userID, redemption.ID))
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(redemption)
+}
+
+// GetRedemptionHandler
+// @Description    Handle the GET /users/{id}/redemptions/{redemptionId} endpoint: get a redemption of a user.
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) GetRedemptionHandler(w http.ResponseWriter, r *http.Request) {
+    h.settleRedemption(w, r, func(userID string, redemptionID string) (services.Redemption, error) {
+        return h.ledger.Redemption(userID, redemptionID)
+    })
+}
+
+// ConfirmRedemptionHandler
+// @Description    Handle the POST /users/{id}/redemptions/{redemptionId}/confirm endpoint: spend the points of a reserved redemption.
+//                 Confirming a confirmed redemption again is not an error.
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) ConfirmRedemptionHandler(w http.ResponseWriter, r *http.Request) {
+    h.settleRedemption(w, r, func(userID string, redemptionID string) (services.Redemption, error) {
+        return h.ledger.Confirm(userID, redemptionID, time.Now().UTC())
+    })
+}
+
+// CancelRedemptionHandler
+// @Description    Handle the POST /users/{id}/redemptions/{redemptionId}/cancel endpoint: release the points of a reserved redemption.
+//                 Cancelling a cancelled redemption again is not an error.
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) CancelRedemptionHandler(w http.ResponseWriter, r *http.Request) {
+    h.settleRedemption(w, r, func(userID string, redemptionID string) (services.Redemption, error) {
+        return h.ledger.Cancel(userID, redemptionID, time.Now().UTC())
+    })
+}
+
+// settleRedemption
+// @Description    Apply an operation to the redemption of the request path, and write the redemption or the error.
+// @Param          w: http.ResponseWriter, r: *http.Request, operation: func(userID, redemptionID) (services.Redemption, error)
+// @Return         none
+func (h *Handler) settleRedemption(w http.ResponseWriter, r *http.Request, operation func(string, string) (services.Redemption, error)) {
+    userID, ok := userIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    redemption, err := operation(userID, mux.Vars(r)["redemptionId"])
+    if err != nil {
+        h.writeRedemptionError(w, userID, err)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(redemption)
+}
+
+// writeRedemptionError
+// @Description    Write the response of a failed redemption operation.
+// @Param          w: http.ResponseWriter, userID: string, err: error
+// @Return         none
+func (h *Handler) writeRedemptionError(w http.ResponseWriter, userID string, err error) {
+    switch {
+    case errors.Is(err, services.ErrInsufficientPoints):
+        available, _ := h.ledger.Available(userID)
+        http.Error(w, fmt.Sprintf("Insufficient points, %d available", available), http.StatusConflict)
+    case errors.Is(err, services.ErrRedemptionNotFound):
+        http.Error(w, "No redemption found for that id", http.StatusNotFound)
+    case errors.Is(err, services.ErrRedemptionSettled):
+        http.Error(w, "The redemption is already settled", http.StatusConflict)
+    default:
+        http.Error(w, "Error redeeming the points", http.StatusInternalServerError)
+    }
+}
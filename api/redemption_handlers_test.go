@@ -0,0 +1,179 @@
+// api/redemption_handlers_test.go
+// Tests for the handlers on the redemptions of points.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"receipt-processor/services"
+	"receipt-processor/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupFundedRouter sets up a router where alice has the points of 2 receipts, and returns their total.
+func setupFundedRouter(t *testing.T) (*mux.Router, int64) {
+	router, store := setupRouterWithStore()
+	var total int64
+	for i := 0; i < 2; i++ {
+		receipt := batchReceipt(i)
+		receipt.UserID = "alice"
+		data, _, _ := store.GetReceiptData(processReceipt(t, router, receipt))
+		total += data.Points
+	}
+	return router, total
+}
+
+// postRedemption sends a POST request on a redemption endpoint and decodes the redemption of a successful response.
+func postRedemption(t *testing.T, router http.Handler, path string, body string) (*httptest.ResponseRecorder, services.Redemption) {
+	req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var redemption services.Redemption
+	if rr.Code == http.StatusOK || rr.Code == http.StatusCreated {
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &redemption))
+	}
+	return rr, redemption
+}
+
+// Tests on CreateRedemptionHandler function
+// expected: the points are spent at once, with a redemption entry in the ledger, and overdrawing is refused
+func TestCreateRedemptionHandler(t *testing.T) {
+	router, total := setupFundedRouter(t)
+
+	// 1. general case - immediate redemption
+	rr, redemption := postRedemption(t, router, "/users/alice/redemptions", `{"points": 10}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "/users/alice/redemptions/"+redemption.ID, rr.Header().Get("Location"))
+	assert.Equal(t, services.RedemptionConfirmed, redemption.Status)
+	assert.Equal(t, int64(10), redemption.Points)
+	assert.NotEmpty(t, redemption.Consumed)
+
+	var ledger LedgerResponse
+	getJSON(t, router, "/users/alice/ledger", &ledger)
+	last := ledger.Entries[len(ledger.Entries)-1]
+	assert.Equal(t, services.EntryRedemption, last.Type)
+	assert.Equal(t, int64(-10), last.Points)
+	assert.Equal(t, redemption.Consumed, last.Consumed)
+	assert.Equal(t, total-10, ledger.Balance)
+
+	// 2. overdraw
+	rr, _ = postRedemption(t, router, "/users/alice/redemptions", fmt.Sprintf(`{"points": %d}`, total-9))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), fmt.Sprintf("%d available", total-10))
+	rr, _ = postRedemption(t, router, "/users/bob/redemptions", `{"points": 1}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	// 3. invalid requests
+	for _, body := range []string{`{"points": 0}`, `{"points": -5}`, `{"points": 1.5}`, `not json`} {
+		rr, _ = postRedemption(t, router, "/users/alice/redemptions", body)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+}
+
+// Tests on the two-phase redemptions: reserve, then confirm or cancel
+// expected: the reserved points are held until the confirmation or the cancellation
+func TestRedemptionHandlers_TwoPhase(t *testing.T) {
+	router, total := setupFundedRouter(t)
+
+	rr, reserved := postRedemption(t, router, "/users/alice/redemptions", `{"points": 20, "reserve": true}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, services.RedemptionReserved, reserved.Status)
+	var balance BalanceResponse
+	getJSON(t, router, "/users/alice/balance", &balance)
+	assert.Equal(t, BalanceResponse{UserID: "alice", Balance: total, Available: total - 20, Held: 20}, balance)
+
+	// confirm, twice
+	path := "/users/alice/redemptions/" + reserved.ID
+	for i := 0; i < 2; i++ {
+		rr, confirmed := postRedemption(t, router, path+"/confirm", "")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, services.RedemptionConfirmed, confirmed.Status)
+	}
+	getJSON(t, router, "/users/alice/balance", &balance)
+	assert.Equal(t, BalanceResponse{UserID: "alice", Balance: total - 20, Available: total - 20, Held: 0}, balance)
+	rr, _ = postRedemption(t, router, path+"/cancel", "")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	var redemption services.Redemption
+	assert.Equal(t, http.StatusOK, getJSON(t, router, path, &redemption))
+	assert.Equal(t, services.RedemptionConfirmed, redemption.Status)
+
+	// cancel
+	_, reserved = postRedemption(t, router, "/users/alice/redemptions", `{"points": 5, "reserve": true}`)
+	rr, cancelled := postRedemption(t, router, "/users/alice/redemptions/"+reserved.ID+"/cancel", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, services.RedemptionCancelled, cancelled.Status)
+	getJSON(t, router, "/users/alice/balance", &balance)
+	assert.Equal(t, BalanceResponse{UserID: "alice", Balance: total - 20, Available: total - 20, Held: 0}, balance)
+
+	// unknown redemption, or redemption of another user
+	rr, _ = postRedemption(t, router, "/users/alice/redemptions/unknown/confirm", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, http.StatusNotFound, getJSON(t, router, "/users/bob/redemptions/"+reserved.ID, &redemption))
+}
+
+// Tests on redemptions that cannot be saved
+// expected: 500, and the points are neither spent nor held
+func TestRedemptionHandlers_SaveFailure(t *testing.T) {
+	store := &failingStore{MemoryStore: storage.NewMemoryStore()}
+	router := mux.NewRouter()
+	SetupRouter(router, NewHandler(store))
+	receipt := batchReceipt(0)
+	receipt.UserID = "alice"
+	data, _, _ := store.GetReceiptData(processReceipt(t, router, receipt))
+	_, reserved := postRedemption(t, router, "/users/alice/redemptions", `{"points": 2, "reserve": true}`)
+
+	store.setFail(true)
+	for _, body := range []string{`{"points": 1}`, `{"points": 1, "reserve": true}`} {
+		rr, _ := postRedemption(t, router, "/users/alice/redemptions", body)
+		assert.Equal(t, http.StatusInternalServerError, rr.Code, body)
+	}
+	rr, _ := postRedemption(t, router, "/users/alice/redemptions/"+reserved.ID+"/confirm", "")
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	store.setFail(false)
+
+	var balance BalanceResponse
+	getJSON(t, router, "/users/alice/balance", &balance)
+	assert.Equal(t, BalanceResponse{UserID: "alice", Balance: data.Points, Available: data.Points - 2, Held: 2}, balance)
+	redemptions, _ := store.ListRedemptions()
+	assert.Len(t, redemptions, 1)
+	assert.Equal(t, "reserved", redemptions[0].Status)
+}
+
+// Tests on concurrent redemptions
+// expected: the points are never double spent
+func TestCreateRedemptionHandler_Concurrent(t *testing.T) {
+	router, total := setupFundedRouter(t)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := int64(0)
+	for worker := 0; worker < 20; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr, _ := postRedemption(t, router, "/users/alice/redemptions", `{"points": 7}`)
+			if rr.Code == http.StatusCreated {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, total/7, created) // fewer than the 20 redemptions fit in the balance
+	var balance BalanceResponse
+	getJSON(t, router, "/users/alice/balance", &balance)
+	assert.Equal(t, total-7*created, balance.Balance)
+	assert.GreaterOrEqual(t, balance.Balance, int64(0))
+}
@@ -0,0 +1,138 @@
+// api/reversal_handlers.go
+// Handling the reversals of the receipts (e.g. refunds): the points of the reversed receipt, or of its reversed items,
+// are taken back from the user with a negative adjustment of their ledger.
+
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "sort"
+    "time"
+
+    "receipt-processor/services"
+    "receipt-processor/storage"
+
+    "github.com/gorilla/mux"
+)
+
+// ReversalRequest is the request body of the POST /receipts/{id}/reverse endpoint, optional for a full reversal.
+type ReversalRequest struct {
+    Items  []int  `json:"items,omitempty"` // indexes of the reversed items in the stored receipt (see GET /receipts/{id}), every item if empty
+    Reason string `json:"reason,omitempty"`
+}
+
+// ReversalResponse is the response body of the POST /receipts/{id}/reverse endpoint.
+type ReversalResponse struct {
+    ID             string           `json:"id"`
+    Reversal       storage.Reversal `json:"reversal"`
+    OriginalPoints int64            `json:"originalPoints"`
+    NetPoints      int64            `json:"netPoints"`     // points of the receipt after all its reversals
+    FullyReversed  bool             `json:"fullyReversed"` // no item of the receipt is left
+}
+
+// ReverseReceiptHandler
+// @Description    Handle the POST /receipts/{id}/reverse endpoint: reverse a receipt (full reversal, without items), or some of its
+//                 items (partial reversal). The remaining items are scored again with the rule set version of the receipt, and
+//                 the points the receipt no longer earns are taken back from its user (a reversal entry of the ledger, which may
+//                 leave a negative balance if the points were spent). The stored receipt keeps its original points and records
+//                 the reversal. Reversing a fully reversed receipt, or an item already reversed, is refused (409 Conflict).
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) ReverseReceiptHandler(w http.ResponseWriter, r *http.Request) {
+    defer r.Body.Close()
+
+    var request ReversalRequest
+    if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+        http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+        return
+    }
+
+    // The reversals of a receipt are read, computed and saved one at a time, and never save a receipt erased meanwhile
+    h.changeMu.Lock()
+    defer h.changeMu.Unlock()
+
+    data, ok := h.lookupReceipt(w, mux.Vars(r)["id"])
+    if !ok {
+        return
+    }
+    if data.FullyReversed() {
+        http.Error(w, "The receipt is already fully reversed", http.StatusConflict)
+        return
+    }
+
+    // Check the reversed items
+    reversed := data.ReversedItems()
+    items := append([]int{}, request.Items...)
+    sort.Ints(items)
+    for i, index := range items {
+        if index < 0 || index >= len(data.Receipt.Items) || (i > 0 && index == items[i-1]) {
+            http.Error(w, fmt.Sprintf("Invalid item index %d: the indexes must be distinct, from 0 to %d", index, len(data.Receipt.Items)-1), http.StatusBadRequest)
+            return
+        }
+        if reversed[index] {
+            http.Error(w, fmt.Sprintf("Item %d of the receipt is already reversed", index), http.StatusConflict)
+            return
+        }
+        reversed[index] = true
+    }
+
+    // Compute the points of the remaining items, none for a full reversal
+    var remaining services.ReversalPoints
+    if len(items) > 0 && len(reversed) < len(data.Receipt.Items) {
+        if _, found := services.GetRuleRegistry().Version(data.RuleVersion); !found {
+            http.Error(w, fmt.Sprintf("The rule set version %v of the receipt is not available, its points cannot be computed again", data.RuleVersion), http.StatusConflict)
+            return
+        }
+        var err error
+        stored := services.PointsResult{Points: data.Points, Breakdown: data.Breakdown, RuleVersion: data.RuleVersion}
+        if remaining, err = services.ReversedReceiptPoints(&data.Receipt, stored, reversed); err != nil {
+            http.Error(w, "Error computing the points of the remaining items", http.StatusInternalServerError)
+            return
+        }
+    }
+
+    // Record the reversal with the receipt
+    reversal := storage.Reversal{
+        Points:     max(0, data.NetPoints()-remaining.Net),
+        BasePoints: remaining.Base,
+        Reason:     request.Reason,
+        ReversedAt: time.Now().UTC(),
+    }
+    if len(items) > 0 {
+        reversal.Items = items
+    }
+    previous := data
+    data.Reversals = append(append([]storage.Reversal{}, data.Reversals...), reversal)
+    if err := h.store.SaveReceipt(data.ID, data); err != nil {
+        http.Error(w, "Error saving the receipt", http.StatusInternalServerError)
+        return
+    }
+
+    // Take the points back from the user. If the ledger refuses the reversal, the stored reversal is rolled back,
+    // so that the reversal can be retried instead of being refused as already done
+    if userID := data.Receipt.UserID; userID != "" {
+        if _, _, err := h.ledger.Reverse(userID, data.ID, len(data.Reversals)-1, reversal.Points, reversal.ReversedAt); err != nil {
+            if err := h.store.SaveReceipt(previous.ID, previous); err != nil {
+                http.Error(w, "Error taking back the points, and the reversal could not be rolled back", http.StatusInternalServerError)
+                return
+            }
+            http.Error(w, "Error taking back the points", http.StatusInternalServerError)
+            return
+        }
+        h.tiers.Reverse(userID, data.ID, reversal.BasePoints)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(ReversalResponse{
+        ID:             data.ID,
+        Reversal:       reversal,
+        OriginalPoints: data.Points,
+        NetPoints:      data.NetPoints(),
+        FullyReversed:  data.FullyReversed(),
+    })
+}
@@ -0,0 +1,274 @@
+// api/reversal_handlers_test.go
+// Tests for the handlers on the reversals of the receipts.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"receipt-processor/models"
+	"receipt-processor/services"
+	"receipt-processor/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// reversalReceipt is the example receipt of the instruction (109 points) submitted by alice: 4 items of 2.25, total 9.00.
+func reversalReceipt() models.Receipt {
+	return models.Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []models.Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+		Total:  "9.00",
+		UserID: "alice",
+	}
+}
+
+// postReversal sends a POST /receipts/{id}/reverse request and decodes the response on success.
+func postReversal(t *testing.T, router http.Handler, id string, body string) (*httptest.ResponseRecorder, ReversalResponse) {
+	req, _ := http.NewRequest("POST", "/receipts/"+id+"/reverse", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var response ReversalResponse
+	if rr.Code == http.StatusOK {
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	}
+	return rr, response
+}
+
+// Tests on ReverseReceiptHandler function
+// expected: the remaining items are scored again, the points taken back are a reversal entry of the ledger,
+// and the points endpoint reports the original and the net points
+func TestReverseReceiptHandler(t *testing.T) {
+	router, store := setupRouterWithStore()
+	id := processReceipt(t, router, reversalReceipt())
+
+	// 1. partial reversal: 3 items left, total 6.75, 54 points (no round dollar total, 1 pair)
+	rr, response := postReversal(t, router, id, `{"items": [3], "reason": "refund"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []int{3}, response.Reversal.Items)
+	assert.Equal(t, int64(55), response.Reversal.Points)
+	assert.Equal(t, int64(54), response.Reversal.BasePoints)
+	assert.Equal(t, "refund", response.Reversal.Reason)
+	assert.Equal(t, int64(109), response.OriginalPoints)
+	assert.Equal(t, int64(54), response.NetPoints)
+	assert.False(t, response.FullyReversed)
+
+	var points PointsResponse
+	assert.Equal(t, http.StatusOK, getJSON(t, router, "/receipts/"+id+"/points", &points))
+	assert.Equal(t, PointsResponse{Points: 54, OriginalPoints: 109, NetPoints: 54, RuleVersion: services.DefaultRuleSetVersion}, points)
+	var balance BalanceResponse
+	getJSON(t, router, "/users/alice/balance", &balance)
+	assert.Equal(t, int64(54), balance.Balance)
+
+	// 2. reversing an item that leaves the points unchanged (2 items left, still 1 pair): no ledger entry
+	rr, response = postReversal(t, router, id, `{"items": [0]}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, int64(0), response.Reversal.Points)
+	assert.Equal(t, int64(54), response.NetPoints)
+
+	// 3. full reversal of the rest
+	rr, response = postReversal(t, router, id, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, response.Reversal.Items)
+	assert.Equal(t, int64(54), response.Reversal.Points)
+	assert.Equal(t, int64(0), response.NetPoints)
+	assert.True(t, response.FullyReversed)
+
+	var ledger LedgerResponse
+	getJSON(t, router, "/users/alice/ledger", &ledger)
+	assert.Equal(t, int64(0), ledger.Balance)
+	if assert.Len(t, ledger.Entries, 3) {
+		assert.Equal(t, services.EntryReversal, ledger.Entries[1].Type)
+		assert.Equal(t, int64(-55), ledger.Entries[1].Points)
+		assert.Equal(t, id, ledger.Entries[1].ReceiptID)
+		assert.Equal(t, int64(-54), ledger.Entries[2].Points)
+	}
+
+	// the stored receipt keeps its original points and records the reversals
+	data, _, _ := store.GetReceiptData(id)
+	assert.Equal(t, int64(109), data.Points)
+	assert.Len(t, data.Reversals, 3)
+	var receipt ReceiptResponse
+	getJSON(t, router, "/receipts/"+id, &receipt)
+	assert.Equal(t, data.Reversals, receipt.Reversals)
+
+	// 4. a fully reversed receipt cannot be reversed again
+	rr, _ = postReversal(t, router, id, "")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+}
+
+// Tests on the errors of ReverseReceiptHandler function
+// expected: 400 for an invalid request, 404 for an unknown receipt, 409 for an item already reversed or unknown rules
+func TestReverseReceiptHandler_Errors(t *testing.T) {
+	router, store := setupRouterWithStore()
+	id := processReceipt(t, router, reversalReceipt())
+
+	for _, body := range []string{`{"items": [4]}`, `{"items": [-1]}`, `{"items": [1, 1]}`, `{"items": "all"}`} {
+		rr, _ := postReversal(t, router, id, body)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+	rr, _ := postReversal(t, router, "unknown", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr, _ = postReversal(t, router, id, `{"items": [1, 2]}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr, _ = postReversal(t, router, id, `{"items": [0, 2]}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	// the items of a receipt scored by rules that are no longer loaded cannot be scored again, a full reversal is still possible
+	data, _, _ := store.GetReceiptData(id)
+	data.RuleVersion = "unloaded"
+	assert.NoError(t, store.SaveReceipt(id, data))
+	rr, _ = postReversal(t, router, id, `{"items": [0]}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	rr, response := postReversal(t, router, id, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, response.FullyReversed)
+}
+
+// Test on reversing a receipt whose points are spent
+// expected: the balance of the user becomes negative, and the next receipts repay it
+func TestReverseReceiptHandler_SpentPoints(t *testing.T) {
+	router, total := setupFundedRouter(t)
+	rr, _ := postRedemption(t, router, "/users/alice/redemptions", `{"points": 74}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	receipt := batchReceipt(0)
+	receipt.UserID = "alice"
+	id := processReceipt(t, router, receipt) // already processed, same ID
+	rr, response := postReversal(t, router, id, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, total/2, response.Reversal.Points)
+
+	var balance BalanceResponse
+	getJSON(t, router, "/users/alice/balance", &balance)
+	assert.Equal(t, -total/2, balance.Balance)
+	assert.Equal(t, -total/2, balance.Available)
+
+	receipt = batchReceipt(2)
+	receipt.UserID = "alice"
+	processReceipt(t, router, receipt)
+	getJSON(t, router, "/users/alice/balance", &balance)
+	assert.Equal(t, int64(0), balance.Available)
+}
+
+// Test on reversing a receipt without user
+// expected: the reversal is recorded with the receipt, no ledger is involved
+func TestReverseReceiptHandler_Anonymous(t *testing.T) {
+	router, store := setupRouterWithStore()
+	receipt := reversalReceipt()
+	receipt.UserID = ""
+	id := processReceipt(t, router, receipt)
+
+	rr, response := postReversal(t, router, id, `{"items": [0, 1, 2, 3]}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, int64(109), response.Reversal.Points)
+	assert.True(t, response.FullyReversed)
+	data, _, _ := store.GetReceiptData(id)
+	assert.Equal(t, []storage.Reversal{response.Reversal}, data.Reversals)
+}
+
+// Test on a reversal the ledger refuses, and on a reversal that cannot be stored
+// expected: nothing is recorded with the receipt nor taken back, so the reversal can be retried
+func TestReverseReceiptHandler_Rollback(t *testing.T) {
+	store := &failingStore{MemoryStore: storage.NewMemoryStore()}
+	ledger := services.NewLedger()
+	router := mux.NewRouter()
+	SetupRouter(router, NewHandler(store).WithLedger(ledger))
+	id := processReceipt(t, router, reversalReceipt())
+
+	// 1. the save fails before the ledger is touched
+	store.setFail(true)
+	rr, _ := postReversal(t, router, id, "")
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	store.setFail(false)
+	assert.Equal(t, int64(109), ledger.Balance("alice"))
+
+	// 2. the ledger refuses the reversal (it already has a first reversal of other points): the stored reversal is rolled back
+	_, _, err := ledger.Reverse("alice", id, 0, 1, time.Now())
+	assert.NoError(t, err)
+	rr, _ = postReversal(t, router, id, "")
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	data, _, _ := store.GetReceiptData(id)
+	assert.Empty(t, data.Reversals)
+	assert.Equal(t, int64(108), ledger.Balance("alice"))
+}
+
+// pausingStore is a memory storage whose next receipt lookup signals looked up, then waits for resume.
+type pausingStore struct {
+	*storage.MemoryStore
+	mu       sync.Mutex
+	paused   bool
+	lookedUp chan struct{}
+	resume   chan struct{}
+}
+
+func (s *pausingStore) pauseNextLookup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = true
+	s.lookedUp = make(chan struct{})
+	s.resume = make(chan struct{})
+}
+
+func (s *pausingStore) GetReceiptData(id string) (storage.ReceiptData, bool, error) {
+	s.mu.Lock()
+	paused := s.paused
+	s.paused = false
+	s.mu.Unlock()
+	data, found, err := s.MemoryStore.GetReceiptData(id)
+	if paused {
+		close(s.lookedUp)
+		<-s.resume
+	}
+	return data, found, err
+}
+
+// Test on erasing a receipt while it is being reversed
+// expected: the reversal does not save the erased receipt again
+func TestReverseReceiptHandler_ConcurrentErasure(t *testing.T) {
+	for _, path := range []string{"/receipts/{id}", "/users/alice/receipts"} {
+		store := &pausingStore{MemoryStore: storage.NewMemoryStore()}
+		router := mux.NewRouter()
+		SetupRouter(router, NewHandler(store))
+		id := processReceipt(t, router, reversalReceipt())
+
+		// the reversal has read the receipt when the erasure starts
+		store.pauseNextLookup()
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			postReversal(t, router, id, "")
+		}()
+		<-store.lookedUp
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("DELETE", strings.Replace(path, "{id}", id, 1), nil)
+			router.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		close(store.resume)
+		wg.Wait()
+
+		_, found, _ := store.GetReceiptData(id)
+		assert.False(t, found, path)
+		_, erased, _ := store.GetTombstone(id)
+		assert.True(t, erased, path)
+	}
+}
@@ -11,12 +11,34 @@ import (
 
 // SetupRouter
 // @Description    Set up the router for the API.
-// @Param          router: *mux.Router (pointer to the router)
+// @Param          router: *mux.Router (pointer to the router), handler: *Handler (handlers and their dependencies)
 // @Return         none
-func SetupRouter(router *mux.Router) {
+func SetupRouter(router *mux.Router, handler *Handler) {
 	// Skip cleaning the URL path (enabling empty {id} requests and return 404 instead of 301 redirect)
 	router.SkipClean(true)
 	
-	router.HandleFunc("/receipts/process", ProcessReceiptHandler).Methods(http.MethodPost)
-    router.HandleFunc("/receipts/{id}/points", GetPointsHandler).Methods(http.MethodGet)
+	router.HandleFunc("/receipts", handler.ListReceiptsHandler).Methods(http.MethodGet)
+	router.HandleFunc("/receipts/process", handler.ProcessReceiptHandler).Methods(http.MethodPost)
+	router.HandleFunc("/receipts/batch", handler.ProcessBatchHandler).Methods(http.MethodPost)
+	router.HandleFunc("/receipts/score", handler.ScoreReceiptHandler).Methods(http.MethodPost)
+    router.HandleFunc("/receipts/{id}", handler.GetReceiptHandler).Methods(http.MethodGet)
+    router.HandleFunc("/receipts/{id}", handler.DeleteReceiptHandler).Methods(http.MethodDelete)
+    router.HandleFunc("/receipts/{id}/points", handler.GetPointsHandler).Methods(http.MethodGet)
+    router.HandleFunc("/receipts/{id}/breakdown", handler.GetBreakdownHandler).Methods(http.MethodGet)
+    router.HandleFunc("/receipts/{id}/reverse", handler.ReverseReceiptHandler).Methods(http.MethodPost)
+
+	// User endpoints
+	router.HandleFunc("/users/{id}/receipts", handler.EraseUserReceiptsHandler).Methods(http.MethodDelete)
+	router.HandleFunc("/users/{id}/balance", handler.GetBalanceHandler).Methods(http.MethodGet)
+	router.HandleFunc("/users/{id}/ledger", handler.GetLedgerHandler).Methods(http.MethodGet)
+	router.HandleFunc("/users/{id}/expirations", handler.GetExpirationsHandler).Methods(http.MethodGet)
+	router.HandleFunc("/users/{id}/tier", handler.GetTierHandler).Methods(http.MethodGet)
+	router.HandleFunc("/users/{id}/redemptions", handler.CreateRedemptionHandler).Methods(http.MethodPost)
+	router.HandleFunc("/users/{id}/redemptions/{redemptionId}", handler.GetRedemptionHandler).Methods(http.MethodGet)
+	router.HandleFunc("/users/{id}/redemptions/{redemptionId}/confirm", handler.ConfirmRedemptionHandler).Methods(http.MethodPost)
+	router.HandleFunc("/users/{id}/redemptions/{redemptionId}/cancel", handler.CancelRedemptionHandler).Methods(http.MethodPost)
+
+	// Admin endpoints
+	router.HandleFunc("/admin/rules/simulate", handler.SimulateRulesHandler).Methods(http.MethodPost)
+	router.HandleFunc("/admin/tombstones", handler.ListTombstonesHandler).Methods(http.MethodGet)
 }
\ No newline at end of file
@@ -0,0 +1,185 @@
+// api/user_handlers.go
+// Handling the API requests on the receipts of a user.
+
+package api
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+    "time"
+
+    "receipt-processor/services"
+    "receipt-processor/storage"
+
+    "github.com/gorilla/mux"
+)
+
+// ErasureResponse is the response body of the DELETE /users/{id}/receipts endpoint.
+type ErasureResponse struct {
+    UserID      string   `json:"userId"`
+    ErasedCount int      `json:"erasedCount"`
+    ReceiptIDs  []string `json:"receiptIds"` // IDs of the erased receipts, each has a tombstone
+}
+
+// BalanceResponse is the response body of the GET /users/{id}/balance endpoint.
+type BalanceResponse struct {
+    UserID    string `json:"userId"`
+    Balance   int64  `json:"balance"`
+    Available int64  `json:"available"` // balance less the points held by the reserved redemptions
+    Held      int64  `json:"held"`
+}
+
+// LedgerResponse is the response body of the GET /users/{id}/ledger endpoint.
+type LedgerResponse struct {
+    UserID  string                 `json:"userId"`
+    Balance int64                  `json:"balance"`
+    Entries []services.LedgerEntry `json:"entries"` // oldest first
+}
+
+// ExpirationsResponse is the response body of the GET /users/{id}/expirations endpoint.
+type ExpirationsResponse struct {
+    UserID      string                        `json:"userId"`
+    Total       int64                         `json:"total"`       // points that will expire unless they are redeemed first
+    Expirations []services.UpcomingExpiration `json:"expirations"` // soonest first
+}
+
+// GetBalanceHandler
+// @Description    Handle the GET /users/{id}/balance endpoint: get the points balance of a user (0 for an unknown user),
+//                 and the points that can be redeemed.
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) GetBalanceHandler(w http.ResponseWriter, r *http.Request) {
+    userID, ok := userIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    available, held := h.ledger.Available(userID)
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(BalanceResponse{UserID: userID, Balance: available + held, Available: available, Held: held})
+}
+
+// GetLedgerHandler
+// @Description    Handle the GET /users/{id}/ledger endpoint: get the ledger entries of a user, with their running balance.
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) GetLedgerHandler(w http.ResponseWriter, r *http.Request) {
+    userID, ok := userIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    entries := h.ledger.Entries(userID)
+    response := LedgerResponse{UserID: userID, Entries: entries}
+    if len(entries) > 0 {
+        response.Balance = entries[len(entries)-1].Balance
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(response)
+}
+
+// GetExpirationsHandler
+// @Description    Handle the GET /users/{id}/expirations endpoint: get the points of a user that will expire, per receipt, soonest first.
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) GetExpirationsHandler(w http.ResponseWriter, r *http.Request) {
+    userID, ok := userIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    response := ExpirationsResponse{UserID: userID, Expirations: h.ledger.UpcomingExpirations(userID)}
+    for _, expiration := range response.Expirations {
+        response.Total += expiration.Points
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(response)
+}
+
+// GetTierHandler
+// @Description    Handle the GET /users/{id}/tier endpoint: get the current loyalty tier of a user, and the points to the next tier.
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) GetTierHandler(w http.ResponseWriter, r *http.Request) {
+    userID, ok := userIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(h.tiers.Status(userID, time.Now().UTC()))
+}
+
+// userIDParam
+// @Description    Get the user ID of the request path, writing a 400 Bad Request response if it is blank.
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         user ID: string, ok: bool
+func userIDParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+    userID := mux.Vars(r)["id"]
+    if strings.TrimSpace(userID) == "" {
+        http.Error(w, "The ID of the user is required", http.StatusBadRequest)
+        return "", false
+    }
+    return userID, true
+}
+
+// EraseUserReceiptsHandler
+// @Description    Handle the DELETE /users/{id}/receipts endpoint: erase every receipt of the user (e.g. GDPR erasure request),
+//                 leaving a tombstone without personal data for each of them. Erasing a user without receipts is not an error.
+//                 The points ledger, the redemptions and the tier history of the user are erased as well.
+// @Param          w: http.ResponseWriter, r: *http.Request
+// @Return         none
+func (h *Handler) EraseUserReceiptsHandler(w http.ResponseWriter, r *http.Request) {
+    userID, ok := userIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    // A reversal in progress is saved before the receipts are erased, not after
+    h.changeMu.Lock()
+    defer h.changeMu.Unlock()
+
+    // Find the receipts of the user
+    receipts, err := h.store.FindReceipts(storage.ReceiptFilter{UserID: userID})
+    if err != nil {
+        http.Error(w, "Error accessing the storage", http.StatusInternalServerError)
+        return
+    }
+    ids := make([]string, 0, len(receipts))
+    for _, data := range receipts {
+        ids = append(ids, data.ID)
+    }
+
+    // Erase them. The storage may report an error after erasing some of them (e.g. a failed compaction of the file
+    // storage): the erased receipts are then cleaned up before reporting it, as a retry no longer finds them
+    tombstones, err := h.store.EraseReceipts(ids, storage.TombstoneUserErasure, time.Now())
+    response := ErasureResponse{UserID: userID, ReceiptIDs: make([]string, 0, len(tombstones))}
+    for _, tombstone := range tombstones {
+        response.ReceiptIDs = append(response.ReceiptIDs, tombstone.ID)
+    }
+    response.ErasedCount = len(response.ReceiptIDs)
+    if err == nil || len(response.ReceiptIDs) > 0 {
+        h.idempotency.ForgetReceipts(response.ReceiptIDs...)
+        h.ledger.EraseUser(userID)
+        h.tiers.EraseUser(userID)
+    }
+    if err != nil {
+        http.Error(w, "Error erasing the receipts", http.StatusInternalServerError)
+        return
+    }
+    if err := h.store.EraseRedemptions(userID); err != nil {
+        http.Error(w, "Error erasing the redemptions", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,377 @@
+// api/user_handlers_test.go
+// Tests for the handlers on the receipts of a user.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"receipt-processor/models"
+	"receipt-processor/services"
+	"receipt-processor/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests on EraseUserReceiptsHandler function
+func TestEraseUserReceiptsHandler(t *testing.T) {
+	router, store := setupRouterWithStore()
+	ids := map[string]string{}
+	for _, retailer := range []string{"Target", "Walmart", "Costco"} {
+		userID := "alice"
+		if retailer == "Costco" {
+			userID = "bob"
+		}
+		ids[retailer] = processReceipt(t, router, models.Receipt{
+			Retailer:     retailer,
+			PurchaseDate: "2022-01-01",
+			PurchaseTime: "13:01",
+			Total:        "1.25",
+			Items:        []models.Item{{ShortDescription: "Pepsi - 12-oz", Price: "1.25"}},
+			UserID:       userID,
+		})
+	}
+
+	// 1. general case - the receipts of the user are erased, with tombstones
+	req, _ := http.NewRequest("DELETE", "/users/alice/receipts", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response ErasureResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "alice", response.UserID)
+	assert.Equal(t, 2, response.ErasedCount)
+	assert.ElementsMatch(t, []string{ids["Target"], ids["Walmart"]}, response.ReceiptIDs)
+
+	for _, retailer := range []string{"Target", "Walmart"} {
+		_, found, _ := store.GetReceiptData(ids[retailer])
+		assert.False(t, found)
+		tombstone, found, _ := store.GetTombstone(ids[retailer])
+		assert.True(t, found)
+		assert.Equal(t, storage.TombstoneUserErasure, tombstone.Reason)
+	}
+	_, found, _ := store.GetReceiptData(ids["Costco"])
+	assert.True(t, found)
+
+	// 2. the tombstones are listed for audit, without personal data
+	req, _ = http.NewRequest("GET", "/admin/tombstones", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "alice")
+	assert.NotContains(t, rr.Body.String(), "Target")
+	assert.Contains(t, rr.Body.String(), ids["Target"])
+
+	// 3. nothing left to erase - 200 OK
+	req, _ = http.NewRequest("DELETE", "/users/alice/receipts", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 0, response.ErasedCount)
+	assert.Equal(t, []string{}, response.ReceiptIDs)
+}
+
+// Tests on erasing receipts when the storage reports an error after erasing them
+// expected: 500, the erased receipts are still cleaned up: their points and idempotency keys are forgotten
+func TestEraseUserReceiptsHandler_PartialFailure(t *testing.T) {
+	store := &failingStore{MemoryStore: storage.NewMemoryStore()}
+	router := mux.NewRouter()
+	SetupRouter(router, NewHandler(store).WithIdempotencyTTL(time.Hour))
+	first := batchReceipt(0)
+	first.UserID = "alice"
+	assert.Equal(t, http.StatusOK, postWithKey(router, first, "purchase-1").Code)
+	second := batchReceipt(1)
+	second.UserID = "alice"
+	id := processReceipt(t, router, second)
+
+	// 1. deletion of one receipt
+	store.setFail(true)
+	req, _ := http.NewRequest("DELETE", "/receipts/"+id, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	var balance BalanceResponse
+	getJSON(t, router, "/users/alice/balance", &balance)
+	data, _ := store.FindReceipts(storage.ReceiptFilter{UserID: "alice"})
+	assert.Len(t, data, 1)
+	assert.Equal(t, data[0].Points, balance.Balance)
+
+	// 2. erasure of the user
+	req, _ = http.NewRequest("DELETE", "/users/alice/receipts", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	data, _ = store.FindReceipts(storage.ReceiptFilter{UserID: "alice"})
+	assert.Empty(t, data)
+	getJSON(t, router, "/users/alice/balance", &balance)
+	assert.Equal(t, BalanceResponse{UserID: "alice"}, balance)
+
+	// the key of the erased receipt is not replayed
+	store.setFail(false)
+	rr = postWithKey(router, first, "purchase-1")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get(IdempotentReplayedHeader))
+}
+
+// getJSON sends a GET request and decodes the JSON response.
+func getJSON(t *testing.T, router http.Handler, path string, out any) int {
+	req, _ := http.NewRequest("GET", path, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code == http.StatusOK {
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), out))
+	}
+	return rr.Code
+}
+
+// Tests on GetBalanceHandler and GetLedgerHandler functions
+// expected: one credit per receipt of the user, duplicates credited once, nothing for the anonymous receipts
+func TestUserBalanceAndLedger(t *testing.T) {
+	router, store := setupRouterWithStore()
+
+	first := batchReceipt(0)
+	first.UserID = "alice"
+	firstID := processReceipt(t, router, first)
+	processReceipt(t, router, first) // duplicate
+	second := batchReceipt(1)
+	second.UserID = "alice"
+	secondID := processReceipt(t, router, second)
+	processReceipt(t, router, batchReceipt(2)) // anonymous
+
+	firstData, _, _ := store.GetReceiptData(firstID)
+	secondData, _, _ := store.GetReceiptData(secondID)
+
+	var balance BalanceResponse
+	assert.Equal(t, http.StatusOK, getJSON(t, router, "/users/alice/balance", &balance))
+	assert.Equal(t, BalanceResponse{UserID: "alice", Balance: firstData.Points + secondData.Points, Available: firstData.Points + secondData.Points}, balance)
+
+	var ledger LedgerResponse
+	assert.Equal(t, http.StatusOK, getJSON(t, router, "/users/alice/ledger", &ledger))
+	assert.Equal(t, balance.Balance, ledger.Balance)
+	if assert.Len(t, ledger.Entries, 2) {
+		assert.Equal(t, services.EntryCredit, ledger.Entries[0].Type)
+		assert.Equal(t, firstID, ledger.Entries[0].ReceiptID)
+		assert.Equal(t, firstData.Points, ledger.Entries[0].Points)
+		assert.Equal(t, secondID, ledger.Entries[1].ReceiptID)
+	}
+
+	// unknown user
+	assert.Equal(t, http.StatusOK, getJSON(t, router, "/users/bob/ledger", &ledger))
+	assert.Equal(t, LedgerResponse{UserID: "bob", Balance: 0, Entries: []services.LedgerEntry{}}, ledger)
+	assert.Equal(t, http.StatusBadRequest, getJSON(t, router, "/users/%20/balance", &balance))
+
+	// the erasure of the user erases their ledger
+	req, _ := http.NewRequest("DELETE", "/users/alice/receipts", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	getJSON(t, router, "/users/alice/balance", &balance)
+	assert.Equal(t, int64(0), balance.Balance)
+}
+
+// Tests on concurrent submissions of the receipts of a user
+// expected: the balance is consistent with the stored receipts
+func TestUserBalance_ConcurrentSubmissions(t *testing.T) {
+	router, store := setupRouterWithStore()
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				// every worker submits the same 20 receipts
+				receipt := batchReceipt(i)
+				receipt.UserID = "alice"
+				processReceipt(t, router, receipt)
+			}
+		}()
+	}
+	wg.Wait()
+
+	list, _ := store.ListReceipts()
+	assert.Len(t, list, 20)
+	var expected int64
+	for _, data := range list {
+		expected += data.Points
+	}
+	var balance BalanceResponse
+	getJSON(t, router, "/users/alice/balance", &balance)
+	assert.Equal(t, expected, balance.Balance)
+}
+
+// Tests on GetExpirationsHandler function
+// expected: the points of each receipt of the user expire 12 months after its purchase date, soonest first
+func TestGetExpirationsHandler(t *testing.T) {
+	store := storage.NewMemoryStore()
+	router := mux.NewRouter()
+	ledger := services.NewLedger().WithExpirationPolicy(services.ExpirationPolicy{LifetimeMonths: 12})
+	SetupRouter(router, NewHandler(store).WithLedger(ledger))
+
+	var ids []string
+	for i, purchaseDate := range []string{"2022-03-01", "2022-01-01"} {
+		receipt := batchReceipt(i)
+		receipt.PurchaseDate = purchaseDate
+		receipt.UserID = "alice"
+		ids = append(ids, processReceipt(t, router, receipt))
+	}
+
+	var response ExpirationsResponse
+	assert.Equal(t, http.StatusOK, getJSON(t, router, "/users/alice/expirations", &response))
+	assert.Equal(t, "alice", response.UserID)
+	if assert.Len(t, response.Expirations, 2) {
+		assert.Equal(t, ids[1], response.Expirations[0].ReceiptID)
+		assert.Equal(t, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), response.Expirations[0].ExpiresAt)
+		assert.Equal(t, services.ExpiryLifetime, response.Expirations[0].Reason)
+		assert.Equal(t, ids[0], response.Expirations[1].ReceiptID)
+		assert.Equal(t, response.Expirations[0].Points+response.Expirations[1].Points, response.Total)
+	}
+
+	// once expired, the points are gone from the balance and from the upcoming expirations
+	ledger.ExpirePoints(time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC))
+	getJSON(t, router, "/users/alice/expirations", &response)
+	assert.Len(t, response.Expirations, 1)
+	var ledgerResponse LedgerResponse
+	getJSON(t, router, "/users/alice/ledger", &ledgerResponse)
+	assert.Equal(t, services.EntryExpiry, ledgerResponse.Entries[len(ledgerResponse.Entries)-1].Type)
+	assert.Equal(t, response.Total, ledgerResponse.Balance)
+
+	assert.Equal(t, http.StatusOK, getJSON(t, router, "/users/bob/expirations", &response))
+	assert.Equal(t, ExpirationsResponse{UserID: "bob", Expirations: []services.UpcomingExpiration{}}, response)
+}
+
+// Tests on the loyalty tiers of the users, and on GetTierHandler function
+// expected: the tier multiplies the points of the next receipts, is recorded in their breakdown, and its changes are emitted
+func TestGetTierHandler(t *testing.T) {
+	tiers, err := services.ParseTiers("Bronze:0:1,Silver:30:2:1")
+	assert.NoError(t, err)
+	var mu sync.Mutex
+	var events []services.TierChangeEvent
+	tracker := services.NewTierTracker(services.TierPolicy{Tiers: tiers, WindowMonths: 12}, services.TierEventSinkFunc(func(event services.TierChangeEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}))
+	store := storage.NewMemoryStore()
+	router := mux.NewRouter()
+	SetupRouter(router, NewHandler(store).WithTiers(tracker))
+
+	// 1. first receipt at Bronze, the second at Silver: base points x2, plus 1 bonus point
+	first := batchReceipt(0)
+	first.UserID = "alice"
+	firstData, _, _ := store.GetReceiptData(processReceipt(t, router, first))
+	second := batchReceipt(1)
+	second.UserID = "alice"
+	second.PurchaseDate = "2022-01-02"
+	secondID := processReceipt(t, router, second)
+	secondData, _, _ := store.GetReceiptData(secondID)
+
+	var base int64
+	for _, entry := range secondData.Breakdown {
+		if entry.RuleID != services.TierRuleID {
+			base += entry.Points
+		}
+	}
+	assert.Equal(t, 2*base+1, secondData.Points)
+	tierEntry := secondData.Breakdown[len(secondData.Breakdown)-1]
+	assert.Equal(t, services.TierRuleID, tierEntry.RuleID)
+	assert.Equal(t, base+1, tierEntry.Points)
+	assert.Contains(t, tierEntry.Reason, "Silver tier")
+
+	// the ledger is credited with the multiplied points
+	var balance BalanceResponse
+	getJSON(t, router, "/users/alice/balance", &balance)
+	assert.Equal(t, firstData.Points+secondData.Points, balance.Balance)
+
+	// the anonymous receipts have no tier
+	anonymousData, _, _ := store.GetReceiptData(processReceipt(t, router, batchReceipt(2)))
+	for _, entry := range anonymousData.Breakdown {
+		assert.NotEqual(t, services.TierRuleID, entry.RuleID)
+	}
+
+	// 2. the tier changes are emitted: the first receipt alone reaches Silver
+	mu.Lock()
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "", events[0].From)
+		assert.Equal(t, "Silver", events[0].To)
+		assert.Equal(t, firstData.ID, events[0].ReceiptID)
+	}
+	mu.Unlock()
+
+	// 3. the tier status is evaluated now: the 2022 receipts are out of the rolling window
+	var status services.TierStatus
+	assert.Equal(t, http.StatusOK, getJSON(t, router, "/users/alice/tier", &status))
+	assert.Equal(t, services.TierStatus{UserID: "alice", Tier: "Bronze", RollingPoints: 0, NextTier: "Silver", PointsToNextTier: 30}, status)
+	assert.Equal(t, http.StatusBadRequest, getJSON(t, router, "/users/%20/tier", &status))
+}
+
+// Tests on a receipt of a user that cannot be stored
+// expected: the receipt does not count for the tier of the user, so a retry is not counted twice
+func TestProcessReceiptHandler_TierSaveFailure(t *testing.T) {
+	tiers, err := services.ParseTiers("Bronze:0:1,Silver:30:2:1")
+	assert.NoError(t, err)
+	var mu sync.Mutex
+	var events []services.TierChangeEvent
+	tracker := services.NewTierTracker(services.TierPolicy{Tiers: tiers, WindowMonths: 12}, services.TierEventSinkFunc(func(event services.TierChangeEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}))
+	store := &failingStore{MemoryStore: storage.NewMemoryStore()}
+	router := mux.NewRouter()
+	SetupRouter(router, NewHandler(store).WithTiers(tracker))
+
+	first := batchReceipt(0)
+	first.UserID = "alice"
+	body, _ := json.Marshal(first)
+	store.setFail(true)
+	req, _ := http.NewRequest("POST", "/receipts/process", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	mu.Lock()
+	assert.Empty(t, events)
+	mu.Unlock()
+
+	// the retry counts the receipt once: the next receipt is evaluated on its 37 points only
+	store.setFail(false)
+	processReceipt(t, router, first)
+	second := batchReceipt(1)
+	second.UserID = "alice"
+	second.PurchaseDate = "2022-01-02"
+	data, _, _ := store.GetReceiptData(processReceipt(t, router, second))
+	assert.Contains(t, data.Breakdown[len(data.Breakdown)-1].Reason, "Silver tier (37 points in the last 12 months)")
+	mu.Lock()
+	assert.Len(t, events, 1)
+	mu.Unlock()
+}
+
+// Tests on deleting a receipt of a user
+// expected: the points of the receipt are taken back, the balance matches the receipts left (as rebuilt after a restart)
+func TestDeleteReceiptHandler_Balance(t *testing.T) {
+	router, total := setupFundedRouter(t)
+	receipt := batchReceipt(0)
+	receipt.UserID = "alice"
+	id := processReceipt(t, router, receipt) // already processed, same ID
+
+	req, _ := http.NewRequest("DELETE", "/receipts/"+id, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	var balance BalanceResponse
+	getJSON(t, router, "/users/alice/balance", &balance)
+	assert.Equal(t, total/2, balance.Balance)
+	var ledger LedgerResponse
+	getJSON(t, router, "/users/alice/ledger", &ledger)
+	last := ledger.Entries[len(ledger.Entries)-1]
+	assert.Equal(t, services.EntryDeletion, last.Type)
+	assert.Equal(t, id, last.ReceiptID)
+	assert.Equal(t, -total/2, last.Points)
+}
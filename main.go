@@ -4,22 +4,186 @@
 package main
 
 import (
+    "context"
+    "flag"
     "fmt"
+    "log"
     "net/http"
+    "os"
+    "os/signal"
+    "path/filepath"
     "receipt-processor/api"
+    "receipt-processor/models"
+    "receipt-processor/services"
+    "receipt-processor/storage"
+    "syscall"
+    "time"
 
     "github.com/gorilla/mux"
 )
 
 func main() {
+    rulesPath := flag.String("rules", "", "path to the points rule configuration (YAML or JSON), the built-in rules are used if empty")
+    rulesWatch := flag.Duration("rules-watch", 0, "interval to poll the rule configuration for changes (e.g. 10s), disabled if 0")
+    storageBackend := flag.String("storage", "memory", "storage backend: memory (lost on restart), file or sqlite (durable, in -data-dir)")
+    dataDir := flag.String("data-dir", "data", "directory of the file and sqlite storages")
+    normalizeWhitespace := flag.Bool("normalize-whitespace", true, "trim and collapse the whitespace of the retailer and item descriptions before hashing")
+    normalizeCase := flag.String("normalize-case", "preserve", "case of the retailer and item descriptions before hashing: preserve, lower or upper")
+    normalizeSortItems := flag.Bool("normalize-sort-items", true, "sort the items before hashing, so that their order does not change the receipt ID")
+    normalizeAmounts := flag.Bool("normalize-amounts", true, "format the amounts canonically before hashing (e.g. 06.49 becomes 6.49)")
+    idempotencyTTL := flag.Duration("idempotency-ttl", api.DefaultIdempotencyTTL, "time an Idempotency-Key is kept after its first request (e.g. 24h)")
+    lifetimeMonths := flag.Int("points-lifetime-months", 12, "months after the purchase date when the points of a receipt expire, never if 0")
+    inactivityMonths := flag.Int("points-inactivity-months", 0, "months without credit nor redemption after which all the points of a user expire, never if 0")
+    expirationInterval := flag.Duration("expiration-interval", time.Hour, "interval between two runs of the points expiration")
+    tierSpec := flag.String("tiers", "Bronze:0:1,Silver:500:1.25,Gold:1500:1.5", "loyalty tiers, Name:minPoints:multiplier[:bonusPoints] separated by commas, ordered by minimum points")
+    tierWindow := flag.Int("tier-window-months", services.DefaultTierWindowMonths, "rolling window of the points counted for the loyalty tiers, in months")
+    flag.Parse()
+
+    // Canonical form of the submitted receipts, which is hashed to the receipt ID
+    casePolicy, err := models.ParseCasePolicy(*normalizeCase)
+    if err != nil {
+        panic(err)
+    }
+    normalization := models.NormalizationPolicy{
+        CollapseWhitespace: *normalizeWhitespace,
+        Case:               casePolicy,
+        SortItems:          *normalizeSortItems,
+        FormatAmounts:      *normalizeAmounts,
+    }
+
+    // Set up the storage
+    store, err := openStore(*storageBackend, *dataDir)
+    if err != nil {
+        panic(err)
+    }
+    defer store.Close()
+
+    // Restore the rule set versions that scored the stored receipts, so that they can be re-scored (e.g. by a partial reversal)
+    if *storageBackend != "memory" {
+        history, err := services.OpenRuleHistory(filepath.Join(*dataDir, "rules-history.json"))
+        if err != nil {
+            panic(err)
+        }
+        if err := services.GetRuleRegistry().WithHistory(history); err != nil {
+            panic(err)
+        }
+    }
+
+    // Load the points rules, they can then be reloaded with SIGHUP (or by the watcher) without restarting
+    if *rulesPath != "" {
+        reloader := services.NewRuleReloader(*rulesPath, services.GetRuleRegistry())
+        if err := reloader.Reload(); err != nil {
+            panic(err)
+        }
+        fmt.Printf("Loaded %d points rules (version %v) from %v\n", reloader.Status().RuleCount, reloader.Status().Version, *rulesPath)
+        watchRules(reloader, *rulesWatch)
+    }
+
     router := mux.NewRouter()
 
+    // Loyalty tiers, the tier changes are logged
+    tiers, err := services.ParseTiers(*tierSpec)
+    if err != nil {
+        panic(err)
+    }
+    tierPolicy := services.TierPolicy{Tiers: tiers, WindowMonths: *tierWindow}
+    if err := tierPolicy.Validate(); err != nil {
+        panic(err)
+    }
+    tierTracker := services.NewTierTracker(tierPolicy, services.TierEventSinkFunc(func(event services.TierChangeEvent) {
+        log.Printf("User %v moved from tier %q to tier %q (%d rolling points)", event.UserID, event.From, event.To, event.RollingPoints)
+    }))
+
+    // Restore the points and the tiers of the users from the stored receipts and redemptions
+    ledger, err := api.RestoreAccounts(store, tierTracker)
+    if err != nil {
+        panic(err)
+    }
+
+    // Expire the points on a schedule
+    expiration := services.ExpirationPolicy{LifetimeMonths: *lifetimeMonths, InactivityMonths: *inactivityMonths}
+    if err := expiration.Validate(); err != nil {
+        panic(err)
+    }
+    if *expirationInterval <= 0 {
+        panic(fmt.Errorf("[main] The expiration interval must be positive, got %v", *expirationInterval))
+    }
+    ledger.WithExpirationPolicy(expiration)
+    go ledger.RunExpiration(context.Background(), *expirationInterval, func(expired []services.LedgerEntry) {
+        if len(expired) > 0 {
+            log.Printf("Expired the points of %d receipts", len(expired))
+        }
+    })
+
     // Set up routes
-    api.SetupRouter(router)
+    handler := api.NewHandler(store).
+        WithNormalization(normalization).
+        WithIdempotencyTTL(*idempotencyTTL).
+        WithLedger(ledger).
+        WithTiers(tierTracker)
+    api.SetupRouter(router, handler)
 
     fmt.Println("Server is running on port 8080...")
-    err := http.ListenAndServe(":8080", router)
+    err = http.ListenAndServe(":8080", router)
     if err != nil {
         panic(err)
     }
-}
\ No newline at end of file
+}
+
+// openStore
+// @Description    Open the storage backend selected on the command line.
+// @Param          backend: string, dataDir: string
+// @Return         storage: storage.ReceiptStore, error: error
+func openStore(backend string, dataDir string) (storage.ReceiptStore, error) {
+    switch backend {
+    case "memory":
+        return storage.NewMemoryStore(), nil
+    case "file":
+        store, err := storage.OpenFileStore(dataDir, storage.FileStoreOptions{})
+        if err != nil {
+            return nil, err
+        }
+        fmt.Printf("Using the file storage in %v\n", dataDir)
+        return store, nil
+    case "sqlite":
+        if err := os.MkdirAll(dataDir, 0o755); err != nil {
+            return nil, fmt.Errorf("[openStore] Failed to create the data directory: %w", err)
+        }
+        path := filepath.Join(dataDir, "receipts.db")
+        store, err := storage.OpenSQLStore(path)
+        if err != nil {
+            return nil, err
+        }
+        fmt.Printf("Using the sqlite storage in %v\n", path)
+        return store, nil
+    default:
+        return nil, fmt.Errorf("[openStore] Unknown storage backend %q", backend)
+    }
+}
+
+// watchRules
+// @Description    Reload the points rules on SIGHUP, and on file changes if interval is positive.
+//                 A failed reload keeps the previous rules and logs the error.
+// @Param          reloader: *services.RuleReloader, interval: time.Duration
+// @Return         none
+func watchRules(reloader *services.RuleReloader, interval time.Duration) {
+    logReload := func(err error) {
+        if err != nil {
+            log.Printf("Failed to reload points rules: %v", err)
+            return
+        }
+        log.Printf("Reloaded %d points rules (version %v)", reloader.Status().RuleCount, reloader.Status().Version)
+    }
+
+    hangup := make(chan os.Signal, 1)
+    signal.Notify(hangup, syscall.SIGHUP)
+    go func() {
+        for range hangup {
+            logReload(reloader.Reload())
+        }
+    }()
+
+    if interval > 0 {
+        go reloader.Watch(context.Background(), interval, logReload)
+    }
+}
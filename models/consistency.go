@@ -0,0 +1,119 @@
+// models/v1/consistency.go
+
+package models
+
+// @Title        models/consistency.go
+// @Description  Consistency check of the receipt total against the sum of the item prices.
+
+import (
+    "fmt"
+)
+
+// CodeTotalMismatch is the validation error code of a total not matching the sum of the item prices.
+const CodeTotalMismatch = "total_mismatch"
+
+// TotalCheckMode defines how strictly the total must match the sum of the item prices.
+type TotalCheckMode string
+
+const (
+    TotalCheckOff      TotalCheckMode = "off"      // the total is not checked
+    TotalCheckStrict   TotalCheckMode = "strict"   // the total must be exactly the sum of the item prices
+    TotalCheckTolerant TotalCheckMode = "tolerant" // the total may differ from the sum by an allowance (tax, discounts...)
+)
+
+// TotalMismatchAction defines what happens to a receipt whose total does not match.
+type TotalMismatchAction string
+
+const (
+    TotalMismatchReject TotalMismatchAction = "reject" // the receipt is refused with a validation error
+    TotalMismatchFlag   TotalMismatchAction = "flag"   // the receipt is accepted and flagged in its points breakdown
+)
+
+// TotalCheckPolicy configures the consistency check of the receipt total.
+// In tolerant mode, the allowed difference is Tolerance plus TolerancePercent of the sum of the item prices.
+type TotalCheckPolicy struct {
+    Mode             TotalCheckMode
+    Tolerance        Money // absolute allowance, tolerant mode only
+    TolerancePercent int64 // allowance in percent of the sum of the item prices, tolerant mode only
+    OnMismatch       TotalMismatchAction
+}
+
+// Validate
+// @Description    Check the policy itself (known mode and action, non-negative allowances).
+// @Param          none
+// @Return         error: error
+func (p TotalCheckPolicy) Validate() error {
+    switch p.Mode {
+    case TotalCheckOff, TotalCheckStrict, TotalCheckTolerant:
+    default:
+        return fmt.Errorf("[TotalCheckPolicy.Validate] Unknown mode %q, expected off, strict or tolerant", p.Mode)
+    }
+    switch p.OnMismatch {
+    case TotalMismatchReject, TotalMismatchFlag:
+    default:
+        return fmt.Errorf("[TotalCheckPolicy.Validate] Unknown mismatch action %q, expected reject or flag", p.OnMismatch)
+    }
+    if p.Tolerance < 0 || p.TolerancePercent < 0 {
+        return fmt.Errorf("[TotalCheckPolicy.Validate] Tolerances must not be negative")
+    }
+    if p.Mode != TotalCheckTolerant && (p.Tolerance != 0 || p.TolerancePercent != 0) {
+        return fmt.Errorf("[TotalCheckPolicy.Validate] Tolerances are only allowed in tolerant mode")
+    }
+    return nil
+}
+
+// CheckTotal
+// @Description    Check the total of the receipt against the sum of its item prices, under the given policy.
+//                 Amounts that cannot be parsed are not reported here, Validate reports them.
+// @Param          policy: TotalCheckPolicy
+// @Return         mismatch (nil if the total is consistent or not checked): *FieldError
+func (r *Receipt) CheckTotal(policy TotalCheckPolicy) *FieldError {
+    if policy.Mode == "" || policy.Mode == TotalCheckOff {
+        return nil
+    }
+
+    total, err := r.TotalAmount()
+    if err != nil {
+        return nil
+    }
+    var sum Money
+    for _, item := range r.Items {
+        price, err := item.PriceAmount()
+        if err != nil {
+            return nil
+        }
+        if sum, err = sum.Add(price); err != nil {
+            return nil
+        }
+    }
+
+    difference, err := total.Sub(sum)
+    if err != nil {
+        return nil
+    }
+    if difference < 0 {
+        difference = -difference
+    }
+
+    var allowance Money
+    if policy.Mode == TotalCheckTolerant {
+        // the percentage of the sum is rounded down to the cent
+        percent, err := sum.Mul(policy.TolerancePercent)
+        if err == nil {
+            allowance, err = policy.Tolerance.Add(percent / 100)
+        }
+        if err != nil {
+            // the allowance is larger than any amount
+            return nil
+        }
+    }
+    if difference <= allowance {
+        return nil
+    }
+
+    return &FieldError{
+        Field:   "total",
+        Code:    CodeTotalMismatch,
+        Message: fmt.Sprintf("the total %v does not match the sum of the item prices %v (allowed difference %v)", total, sum, allowance),
+    }
+}
@@ -0,0 +1,56 @@
+// models/consistency_test.go
+// Tests for the consistency check of the receipt total
+
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test on checking the total under each mode
+// expected: a total_mismatch error only when the difference exceeds the allowance of the mode
+func TestCheckTotal(t *testing.T) {
+	receipt := validReceipt() // items sum up to 14.25
+	strict := TotalCheckPolicy{Mode: TotalCheckStrict, OnMismatch: TotalMismatchReject}
+	tolerant := TotalCheckPolicy{Mode: TotalCheckTolerant, Tolerance: 50, TolerancePercent: 10, OnMismatch: TotalMismatchFlag} // 0.50 + 1.42
+
+	assert.Nil(t, receipt.CheckTotal(strict))
+	assert.Nil(t, receipt.CheckTotal(TotalCheckPolicy{}))
+
+	receipt.Total = "15.00" // fabricated to hit the round dollar bonus
+	mismatch := receipt.CheckTotal(strict)
+	if assert.NotNil(t, mismatch) {
+		assert.Equal(t, "total", mismatch.Field)
+		assert.Equal(t, CodeTotalMismatch, mismatch.Code)
+		assert.Contains(t, mismatch.Message, "14.25")
+	}
+	assert.Nil(t, receipt.CheckTotal(TotalCheckPolicy{Mode: TotalCheckOff}))
+	assert.Nil(t, receipt.CheckTotal(tolerant))
+
+	receipt.Total = "16.17" // 14.25 + 1.92 allowed
+	assert.Nil(t, receipt.CheckTotal(tolerant))
+	receipt.Total = "16.18"
+	assert.NotNil(t, receipt.CheckTotal(tolerant))
+	receipt.Total = "12.33" // discounts are allowed too
+	assert.Nil(t, receipt.CheckTotal(tolerant))
+	receipt.Total = "12.32"
+	assert.NotNil(t, receipt.CheckTotal(tolerant))
+
+	// invalid amounts are reported by Validate, not by the check
+	receipt.Total = "15"
+	assert.Nil(t, receipt.CheckTotal(strict))
+}
+
+// Test on validating the policies
+// expected: errors for unknown modes or actions, and misplaced or negative tolerances
+func TestTotalCheckPolicy_Validate(t *testing.T) {
+	assert.NoError(t, TotalCheckPolicy{Mode: TotalCheckOff, OnMismatch: TotalMismatchReject}.Validate())
+	assert.NoError(t, TotalCheckPolicy{Mode: TotalCheckTolerant, Tolerance: 100, OnMismatch: TotalMismatchFlag}.Validate())
+
+	assert.Error(t, TotalCheckPolicy{Mode: "lenient", OnMismatch: TotalMismatchReject}.Validate())
+	assert.Error(t, TotalCheckPolicy{Mode: TotalCheckStrict, OnMismatch: "ignore"}.Validate())
+	assert.Error(t, TotalCheckPolicy{Mode: TotalCheckStrict, Tolerance: 100, OnMismatch: TotalMismatchReject}.Validate())
+	assert.Error(t, TotalCheckPolicy{Mode: TotalCheckTolerant, TolerancePercent: -1, OnMismatch: TotalMismatchReject}.Validate())
+}
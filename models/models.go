@@ -3,6 +3,12 @@
 // Package models defines the data models used in the application.
 package models
 
+import (
+    "fmt"
+    "math"
+    "strings"
+)
+
 // @Title        models/models.go
 // @Description  Data models definitions.
 
@@ -14,6 +20,7 @@ type Receipt struct {
     PurchaseTime    string `json:"purchaseTime"`
     Items           []Item `json:"items"`
     Total           string `json:"total"`
+    UserID          string `json:"userId,omitempty"` // optional, owner of the receipt (e.g. for erasure requests)
 }
 
 // Item defines a single item purchased in a receipt.
@@ -22,6 +29,15 @@ type Item struct {
     Price            string `json:"price"`
 }
 
+// PointsBreakdownEntry defines the points awarded to a receipt by a single rule,
+// or by a single item for the rules applied per item.
+type PointsBreakdownEntry struct {
+    RuleID          string `json:"ruleId"`
+    Reason          string `json:"reason"`
+    Points          int64  `json:"points"`
+    Source          string `json:"source,omitempty"` // receipt field or item that triggered the rule, e.g. "total", "items[2]"
+}
+
 
 // Equals
 // @Description    Check if two Receipt structs are equal. Currently only used for hash colision checks.
@@ -33,6 +49,7 @@ func (r *Receipt) Equals(other *Receipt) bool {
         r.PurchaseDate != other.PurchaseDate ||
         r.PurchaseTime != other.PurchaseTime ||
         r.Total != other.Total ||
+        r.UserID != other.UserID ||
         len(r.Items) != len(other.Items) {
         return false
     }
@@ -47,3 +64,30 @@ func (r *Receipt) Equals(other *Receipt) bool {
 
     return true
 }
+
+// ParseCents
+// @Description    Parse an amount with up to 2 decimals (e.g. "35.35", "12.5", "9") to cents, without floating point errors.
+// @Param          amount: string
+// @Return         amount in cents: int64, error: error
+func ParseCents(amount string) (int64, error) {
+    whole, fraction, hasFraction := strings.Cut(amount, ".")
+    if whole == "" || len(fraction) > 2 || (hasFraction && fraction == "") {
+        return 0, fmt.Errorf("[ParseCents] Invalid amount %q", amount)
+    }
+    for len(fraction) < 2 {
+        fraction += "0"
+    }
+
+    var cents int64 = 0
+    for _, c := range whole + fraction {
+        if c < '0' || c > '9' {
+            return 0, fmt.Errorf("[ParseCents] Invalid amount %q", amount)
+        }
+        digit := int64(c - '0')
+        if cents > (math.MaxInt64 - digit) / 10 {
+            return 0, fmt.Errorf("[ParseCents] Amount %q is too large", amount)
+        }
+        cents = cents*10 + digit
+    }
+    return cents, nil
+}
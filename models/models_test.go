@@ -128,4 +128,24 @@ func TestEquals(t *testing.T){
 		Total: "1.51", // different total amount
 	}
 	assert.False(t, receipt1.Equals(&receipt9))
-}
\ No newline at end of file
+
+	receipt10 := receipt2
+	receipt10.UserID = "user-1" // different user
+	assert.False(t, receipt1.Equals(&receipt10))
+}
+
+// Test on parsing amounts to cents
+// expected: exact cents for up to 2 decimals, errors otherwise
+func TestParseCents(t *testing.T) {
+	valid := map[string]int64{"35.35": 3535, "0.29": 29, "12.5": 1250, "9": 900, "0.00": 0, "1234567.89": 123456789}
+	for amount, expected := range valid {
+		cents, err := ParseCents(amount)
+		assert.NoError(t, err, amount)
+		assert.Equal(t, expected, cents, amount)
+	}
+
+	for _, amount := range []string{"", ".50", "1.", "1.234", "-1.00", "1,00", "abc", "1e3", "99999999999999999999"} {
+		_, err := ParseCents(amount)
+		assert.Error(t, err, amount)
+	}
+}
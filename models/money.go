@@ -0,0 +1,167 @@
+// models/v1/money.go
+
+package models
+
+// @Title        models/money.go
+// @Description  Exact decimal amounts, stored as integer cents to avoid floating point rounding errors.
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "math/big"
+)
+
+// Money is an amount in cents (minor units), e.g. Money(435) is "4.35".
+// Amounts are parsed and computed with integers only: 4.35 * 100 is exactly 435 cents, not 434.99999999999994.
+type Money int64
+
+// ParseMoney
+// @Description    Parse an amount of the receipts wire format (pattern "^\\d+\\.\\d{2}$", e.g. "4.35") to Money.
+// @Param          amount: string
+// @Return         money: Money, error: error
+func ParseMoney(amount string) (Money, error) {
+    if !amountPattern.MatchString(amount) {
+        return 0, fmt.Errorf("[ParseMoney] Invalid amount %q, expected 2 decimals (e.g. 6.49)", amount)
+    }
+    cents, err := ParseCents(amount)
+    if err != nil {
+        return 0, fmt.Errorf("[ParseMoney] %w", err)
+    }
+    return Money(cents), nil
+}
+
+// Cents
+// @Description    Get the amount in cents.
+// @Param          none
+// @Return         cents: int64
+func (m Money) Cents() int64 {
+    return int64(m)
+}
+
+// String
+// @Description    Format the amount with 2 decimals, the receipts wire format (e.g. "4.35", "-0.05").
+// @Param          none
+// @Return         amount: string
+func (m Money) String() string {
+    if m < 0 {
+        // -m would overflow for math.MinInt64, format the unsigned value instead
+        abs := uint64(-(m + 1)) + 1
+        return fmt.Sprintf("-%d.%02d", abs/100, abs%100)
+    }
+    return fmt.Sprintf("%d.%02d", m/100, m%100)
+}
+
+// Add
+// @Description    Add two amounts.
+// @Param          other: Money
+// @Return         sum: Money, error: error (on overflow)
+func (m Money) Add(other Money) (Money, error) {
+    if (other > 0 && m > math.MaxInt64-other) || (other < 0 && m < math.MinInt64-other) {
+        return 0, fmt.Errorf("[Money.Add] %v + %v overflows", m, other)
+    }
+    return m + other, nil
+}
+
+// Sub
+// @Description    Subtract an amount.
+// @Param          other: Money
+// @Return         difference: Money, error: error (on overflow)
+func (m Money) Sub(other Money) (Money, error) {
+    if (other < 0 && m > math.MaxInt64+other) || (other > 0 && m < math.MinInt64+other) {
+        return 0, fmt.Errorf("[Money.Sub] %v - %v overflows", m, other)
+    }
+    return m - other, nil
+}
+
+// Mul
+// @Description    Multiply the amount by an integer, e.g. a quantity.
+// @Param          n: int64
+// @Return         product: Money, error: error (on overflow)
+func (m Money) Mul(n int64) (Money, error) {
+    product := new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(n))
+    if !product.IsInt64() {
+        return 0, fmt.Errorf("[Money.Mul] %v * %d overflows", m, n)
+    }
+    return Money(product.Int64()), nil
+}
+
+// IsWholeDollar
+// @Description    Check if the amount has no cents.
+// @Param          none
+// @Return         true if the amount is a round dollar amount: bool
+func (m Money) IsWholeDollar() bool {
+    return m%100 == 0
+}
+
+// IsMultipleOf
+// @Description    Check if the amount is a multiple of another amount (e.g. of 0.25).
+// @Param          other: Money (must not be zero)
+// @Return         true if the amount is a multiple of other: bool
+func (m Money) IsMultipleOf(other Money) bool {
+    if other == 0 {
+        return false
+    }
+    return m%other == 0
+}
+
+// MulRatCeilDollars
+// @Description    Multiply the amount by the fraction num/den, and round the result up to a whole number of dollars,
+//                 e.g. 12.25 * 2/10 = 2.45 rounded up to 3. Computed exactly, without floating point.
+// @Param          num: int64, den: int64 (must be positive)
+// @Return         whole dollars: int64, error: error
+func (m Money) MulRatCeilDollars(num int64, den int64) (int64, error) {
+    if den <= 0 {
+        return 0, fmt.Errorf("[Money.MulRatCeilDollars] Invalid denominator %d", den)
+    }
+    numerator := new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(num))
+    denominator := new(big.Int).Mul(big.NewInt(den), big.NewInt(100))
+    // ceil(a / b) = -floor(-a / b), big.Int.Div rounds towards negative infinity for a positive divisor
+    dollars := new(big.Int).Div(numerator.Neg(numerator), denominator)
+    dollars.Neg(dollars)
+    if !dollars.IsInt64() {
+        return 0, fmt.Errorf("[Money.MulRatCeilDollars] %v * %d/%d overflows", m, num, den)
+    }
+    return dollars.Int64(), nil
+}
+
+// MarshalJSON
+// @Description    Encode the amount as a string with 2 decimals, the receipts wire format (e.g. "4.35").
+// @Param          none
+// @Return         json: []byte, error: error
+func (m Money) MarshalJSON() ([]byte, error) {
+    return json.Marshal(m.String())
+}
+
+// UnmarshalJSON
+// @Description    Decode an amount string of the receipts wire format (e.g. "4.35").
+// @Param          data: []byte
+// @Return         error: error
+func (m *Money) UnmarshalJSON(data []byte) error {
+    var amount string
+    if err := json.Unmarshal(data, &amount); err != nil {
+        return fmt.Errorf("[Money.UnmarshalJSON] Amount must be a string, got %s", data)
+    }
+    money, err := ParseMoney(amount)
+    if err != nil {
+        return err
+    }
+    *m = money
+    return nil
+}
+
+// TotalAmount
+// @Description    Parse the total of the receipt.
+// @Param          none
+// @Return         total: Money, error: error
+func (r *Receipt) TotalAmount() (Money, error) {
+    return ParseMoney(r.Total)
+}
+
+// PriceAmount
+// @Description    Parse the price of the item.
+// @Param          none
+// @Return         price: Money, error: error
+func (i *Item) PriceAmount() (Money, error) {
+    return ParseMoney(i.Price)
+}
@@ -0,0 +1,126 @@
+// models/money_test.go
+// Tests for the exact decimal money type
+
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test on parsing and formatting amounts
+// expected: exact cents for the "^\d+\.\d{2}$" amounts, errors otherwise
+func TestParseMoney(t *testing.T) {
+	valid := map[string]Money{"4.35": 435, "0.29": 29, "1.15": 115, "0.57": 57, "0.00": 0, "35.35": 3535, "92233720368547758.07": math.MaxInt64}
+	for amount, expected := range valid {
+		money, err := ParseMoney(amount)
+		assert.NoError(t, err, amount)
+		assert.Equal(t, expected, money, amount)
+		assert.Equal(t, amount, money.String())
+	}
+
+	for _, amount := range []string{"", "4", "4.3", "4.355", ".35", "-4.35", "4,35", "1e3", "92233720368547758.08"} {
+		_, err := ParseMoney(amount)
+		assert.Error(t, err, amount)
+	}
+
+	assert.Equal(t, "-0.05", Money(-5).String())
+	assert.Equal(t, "-92233720368547758.08", Money(math.MinInt64).String())
+}
+
+// Test on the amounts that float64 gets wrong
+// expected: the float64 computations are off by a cent, Money is exact
+func TestMoney_FloatPitfalls(t *testing.T) {
+	for amount, cents := range map[string]int64{"4.35": 435, "0.29": 29, "1.15": 115, "0.57": 57, "2.30": 230} {
+		float, _ := strconv.ParseFloat(amount, 64)
+		assert.NotEqual(t, cents, int64(float*100), amount) // the former float conversion truncates 434.99999999999994 to 434
+
+		money, _ := ParseMoney(amount)
+		assert.Equal(t, cents, money.Cents(), amount)
+	}
+
+	// 0.10 + 0.20 is 0.30000000000000004 in float64
+	sum, err := Money(10).Add(Money(20))
+	assert.NoError(t, err)
+	assert.Equal(t, "0.30", sum.String())
+
+	// 50.00 * 1.1 is 55.00000000000001 in float64, rounded up to 56
+	dollars, err := Money(5000).MulRatCeilDollars(11, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(55), dollars)
+}
+
+// Test on the arithmetic helpers
+// expected: exact results, errors on overflow
+func TestMoney_Arithmetic(t *testing.T) {
+	difference, err := Money(435).Sub(Money(500))
+	assert.NoError(t, err)
+	assert.Equal(t, Money(-65), difference)
+
+	product, err := Money(225).Mul(3)
+	assert.NoError(t, err)
+	assert.Equal(t, Money(675), product)
+
+	_, err = Money(math.MaxInt64).Add(1)
+	assert.Error(t, err)
+	_, err = Money(math.MinInt64).Sub(1)
+	assert.Error(t, err)
+	_, err = Money(math.MaxInt64).Mul(2)
+	assert.Error(t, err)
+
+	assert.True(t, Money(900).IsWholeDollar())
+	assert.False(t, Money(901).IsWholeDollar())
+	assert.True(t, Money(435).IsMultipleOf(5))
+	assert.False(t, Money(435).IsMultipleOf(25))
+	assert.False(t, Money(435).IsMultipleOf(0))
+
+	// 12.25 * 0.2 = 2.45, rounded up to 3
+	dollars, err := Money(1225).MulRatCeilDollars(2, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), dollars)
+	// 10.00 * 0.2 = 2, already a whole number
+	dollars, err = Money(1000).MulRatCeilDollars(2, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), dollars)
+	_, err = Money(1000).MulRatCeilDollars(2, 0)
+	assert.Error(t, err)
+	_, err = Money(math.MaxInt64).MulRatCeilDollars(math.MaxInt64, 1)
+	assert.Error(t, err)
+}
+
+// Test on the JSON encoding
+// expected: the string wire format is preserved
+func TestMoney_JSON(t *testing.T) {
+	var decoded struct {
+		Total Money `json:"total"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(`{"total": "4.35"}`), &decoded))
+	assert.Equal(t, Money(435), decoded.Total)
+
+	encoded, err := json.Marshal(decoded)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"total": "4.35"}`, string(encoded))
+
+	assert.Error(t, json.Unmarshal([]byte(`{"total": 4.35}`), &decoded))
+	assert.Error(t, json.Unmarshal([]byte(`{"total": "4.3"}`), &decoded))
+}
+
+// Test on the amount accessors of the receipts
+// expected: the total and the prices are parsed to Money
+func TestReceipt_Amounts(t *testing.T) {
+	receipt := Receipt{Total: "4.35", Items: []Item{{ShortDescription: "Gatorade", Price: "1.15"}, {Price: "1.1"}}}
+	total, err := receipt.TotalAmount()
+	assert.NoError(t, err)
+	assert.Equal(t, Money(435), total)
+
+	price, err := receipt.Items[0].PriceAmount()
+	assert.NoError(t, err)
+	assert.Equal(t, Money(115), price)
+
+	_, err = receipt.Items[1].PriceAmount()
+	assert.Error(t, err)
+}
@@ -0,0 +1,115 @@
+// models/v1/normalize.go
+
+package models
+
+// @Title        models/normalize.go
+// @Description  Canonical form of the receipts, so that the same receipt always gets the same ID whatever its formatting.
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// CasePolicy defines how the letter case of the retailer and the item descriptions is normalized.
+type CasePolicy string
+
+const (
+    CasePreserve CasePolicy = "preserve" // the case is kept as submitted
+    CaseLower    CasePolicy = "lower"    // "M&M Corner Market" becomes "m&m corner market"
+    CaseUpper    CasePolicy = "upper"    // "M&M Corner Market" becomes "M&M CORNER MARKET"
+)
+
+// NormalizationPolicy configures the canonical form of the receipts.
+type NormalizationPolicy struct {
+    CollapseWhitespace bool       // trim the texts and collapse their inner runs of whitespace to a single space
+    Case               CasePolicy // case of the retailer and the item descriptions
+    SortItems          bool       // sort the items by description then price, the order of the items is not significant
+    FormatAmounts      bool       // format the amounts canonically, e.g. "06.49" becomes "6.49"
+}
+
+// DefaultNormalizationPolicy
+// @Description    Get the default policy: whitespace collapsed, case preserved, items sorted, amounts formatted.
+// @Param          none
+// @Return         policy: NormalizationPolicy
+func DefaultNormalizationPolicy() NormalizationPolicy {
+    return NormalizationPolicy{
+        CollapseWhitespace: true,
+        Case:               CasePreserve,
+        SortItems:          true,
+        FormatAmounts:      true,
+    }
+}
+
+// ParseCasePolicy
+// @Description    Parse a case policy (preserve, lower or upper).
+// @Param          value: string
+// @Return         policy: CasePolicy, error: error
+func ParseCasePolicy(value string) (CasePolicy, error) {
+    switch policy := CasePolicy(value); policy {
+    case CasePreserve, CaseLower, CaseUpper:
+        return policy, nil
+    }
+    return "", fmt.Errorf("[ParseCasePolicy] Unknown case policy %q, expected preserve, lower or upper", value)
+}
+
+// Normalize
+// @Description    Get the canonical form of the receipt under the policy: the form that is hashed to the receipt ID
+//                 and compared to detect collisions. The receipt itself is not modified, it is stored and scored as submitted.
+//                 Amounts that cannot be parsed are kept as they are, the validation reports them.
+// @Param          policy: NormalizationPolicy
+// @Return         canonical receipt: Receipt
+func (r *Receipt) Normalize(policy NormalizationPolicy) Receipt {
+    canonical := *r
+    canonical.Retailer = policy.normalizeText(r.Retailer)
+    canonical.Total = policy.normalizeAmount(r.Total)
+
+    canonical.Items = make([]Item, len(r.Items))
+    for i, item := range r.Items {
+        canonical.Items[i] = Item{
+            ShortDescription: policy.normalizeText(item.ShortDescription),
+            Price:            policy.normalizeAmount(item.Price),
+        }
+    }
+    if policy.SortItems {
+        sort.SliceStable(canonical.Items, func(i, j int) bool {
+            if canonical.Items[i].ShortDescription != canonical.Items[j].ShortDescription {
+                return canonical.Items[i].ShortDescription < canonical.Items[j].ShortDescription
+            }
+            return canonical.Items[i].Price < canonical.Items[j].Price
+        })
+    }
+    return canonical
+}
+
+// normalizeText
+// @Description    Apply the whitespace and case policies to a text.
+// @Param          text: string
+// @Return         normalized text: string
+func (p NormalizationPolicy) normalizeText(text string) string {
+    if p.CollapseWhitespace {
+        text = strings.Join(strings.Fields(text), " ")
+    }
+    switch p.Case {
+    case CaseLower:
+        text = strings.ToLower(text)
+    case CaseUpper:
+        text = strings.ToUpper(text)
+    }
+    return text
+}
+
+// normalizeAmount
+// @Description    Apply the amount formatting policy to an amount.
+// @Param          amount: string
+// @Return         normalized amount: string
+func (p NormalizationPolicy) normalizeAmount(amount string) string {
+    if !p.FormatAmounts {
+        return amount
+    }
+    money, err := ParseMoney(amount)
+    if err != nil {
+        return amount
+    }
+    return money.String()
+}
@@ -0,0 +1,70 @@
+// models/normalize_test.go
+// Tests for the canonical form of the receipts
+
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test on normalizing a receipt with the default policy
+// expected: whitespace collapsed, case preserved, items sorted, amounts formatted, the original receipt untouched
+func TestNormalize_Default(t *testing.T) {
+	receipt := Receipt{
+		Retailer:     "  M&M   Corner\tMarket ",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []Item{
+			{ShortDescription: "Klarbrunn  12-PK 12 FL OZ ", Price: "012.00"},
+			{ShortDescription: " Gatorade", Price: "2.25"},
+		},
+		Total: "14.25",
+	}
+	canonical := receipt.Normalize(DefaultNormalizationPolicy())
+
+	assert.Equal(t, Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Klarbrunn 12-PK 12 FL OZ", Price: "12.00"},
+		},
+		Total: "14.25",
+	}, canonical)
+	assert.Equal(t, " Gatorade", receipt.Items[1].ShortDescription)
+
+	// the canonical form is stable
+	assert.Equal(t, canonical, canonical.Normalize(DefaultNormalizationPolicy()))
+}
+
+// Test on normalizing a receipt with the other policies
+// expected: each option can be turned off, and the case can be changed
+func TestNormalize_Policies(t *testing.T) {
+	receipt := Receipt{
+		Retailer: " Target ",
+		Items:    []Item{{ShortDescription: "Mountain Dew", Price: "6.49"}, {ShortDescription: "Doritos", Price: "3.5"}},
+		Total:    "09.99",
+	}
+
+	canonical := receipt.Normalize(NormalizationPolicy{})
+	assert.Equal(t, receipt, canonical)
+
+	canonical = receipt.Normalize(NormalizationPolicy{CollapseWhitespace: true, Case: CaseLower, SortItems: true, FormatAmounts: true})
+	assert.Equal(t, "target", canonical.Retailer)
+	assert.Equal(t, []Item{{ShortDescription: "doritos", Price: "3.5"}, {ShortDescription: "mountain dew", Price: "6.49"}}, canonical.Items)
+	assert.Equal(t, "9.99", canonical.Total)
+
+	canonical = receipt.Normalize(NormalizationPolicy{Case: CaseUpper})
+	assert.Equal(t, " TARGET ", canonical.Retailer)
+
+	for _, value := range []string{"preserve", "lower", "upper"} {
+		policy, err := ParseCasePolicy(value)
+		assert.NoError(t, err)
+		assert.Equal(t, CasePolicy(value), policy)
+	}
+	_, err := ParseCasePolicy("title")
+	assert.Error(t, err)
+}
@@ -0,0 +1,119 @@
+// models/v1/validation.go
+
+package models
+
+// @Title        models/validation.go
+// @Description  Field level validation of the receipts, checked up front before any calculation.
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+    "time"
+)
+
+// validation error codes, stable identifiers for the clients
+const (
+    CodeRequired      = "required"       // the field is missing or blank
+    CodeInvalidFormat = "invalid_format" // the field does not match its pattern
+    CodeInvalidDate   = "invalid_date"   // not a YYYY-MM-DD calendar date
+    CodeInvalidTime   = "invalid_time"   // not a HH:MM 24-hour time
+    CodeInvalidAmount = "invalid_amount" // not an amount with 2 decimals, e.g. 6.49
+)
+
+// patterns of the receipt fields (from the API specification)
+var (
+    retailerPattern    = regexp.MustCompile(`^[\w\s&-]+$`)
+    descriptionPattern = regexp.MustCompile(`^[\w\s-]+$`)
+    amountPattern      = regexp.MustCompile(`^\d+\.\d{2}$`)
+    timePattern        = regexp.MustCompile(`^\d{2}:\d{2}$`)
+    userIDPattern      = regexp.MustCompile(`^[\w.@+-]{1,128}$`)
+)
+
+// FieldError defines a single invalid field of a receipt.
+type FieldError struct {
+    Field   string `json:"field"`   // path of the field, e.g. "items[2].price"
+    Code    string `json:"code"`    // one of the Code... constants
+    Message string `json:"message"` // human readable explanation
+}
+
+// FieldErrors lists the invalid fields of a receipt, in the order of the fields.
+type FieldErrors []FieldError
+
+// Error
+// @Description    Summarize the invalid fields, so FieldErrors can be used as an error.
+// @Param          none
+// @Return         error message: string
+func (e FieldErrors) Error() string {
+    messages := make([]string, 0, len(e))
+    for _, fieldError := range e {
+        messages = append(messages, fmt.Sprintf("%v: %v", fieldError.Field, fieldError.Message))
+    }
+    return strings.Join(messages, "; ")
+}
+
+// Validate
+// @Description    Check every field of the receipt, and report all the invalid fields at once (not only the first one).
+// @Param          none
+// @Return         invalid fields (nil if the receipt is valid): FieldErrors
+func (r *Receipt) Validate() FieldErrors {
+    var errors FieldErrors
+    add := func(field string, code string, format string, args ...any) {
+        errors = append(errors, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+    }
+
+    switch {
+    case strings.TrimSpace(r.Retailer) == "":
+        add("retailer", CodeRequired, "the retailer is required")
+    case !retailerPattern.MatchString(r.Retailer):
+        add("retailer", CodeInvalidFormat, "the retailer may only contain letters, digits, spaces, '-' and '&'")
+    }
+
+    if r.PurchaseDate == "" {
+        add("purchaseDate", CodeRequired, "the purchase date is required")
+    } else if _, err := time.Parse("2006-01-02", r.PurchaseDate); err != nil {
+        add("purchaseDate", CodeInvalidDate, "the purchase date must be a date formatted as YYYY-MM-DD")
+    }
+
+    if r.PurchaseTime == "" {
+        add("purchaseTime", CodeRequired, "the purchase time is required")
+    } else if _, err := time.Parse("15:04", r.PurchaseTime); err != nil || !timePattern.MatchString(r.PurchaseTime) {
+        add("purchaseTime", CodeInvalidTime, "the purchase time must be a 24-hour time formatted as HH:MM")
+    }
+
+    if len(r.Items) == 0 {
+        add("items", CodeRequired, "at least one item is required")
+    }
+    for i, item := range r.Items {
+        switch {
+        case strings.TrimSpace(item.ShortDescription) == "":
+            add(fmt.Sprintf("items[%d].shortDescription", i), CodeRequired, "the item description is required")
+        case !descriptionPattern.MatchString(item.ShortDescription):
+            add(fmt.Sprintf("items[%d].shortDescription", i), CodeInvalidFormat, "the item description may only contain letters, digits, spaces and '-'")
+        }
+        checkAmount(&errors, fmt.Sprintf("items[%d].price", i), item.Price)
+    }
+
+    checkAmount(&errors, "total", r.Total)
+
+    // the user ID is optional, it identifies the points account of the user (/users/{id})
+    if r.UserID != "" && !userIDPattern.MatchString(r.UserID) {
+        add("userId", CodeInvalidFormat, "the user ID may only contain up to 128 letters, digits and '.', '@', '+', '-'")
+    }
+
+    return errors
+}
+
+// checkAmount
+// @Description    Check an amount field (required, 2 decimals).
+// @Param          errors: *FieldErrors (the error is appended to it), field: string, amount: string
+// @Return         none
+func checkAmount(errors *FieldErrors, field string, amount string) {
+    if amount == "" {
+        *errors = append(*errors, FieldError{Field: field, Code: CodeRequired, Message: "the amount is required"})
+    } else if !amountPattern.MatchString(amount) {
+        *errors = append(*errors, FieldError{Field: field, Code: CodeInvalidAmount, Message: "the amount must have 2 decimals, e.g. 6.49"})
+    } else if _, err := ParseMoney(amount); err != nil {
+        *errors = append(*errors, FieldError{Field: field, Code: CodeInvalidAmount, Message: "the amount is too large"})
+    }
+}
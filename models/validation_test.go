@@ -0,0 +1,82 @@
+// models/validation_test.go
+// Tests for the receipt validation
+
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// valid receipt used by the validation tests
+func validReceipt() Receipt {
+	return Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Klarbrunn 12-PK 12 FL OZ", Price: "12.00"},
+		},
+		Total: "14.25",
+	}
+}
+
+// Test on validating a valid receipt
+// expected: no error
+func TestValidate_Valid(t *testing.T) {
+	receipt := validReceipt()
+	assert.Nil(t, receipt.Validate())
+}
+
+// Test on validating invalid fields, one at a time
+// expected: a single error with the path and the code of the field
+func TestValidate_Fields(t *testing.T) {
+	cases := []struct {
+		change func(*Receipt)
+		field  string
+		code   string
+	}{
+		{func(r *Receipt) { r.Retailer = "  " }, "retailer", CodeRequired},
+		{func(r *Receipt) { r.Retailer = "Target!" }, "retailer", CodeInvalidFormat},
+		{func(r *Receipt) { r.PurchaseDate = "" }, "purchaseDate", CodeRequired},
+		{func(r *Receipt) { r.PurchaseDate = "2022-02-30" }, "purchaseDate", CodeInvalidDate},
+		{func(r *Receipt) { r.PurchaseDate = "03/20/2022" }, "purchaseDate", CodeInvalidDate},
+		{func(r *Receipt) { r.PurchaseTime = "" }, "purchaseTime", CodeRequired},
+		{func(r *Receipt) { r.PurchaseTime = "14:33:00" }, "purchaseTime", CodeInvalidTime},
+		{func(r *Receipt) { r.PurchaseTime = "24:00" }, "purchaseTime", CodeInvalidTime},
+		{func(r *Receipt) { r.PurchaseTime = "2:33" }, "purchaseTime", CodeInvalidTime},
+		{func(r *Receipt) { r.Items = nil }, "items", CodeRequired},
+		{func(r *Receipt) { r.Items[1].ShortDescription = " " }, "items[1].shortDescription", CodeRequired},
+		{func(r *Receipt) { r.Items[1].ShortDescription = "Pizza (large)" }, "items[1].shortDescription", CodeInvalidFormat},
+		{func(r *Receipt) { r.Items[0].Price = "" }, "items[0].price", CodeRequired},
+		{func(r *Receipt) { r.Items[1].Price = "12" }, "items[1].price", CodeInvalidAmount},
+		{func(r *Receipt) { r.Total = "-14.25" }, "total", CodeInvalidAmount},
+		{func(r *Receipt) { r.Total = "99999999999999999999.00" }, "total", CodeInvalidAmount},
+		{func(r *Receipt) { r.UserID = "alice/receipts" }, "userId", CodeInvalidFormat},
+	}
+	for _, c := range cases {
+		receipt := validReceipt()
+		c.change(&receipt)
+		errors := receipt.Validate()
+		if assert.Len(t, errors, 1, c.field) {
+			assert.Equal(t, c.field, errors[0].Field)
+			assert.Equal(t, c.code, errors[0].Code, c.field)
+			assert.NotEmpty(t, errors[0].Message)
+		}
+	}
+}
+
+// Test on validating a receipt with many invalid fields
+// expected: every invalid field is reported, in the order of the fields
+func TestValidate_Many(t *testing.T) {
+	receipt := Receipt{Items: []Item{{ShortDescription: "Gatorade", Price: "2.2"}}}
+	errors := receipt.Validate()
+	fields := []string{}
+	for _, fieldError := range errors {
+		fields = append(fields, fieldError.Field)
+	}
+	assert.Equal(t, []string{"retailer", "purchaseDate", "purchaseTime", "items[0].price", "total"}, fields)
+	assert.Contains(t, errors.Error(), "items[0].price: ")
+}
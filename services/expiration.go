@@ -0,0 +1,188 @@
+// services/v1/expiration.go
+// Expiration of the points: the points of a receipt expire some months after they are accrued (the purchase date),
+// and all the points of a user expire after some months of inactivity. The expired points are expiry entries of the ledger.
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"receipt-processor/models"
+)
+
+// ExpiryReason is why points expired.
+type ExpiryReason string
+
+const (
+	// ExpiryLifetime is the expiration of points at the end of their lifetime, counted from their accrual date.
+	ExpiryLifetime ExpiryReason = "lifetime"
+	// ExpiryInactivity is the expiration of all the points of a user without credit nor redemption for a while.
+	ExpiryInactivity ExpiryReason = "inactivity"
+)
+
+// ExpirationPolicy defines when the points expire. The zero value never expires the points.
+type ExpirationPolicy struct {
+	LifetimeMonths   int // the points of a receipt expire this many months after their accrual date, never if 0
+	InactivityMonths int // the points of a user expire this many months after their last credit or redemption, never if 0
+}
+
+// UpcomingExpiration is the points of a receipt that will expire unless they are redeemed first.
+type UpcomingExpiration struct {
+	ReceiptID string       `json:"receiptId"`
+	Points    int64        `json:"points"`
+	ExpiresAt time.Time    `json:"expiresAt"`
+	Reason    ExpiryReason `json:"reason"`
+}
+
+// Validate
+// @Description    Check an expiration policy: the numbers of months must not be negative.
+// @Param          none
+// @Return         error: error
+func (p ExpirationPolicy) Validate() error {
+	if p.LifetimeMonths < 0 || p.InactivityMonths < 0 {
+		return fmt.Errorf("[Validate] Expiration months must not be negative, got lifetime %d and inactivity %d", p.LifetimeMonths, p.InactivityMonths)
+	}
+	return nil
+}
+
+// AccrualDate
+// @Description    Get the date the points of a receipt are accrued: its purchase date (midnight UTC),
+//                 or fallback if the purchase date cannot be parsed.
+// @Param          receipt: *models.Receipt, fallback: time.Time
+// @Return         accrual date: time.Time
+func AccrualDate(receipt *models.Receipt, fallback time.Time) time.Time {
+	date, err := parsePurchaseDate(receipt.PurchaseDate)
+	if err != nil {
+		return fallback
+	}
+	return date
+}
+
+// WithExpirationPolicy
+// @Description    Set the expiration policy of the points, applied by ExpirePoints.
+// @Param          policy: ExpirationPolicy
+// @Return         pointer to the ledger: *Ledger
+func (l *Ledger) WithExpirationPolicy(policy ExpirationPolicy) *Ledger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expiration = policy
+	return l
+}
+
+// ExpirePoints
+// @Description    Expire the points that are due at now, oldest lots first: one expiry entry per lot, with the remaining
+//                 points of the lot. The points held by reserved redemptions do not expire; if such a redemption is
+//                 cancelled, its points expire on the next run.
+// @Param          now: time.Time
+// @Return         expiry entries written: []LedgerEntry
+func (l *Ledger) ExpirePoints(now time.Time) []LedgerEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the users are processed in a fixed order, so that the entry IDs do not depend on the map order
+	userIDs := make([]string, 0, len(l.accounts))
+	for userID := range l.accounts {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+
+	expired := []LedgerEntry{}
+	for _, userID := range userIDs {
+		account := l.accounts[userID]
+		for _, lot := range account.lots {
+			if lot.remaining == 0 {
+				continue
+			}
+			expiresAt, reason, expires := l.expiration.expiryOf(lot, account)
+			if !expires || now.Before(expiresAt) {
+				continue
+			}
+			points := lot.remaining
+			lot.remaining = 0
+			expired = append(expired, l.appendLocked(account, LedgerEntry{
+				UserID:    userID,
+				Type:      EntryExpiry,
+				Points:    -points,
+				ReceiptID: lot.receiptID,
+				Consumed:  []LotConsumption{{ReceiptID: lot.receiptID, Points: points}},
+				Reason:    reason,
+				CreatedAt: now,
+			}))
+		}
+	}
+	return expired
+}
+
+// UpcomingExpirations
+// @Description    Get the points of a user that will expire unless they are redeemed first, soonest first.
+// @Param          userID: string
+// @Return         upcoming expirations: []UpcomingExpiration
+func (l *Ledger) UpcomingExpirations(userID string) []UpcomingExpiration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	upcoming := []UpcomingExpiration{}
+	account, exists := l.accounts[userID]
+	if !exists {
+		return upcoming
+	}
+	for _, lot := range account.lots {
+		if lot.remaining == 0 {
+			continue
+		}
+		if expiresAt, reason, expires := l.expiration.expiryOf(lot, account); expires {
+			upcoming = append(upcoming, UpcomingExpiration{ReceiptID: lot.receiptID, Points: lot.remaining, ExpiresAt: expiresAt, Reason: reason})
+		}
+	}
+	// the lots are in FIFO order, keep it between the lots expiring at the same time
+	sort.SliceStable(upcoming, func(i, j int) bool {
+		return upcoming[i].ExpiresAt.Before(upcoming[j].ExpiresAt)
+	})
+	return upcoming
+}
+
+// RunExpiration
+// @Description    Expire the due points at once, then every interval, until ctx is done.
+//                 onExpire (optional) is called with the expiry entries of every run.
+// @Param          ctx: context.Context, interval: time.Duration, onExpire: func([]LedgerEntry)
+// @Return         none
+func (l *Ledger) RunExpiration(ctx context.Context, interval time.Duration, onExpire func([]LedgerEntry)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		expired := l.ExpirePoints(time.Now().UTC())
+		if onExpire != nil {
+			onExpire(expired)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expiryOf
+// @Description    Get when the remaining points of a lot expire under the policy: the sooner of the end of their lifetime
+//                 and the end of the inactivity period of the user.
+// @Param          lot: *ledgerLot, account: *ledgerAccount
+// @Return         expiration time: time.Time, reason: ExpiryReason, expires (false if the policy never expires them): bool
+func (p ExpirationPolicy) expiryOf(lot *ledgerLot, account *ledgerAccount) (time.Time, ExpiryReason, bool) {
+	var expiresAt time.Time
+	var reason ExpiryReason
+	expires := false
+	if p.LifetimeMonths > 0 {
+		expiresAt, reason, expires = lot.accruedAt.AddDate(0, p.LifetimeMonths, 0), ExpiryLifetime, true
+	}
+	if p.InactivityMonths > 0 {
+		if inactiveAt := account.activeAt.AddDate(0, p.InactivityMonths, 0); !expires || inactiveAt.Before(expiresAt) {
+			expiresAt, reason, expires = inactiveAt, ExpiryInactivity, true
+		}
+	}
+	return expiresAt, reason, expires
+}
@@ -0,0 +1,160 @@
+// services/expiration_test.go
+// Tests for the expiration of the points.
+
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"receipt-processor/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// date returns midnight UTC of a day.
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// Test on AccrualDate function
+// expected: the purchase date of the receipt, or the fallback if it cannot be parsed
+func TestAccrualDate(t *testing.T) {
+	fallback := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	assert.Equal(t, date(2022, 3, 20), AccrualDate(&models.Receipt{PurchaseDate: "2022-03-20"}, fallback))
+	assert.Equal(t, fallback, AccrualDate(&models.Receipt{PurchaseDate: "20/03/2022"}, fallback))
+}
+
+// Test on the expiration of the points at the end of their lifetime
+// expected: each lot expires 12 months after its purchase date, with an expiry entry of its remaining points
+func TestLedger_ExpirePoints_Lifetime(t *testing.T) {
+	ledger := NewLedger().WithExpirationPolicy(ExpirationPolicy{LifetimeMonths: 12})
+	processedAt := date(2024, 1, 1)
+	ledger.Credit("alice", "receipt-1", 10, date(2023, 1, 15), processedAt)
+	ledger.Credit("alice", "receipt-2", 20, date(2023, 6, 1), processedAt)
+	ledger.Credit("bob", "receipt-3", 30, date(2023, 2, 1), processedAt)
+	redemption, _ := ledger.Reserve("alice", 4, processedAt)
+	ledger.Confirm("alice", redemption.ID, processedAt)
+
+	assert.Equal(t, []UpcomingExpiration{
+		{ReceiptID: "receipt-1", Points: 6, ExpiresAt: date(2024, 1, 15), Reason: ExpiryLifetime},
+		{ReceiptID: "receipt-2", Points: 20, ExpiresAt: date(2024, 6, 1), Reason: ExpiryLifetime},
+	}, ledger.UpcomingExpirations("alice"))
+
+	// nothing is due yet
+	assert.Empty(t, ledger.ExpirePoints(date(2024, 1, 14)))
+
+	expired := ledger.ExpirePoints(date(2024, 2, 1))
+	if assert.Len(t, expired, 2) {
+		assert.Equal(t, LedgerEntry{
+			ID: expired[0].ID, UserID: "alice", Type: EntryExpiry, Points: -6, Balance: 20, ReceiptID: "receipt-1",
+			Consumed: []LotConsumption{{ReceiptID: "receipt-1", Points: 6}}, Reason: ExpiryLifetime, CreatedAt: date(2024, 2, 1),
+		}, expired[0])
+		assert.Equal(t, "bob", expired[1].UserID)
+		assert.Equal(t, int64(-30), expired[1].Points)
+	}
+	assert.Equal(t, int64(20), ledger.Balance("alice"))
+	assert.Equal(t, int64(0), ledger.Balance("bob"))
+
+	// running again does not expire the same points twice
+	assert.Empty(t, ledger.ExpirePoints(date(2024, 2, 1)))
+	assert.Len(t, ledger.UpcomingExpirations("alice"), 1)
+	assert.Equal(t, []UpcomingExpiration{}, ledger.UpcomingExpirations("bob"))
+	assert.Equal(t, []UpcomingExpiration{}, ledger.UpcomingExpirations("carol"))
+}
+
+// Test on the expiration of the points on inactivity
+// expected: all the points of a user expire, oldest first, after months without credit nor redemption
+func TestLedger_ExpirePoints_Inactivity(t *testing.T) {
+	ledger := NewLedger().WithExpirationPolicy(ExpirationPolicy{LifetimeMonths: 24, InactivityMonths: 6})
+	ledger.Credit("alice", "receipt-1", 10, date(2024, 1, 1), date(2024, 1, 2))
+	ledger.Credit("alice", "receipt-2", 20, date(2024, 3, 1), date(2024, 3, 2))
+
+	upcoming := ledger.UpcomingExpirations("alice")
+	assert.Equal(t, []UpcomingExpiration{
+		{ReceiptID: "receipt-1", Points: 10, ExpiresAt: date(2024, 9, 2), Reason: ExpiryInactivity},
+		{ReceiptID: "receipt-2", Points: 20, ExpiresAt: date(2024, 9, 2), Reason: ExpiryInactivity},
+	}, upcoming)
+
+	// a redemption is an activity, it postpones the expiration
+	redemption, _ := ledger.Reserve("alice", 5, date(2024, 5, 1))
+	ledger.Confirm("alice", redemption.ID, date(2024, 5, 1))
+	assert.Empty(t, ledger.ExpirePoints(date(2024, 9, 2)))
+
+	expired := ledger.ExpirePoints(date(2024, 11, 1))
+	if assert.Len(t, expired, 2) {
+		assert.Equal(t, "receipt-1", expired[0].ReceiptID)
+		assert.Equal(t, int64(-5), expired[0].Points)
+		assert.Equal(t, ExpiryInactivity, expired[0].Reason)
+		assert.Equal(t, "receipt-2", expired[1].ReceiptID)
+		assert.Equal(t, int64(0), expired[1].Balance)
+	}
+}
+
+// Test on the expiration of the points held by a reservation
+// expected: the held points do not expire, they expire on the next run if the reservation is cancelled
+func TestLedger_ExpirePoints_Reserved(t *testing.T) {
+	ledger := NewLedger().WithExpirationPolicy(ExpirationPolicy{LifetimeMonths: 12})
+	ledger.Credit("alice", "receipt-1", 10, date(2023, 1, 1), date(2023, 1, 1))
+	redemption, _ := ledger.Reserve("alice", 8, date(2023, 12, 1))
+
+	expired := ledger.ExpirePoints(date(2024, 1, 2))
+	if assert.Len(t, expired, 1) {
+		assert.Equal(t, int64(-2), expired[0].Points)
+	}
+	available, held := ledger.Available("alice")
+	assert.Equal(t, int64(0), available)
+	assert.Equal(t, int64(8), held)
+
+	ledger.Cancel("alice", redemption.ID, date(2024, 1, 3))
+	expired = ledger.ExpirePoints(date(2024, 1, 4))
+	if assert.Len(t, expired, 1) {
+		assert.Equal(t, int64(-8), expired[0].Points)
+	}
+	assert.Equal(t, int64(0), ledger.Balance("alice"))
+}
+
+// Test on the ExpirationPolicy
+// expected: negative months are refused, the zero policy never expires the points
+func TestExpirationPolicy(t *testing.T) {
+	assert.NoError(t, ExpirationPolicy{}.Validate())
+	assert.NoError(t, ExpirationPolicy{LifetimeMonths: 12, InactivityMonths: 6}.Validate())
+	assert.Error(t, ExpirationPolicy{LifetimeMonths: -1}.Validate())
+	assert.Error(t, ExpirationPolicy{InactivityMonths: -1}.Validate())
+
+	ledger := NewLedger()
+	ledger.Credit("alice", "receipt-1", 10, date(2000, 1, 1), date(2000, 1, 1))
+	assert.Empty(t, ledger.ExpirePoints(date(2100, 1, 1)))
+	assert.Empty(t, ledger.UpcomingExpirations("alice"))
+}
+
+// Test on RunExpiration function
+// expected: the due points expire at once, and the runner stops with its context
+func TestLedger_RunExpiration(t *testing.T) {
+	ledger := NewLedger().WithExpirationPolicy(ExpirationPolicy{LifetimeMonths: 12})
+	ledger.Credit("alice", "receipt-1", 10, date(2020, 1, 1), date(2020, 1, 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := make(chan []LedgerEntry, 10)
+	done := make(chan struct{})
+	go func() {
+		ledger.RunExpiration(ctx, time.Millisecond, func(expired []LedgerEntry) {
+			select {
+			case runs <- expired:
+			default: // the test does not read the later runs
+			}
+		})
+		close(done)
+	}()
+
+	assert.Len(t, <-runs, 1)
+	assert.Empty(t, <-runs)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RunExpiration did not stop with its context")
+	}
+	assert.Equal(t, int64(0), ledger.Balance("alice"))
+}
@@ -0,0 +1,635 @@
+// services/v1/ledger.go
+// Points ledger of the users: every change of the points balance of a user is an entry of the ledger.
+// The balance of a user is the sum of the points of their entries.
+// The points of each credited receipt form a lot, and the redemptions consume the lots oldest first.
+// A reversed receipt takes its points back from its lot first, then from the other lots; points that are spent already
+// become a debt (a negative available balance), repaid by the next credits.
+
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInsufficientPoints is returned when a redemption asks for more points than available.
+	ErrInsufficientPoints = errors.New("insufficient points")
+	// ErrRedemptionNotFound is returned for an unknown redemption.
+	ErrRedemptionNotFound = errors.New("redemption not found")
+	// ErrRedemptionSettled is returned when confirming a cancelled redemption, or cancelling a confirmed one.
+	ErrRedemptionSettled = errors.New("redemption already settled")
+	// ErrReversalConflict is returned when a reversal of a receipt is applied again with other points.
+	ErrReversalConflict = errors.New("reversal already applied with other points")
+)
+
+// LedgerEntryType is the kind of a ledger entry.
+type LedgerEntryType string
+
+const (
+	// EntryCredit is the points earned by a processed receipt.
+	EntryCredit LedgerEntryType = "credit"
+	// EntryRedemption is the points spent by a confirmed redemption.
+	EntryRedemption LedgerEntryType = "redemption"
+	// EntryExpiry is the points of a receipt that expired before being redeemed.
+	EntryExpiry LedgerEntryType = "expiry"
+	// EntryReversal is the points taken back from a reversed receipt (e.g. a refund).
+	EntryReversal LedgerEntryType = "reversal"
+	// EntryDeletion is the points taken back from a deleted receipt.
+	EntryDeletion LedgerEntryType = "deletion"
+)
+
+// LedgerEntry is a single change of the points balance of a user.
+type LedgerEntry struct {
+	ID           int64            `json:"id"`                     // sequence number, increasing in the order of the entries
+	UserID       string           `json:"userId"`
+	Type         LedgerEntryType  `json:"type"`
+	Points       int64            `json:"points"`                 // signed change of the balance
+	Balance      int64            `json:"balance"`                // balance of the user after the entry
+	ReceiptID    string           `json:"receiptId,omitempty"`    // receipt that caused the entry, if any
+	RedemptionID string           `json:"redemptionId,omitempty"` // redemption that caused the entry, if any
+	Consumed     []LotConsumption `json:"consumed,omitempty"`     // points taken from the lot of each receipt, for a redemption or a reversal
+	Reason       ExpiryReason     `json:"reason,omitempty"`       // why the points expired, for an expiry
+	CreatedAt    time.Time        `json:"createdAt"`
+}
+
+// LotConsumption is the points a redemption takes from the lot of a credited receipt.
+type LotConsumption struct {
+	ReceiptID string `json:"receiptId"`
+	Points    int64  `json:"points"`
+}
+
+// RedemptionStatus is the state of a redemption.
+type RedemptionStatus string
+
+const (
+	// RedemptionReserved is a redemption holding points until it is confirmed or cancelled.
+	RedemptionReserved RedemptionStatus = "reserved"
+	// RedemptionConfirmed is a redemption whose points are spent, it has a ledger entry.
+	RedemptionConfirmed RedemptionStatus = "confirmed"
+	// RedemptionCancelled is a reservation whose points went back to their lots.
+	RedemptionCancelled RedemptionStatus = "cancelled"
+)
+
+// Redemption is a spending of points by a user, reserved then confirmed or cancelled.
+type Redemption struct {
+	ID        string           `json:"id"`
+	UserID    string           `json:"userId"`
+	Points    int64            `json:"points"`
+	Status    RedemptionStatus `json:"status"`
+	Consumed  []LotConsumption `json:"consumed"`          // lots the points are taken from, oldest first
+	EntryID   int64            `json:"entryId,omitempty"` // ledger entry of a confirmed redemption
+	CreatedAt time.Time        `json:"createdAt"`
+	SettledAt *time.Time       `json:"settledAt,omitempty"` // time of the confirmation or cancellation
+}
+
+// RedemptionRecorder persists the redemptions, so that they survive a restart (see RestoreRedemption).
+type RedemptionRecorder interface {
+	Record(redemption Redemption) error
+}
+
+// RedemptionRecorderFunc adapts a function to a RedemptionRecorder.
+type RedemptionRecorderFunc func(redemption Redemption) error
+
+// Record
+// @Description    Call the function with the redemption.
+// @Param          redemption: Redemption
+// @Return         error: error
+func (f RedemptionRecorderFunc) Record(redemption Redemption) error {
+	return f(redemption)
+}
+
+// ledgerLot is the points of a credited receipt that are not spent, held by a redemption or expired yet.
+type ledgerLot struct {
+	receiptID string
+	remaining int64
+	accruedAt time.Time // date the points were earned, the start of their lifetime
+}
+
+// ledgerAccount holds the entries of a single user.
+type ledgerAccount struct {
+	entries     []LedgerEntry
+	balance     int64
+	held        int64                  // points held by the reserved redemptions
+	credits     map[string]int         // index of the credit entry of each receipt, a receipt is credited at most once
+	reversals   map[string]int         // index of the entry of each reversal of a receipt, a reversal is applied at most once
+	lots        []*ledgerLot           // one lot per credited receipt, oldest first
+	lotIndex    map[string]*ledgerLot  // lot of each credited receipt
+	redemptions map[string]*Redemption // redemptions of the user by ID
+	activeAt    time.Time              // time of the last credit or redemption of the user
+}
+
+// Ledger records the points of the users. It is safe for concurrent use:
+// every change of an account is applied under a single lock, so the balances stay consistent with the entries.
+type Ledger struct {
+	mu         sync.Mutex
+	accounts   map[string]*ledgerAccount
+	nextID     int64
+	expiration ExpirationPolicy
+	recorder   RedemptionRecorder // records every change of a redemption before it is applied, nil if not persisted
+}
+
+// NewLedger
+// @Description    Create an empty ledger.
+// @Param          none
+// @Return         pointer to the ledger: *Ledger
+func NewLedger() *Ledger {
+	return &Ledger{
+		accounts: map[string]*ledgerAccount{},
+		nextID:   1,
+	}
+}
+
+// WithRedemptionRecorder
+// @Description    Set the recorder of the redemptions: every new state of a redemption is recorded before it is applied,
+//                 and a redemption that cannot be recorded does not change.
+// @Param          recorder: RedemptionRecorder
+// @Return         pointer to the ledger: *Ledger
+func (l *Ledger) WithRedemptionRecorder(recorder RedemptionRecorder) *Ledger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recorder = recorder
+	return l
+}
+
+// Credit
+// @Description    Credit a user with the points of a processed receipt. A receipt is credited only once,
+//                 crediting it again (duplicate or concurrent submissions) returns the original entry.
+//                 The points are accrued at accruedAt (see AccrualDate), their lifetime starts then.
+// @Param          userID: string, receiptID: string, points: int64, accruedAt: time.Time, at: time.Time
+// @Return         entry: LedgerEntry, created (false if the receipt was already credited): bool, error: error
+func (l *Ledger) Credit(userID string, receiptID string, points int64, accruedAt time.Time, at time.Time) (LedgerEntry, bool, error) {
+	if userID == "" || receiptID == "" {
+		return LedgerEntry{}, false, fmt.Errorf("[Credit] User ID and receipt ID are required")
+	}
+	if points < 0 {
+		return LedgerEntry{}, false, fmt.Errorf("[Credit] Points must not be negative, got %d", points)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	account := l.accountLocked(userID)
+	if index, credited := account.credits[receiptID]; credited {
+		return account.entries[index], false, nil
+	}
+
+	// the points first repay the debt of the reversals, if any
+	repaid := min(points, account.debt())
+	entry := l.appendLocked(account, LedgerEntry{UserID: userID, Type: EntryCredit, Points: points, ReceiptID: receiptID, CreatedAt: at})
+	account.credits[receiptID] = len(account.entries) - 1
+	lot := &ledgerLot{receiptID: receiptID, remaining: points - repaid, accruedAt: accruedAt}
+	account.lots = append(account.lots, lot)
+	account.lotIndex[receiptID] = lot
+	account.touch(at)
+	return entry, true, nil
+}
+
+// Reverse
+// @Description    Take back points of a reversed receipt of a user, with a reversal entry of the ledger: the points are taken
+//                 from the lot of the receipt first, then from the other lots oldest first. The points held by the reserved
+//                 redemptions are not taken; the points already spent become a debt, so the available balance may be negative.
+//                 Each reversal of a receipt is applied only once (index is its position among the reversals of the receipt),
+//                 applying it again returns the original entry (with other points, it is refused). Taking back 0 points writes no entry.
+// @Param          userID: string, receiptID: string, index: int, points: int64, at: time.Time
+// @Return         entry: LedgerEntry, created (false if the reversal was already applied or has no points): bool,
+//                 error: error (wrapping ErrReversalConflict if the reversal was applied with other points)
+func (l *Ledger) Reverse(userID string, receiptID string, index int, points int64, at time.Time) (LedgerEntry, bool, error) {
+	if userID == "" || receiptID == "" {
+		return LedgerEntry{}, false, fmt.Errorf("[Reverse] User ID and receipt ID are required")
+	}
+	if points < 0 || index < 0 {
+		return LedgerEntry{}, false, fmt.Errorf("[Reverse] Points and index must not be negative, got %d and %d", points, index)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	account := l.accountLocked(userID)
+	key := fmt.Sprintf("%s#%d", receiptID, index)
+	if entryIndex, reversed := account.reversals[key]; reversed {
+		if entry := account.entries[entryIndex]; entry.Points != -points {
+			return LedgerEntry{}, false, fmt.Errorf("[Reverse] Reversal %d of receipt %s took back %d points, not %d: %w", index, receiptID, -entry.Points, points, ErrReversalConflict)
+		}
+		return account.entries[entryIndex], false, nil
+	}
+	if points == 0 {
+		return LedgerEntry{}, false, nil
+	}
+
+	entry := l.appendLocked(account, LedgerEntry{
+		UserID:    userID,
+		Type:      EntryReversal,
+		Points:    -points,
+		ReceiptID: receiptID,
+		Consumed:  account.takeBack(receiptID, points),
+		CreatedAt: at,
+	})
+	account.reversals[key] = len(account.entries) - 1
+	return entry, true, nil
+}
+
+// RemoveReceipt
+// @Description    Take back the points of a deleted receipt of a user, with a deletion entry of the ledger: the points credited
+//                 for the receipt less the points taken back by its reversals, taken like the points of a reversal (see Reverse).
+//                 The receipt is then forgotten, so that the balance matches a ledger rebuilt without it.
+//                 Removing a receipt that is not credited (unknown or already removed) writes nothing.
+// @Param          userID: string, receiptID: string, at: time.Time
+// @Return         entry: LedgerEntry, removed (false if the receipt is not credited): bool
+func (l *Ledger) RemoveReceipt(userID string, receiptID string, at time.Time) (LedgerEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	account, exists := l.accounts[userID]
+	if !exists {
+		return LedgerEntry{}, false
+	}
+	index, credited := account.credits[receiptID]
+	if !credited {
+		return LedgerEntry{}, false
+	}
+
+	points := account.entries[index].Points
+	prefix := receiptID + "#"
+	for key, reversal := range account.reversals {
+		if strings.HasPrefix(key, prefix) {
+			points += account.entries[reversal].Points
+			delete(account.reversals, key)
+		}
+	}
+	delete(account.credits, receiptID)
+
+	entry := l.appendLocked(account, LedgerEntry{
+		UserID:    userID,
+		Type:      EntryDeletion,
+		Points:    -max(points, 0),
+		ReceiptID: receiptID,
+		Consumed:  account.takeBack(receiptID, max(points, 0)),
+		CreatedAt: at,
+	})
+	return entry, true
+}
+
+// Available
+// @Description    Get the points a user can redeem: the balance less the points held by the reserved redemptions.
+// @Param          userID: string
+// @Return         available points: int64, held points: int64
+func (l *Ledger) Available(userID string) (int64, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if account, exists := l.accounts[userID]; exists {
+		return account.balance - account.held, account.held
+	}
+	return 0, 0
+}
+
+// Reserve
+// @Description    Reserve points of a user for a redemption: the points are taken from the lots oldest first and held
+//                 until the redemption is confirmed or cancelled. A reservation never overdraws the available points,
+//                 even under concurrent redemptions.
+// @Param          userID: string, points: int64, at: time.Time
+// @Return         redemption: Redemption, error: error (wrapping ErrInsufficientPoints if the points are not available)
+func (l *Ledger) Reserve(userID string, points int64, at time.Time) (Redemption, error) {
+	if userID == "" {
+		return Redemption{}, fmt.Errorf("[Reserve] User ID is required")
+	}
+	if points <= 0 {
+		return Redemption{}, fmt.Errorf("[Reserve] Points must be positive, got %d", points)
+	}
+	id, err := newRedemptionID()
+	if err != nil {
+		return Redemption{}, fmt.Errorf("[Reserve] Error generating the redemption ID: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	account := l.accountLocked(userID)
+	if available := account.balance - account.held; points > available {
+		return Redemption{}, fmt.Errorf("[Reserve] %d points requested, %d available: %w", points, available, ErrInsufficientPoints)
+	}
+
+	redemption := &Redemption{ID: id, UserID: userID, Points: points, Status: RedemptionReserved, Consumed: []LotConsumption{}, CreatedAt: at}
+	for _, lot := range account.lots {
+		if points == 0 {
+			break
+		}
+		if lot.remaining == 0 {
+			continue
+		}
+		taken := min(lot.remaining, points)
+		points -= taken
+		redemption.Consumed = append(redemption.Consumed, LotConsumption{ReceiptID: lot.receiptID, Points: taken})
+	}
+	if err := l.recordLocked(redemption); err != nil {
+		return Redemption{}, fmt.Errorf("[Reserve] %w", err)
+	}
+	account.hold(redemption)
+	return redemption.copy(), nil
+}
+
+// RestoreRedemption
+// @Description    Reserve a redemption recorded by a previous run again, with its ID, points and lots: used to rebuild the
+//                 ledger in the order things happened, the redemption is then confirmed or cancelled like a new one.
+//                 Nothing is recorded. The points of lots that no longer exist (e.g. deleted receipts) are only held.
+// @Param          redemption: Redemption (its status is ignored, the restored redemption is reserved)
+// @Return         error: error (missing IDs, points not positive, or a redemption with the same ID)
+func (l *Ledger) RestoreRedemption(redemption Redemption) error {
+	if redemption.ID == "" || redemption.UserID == "" {
+		return fmt.Errorf("[RestoreRedemption] Redemption and user IDs are required")
+	}
+	if redemption.Points <= 0 {
+		return fmt.Errorf("[RestoreRedemption] Points must be positive, got %d", redemption.Points)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	account := l.accountLocked(redemption.UserID)
+	if _, exists := account.redemptions[redemption.ID]; exists {
+		return fmt.Errorf("[RestoreRedemption] Redemption %s of user %s is already in the ledger", redemption.ID, redemption.UserID)
+	}
+	restored := redemption.copy()
+	restored.Status = RedemptionReserved
+	restored.EntryID = 0
+	restored.SettledAt = nil
+	account.hold(&restored)
+	return nil
+}
+
+// Confirm
+// @Description    Confirm a reserved redemption: its points are spent, with a redemption entry of the ledger referencing
+//                 the receipts they are taken from. Confirming a confirmed redemption again returns it unchanged.
+// @Param          userID: string, redemptionID: string, at: time.Time
+// @Return         redemption: Redemption, error: error (wrapping ErrRedemptionNotFound or ErrRedemptionSettled)
+func (l *Ledger) Confirm(userID string, redemptionID string, at time.Time) (Redemption, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	redemption, err := l.redemptionLocked(userID, redemptionID)
+	if err != nil {
+		return Redemption{}, fmt.Errorf("[Confirm] %w", err)
+	}
+	switch redemption.Status {
+	case RedemptionConfirmed:
+		return redemption.copy(), nil
+	case RedemptionCancelled:
+		return Redemption{}, fmt.Errorf("[Confirm] Redemption %s is cancelled: %w", redemptionID, ErrRedemptionSettled)
+	}
+	confirmed := redemption.copy()
+	confirmed.Status = RedemptionConfirmed
+	confirmed.EntryID = l.nextID
+	confirmed.SettledAt = &at
+	if err := l.recordLocked(&confirmed); err != nil {
+		return Redemption{}, fmt.Errorf("[Confirm] %w", err)
+	}
+
+	account := l.accounts[userID]
+	account.held -= redemption.Points
+	entry := l.appendLocked(account, LedgerEntry{
+		UserID:       userID,
+		Type:         EntryRedemption,
+		Points:       -redemption.Points,
+		RedemptionID: redemption.ID,
+		Consumed:     append([]LotConsumption{}, redemption.Consumed...),
+		CreatedAt:    at,
+	})
+	redemption.Status = RedemptionConfirmed
+	redemption.EntryID = entry.ID
+	redemption.SettledAt = &at
+	account.touch(at)
+	return redemption.copy(), nil
+}
+
+// Cancel
+// @Description    Cancel a reserved redemption: its points go back to the lots they were taken from, nothing is written
+//                 to the ledger. Cancelling a cancelled redemption again returns it unchanged.
+// @Param          userID: string, redemptionID: string, at: time.Time
+// @Return         redemption: Redemption, error: error (wrapping ErrRedemptionNotFound or ErrRedemptionSettled)
+func (l *Ledger) Cancel(userID string, redemptionID string, at time.Time) (Redemption, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	redemption, err := l.redemptionLocked(userID, redemptionID)
+	if err != nil {
+		return Redemption{}, fmt.Errorf("[Cancel] %w", err)
+	}
+	switch redemption.Status {
+	case RedemptionCancelled:
+		return redemption.copy(), nil
+	case RedemptionConfirmed:
+		return Redemption{}, fmt.Errorf("[Cancel] Redemption %s is confirmed: %w", redemptionID, ErrRedemptionSettled)
+	}
+	cancelled := redemption.copy()
+	cancelled.Status = RedemptionCancelled
+	cancelled.SettledAt = &at
+	if err := l.recordLocked(&cancelled); err != nil {
+		return Redemption{}, fmt.Errorf("[Cancel] %w", err)
+	}
+
+	// the points first repay the debt of the reversals, if any. The points of a lot that no longer exists
+	// (a receipt deleted before a restart, see RestoreRedemption) were only held, they are released
+	account := l.accounts[userID]
+	repaid := min(redemption.Points, account.debt())
+	for _, consumed := range redemption.Consumed {
+		returned := consumed.Points - min(consumed.Points, repaid)
+		repaid -= consumed.Points - returned
+		if lot, exists := account.lotIndex[consumed.ReceiptID]; exists {
+			lot.remaining += returned
+		}
+	}
+	account.held -= redemption.Points
+	redemption.Status = RedemptionCancelled
+	redemption.SettledAt = &at
+	return redemption.copy(), nil
+}
+
+// Redemption
+// @Description    Get a redemption of a user.
+// @Param          userID: string, redemptionID: string
+// @Return         redemption: Redemption, error: error (wrapping ErrRedemptionNotFound)
+func (l *Ledger) Redemption(userID string, redemptionID string) (Redemption, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	redemption, err := l.redemptionLocked(userID, redemptionID)
+	if err != nil {
+		return Redemption{}, fmt.Errorf("[Redemption] %w", err)
+	}
+	return redemption.copy(), nil
+}
+
+// Balance
+// @Description    Get the points balance of a user (0 for a user without entries).
+// @Param          userID: string
+// @Return         balance: int64
+func (l *Ledger) Balance(userID string) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if account, exists := l.accounts[userID]; exists {
+		return account.balance
+	}
+	return 0
+}
+
+// Entries
+// @Description    Get the ledger entries of a user, oldest first.
+// @Param          userID: string
+// @Return         entries: []LedgerEntry
+func (l *Ledger) Entries(userID string) []LedgerEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if account, exists := l.accounts[userID]; exists {
+		return append([]LedgerEntry{}, account.entries...)
+	}
+	return []LedgerEntry{}
+}
+
+// EraseUser
+// @Description    Remove the account of a user and all their entries (e.g. GDPR erasure request).
+// @Param          userID: string
+// @Return         true if the user had an account: bool
+func (l *Ledger) EraseUser(userID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, exists := l.accounts[userID]
+	delete(l.accounts, userID)
+	return exists
+}
+
+// accountLocked
+// @Description    Get the account of a user, creating it if needed. The caller must hold l.mu.
+// @Param          userID: string
+// @Return         account: *ledgerAccount
+func (l *Ledger) accountLocked(userID string) *ledgerAccount {
+	account, exists := l.accounts[userID]
+	if !exists {
+		account = &ledgerAccount{
+			entries:     []LedgerEntry{},
+			credits:     map[string]int{},
+			reversals:   map[string]int{},
+			lotIndex:    map[string]*ledgerLot{},
+			redemptions: map[string]*Redemption{},
+		}
+		l.accounts[userID] = account
+	}
+	return account
+}
+
+// appendLocked
+// @Description    Append an entry to an account, numbering it and updating the balance. The caller must hold l.mu.
+// @Param          account: *ledgerAccount, entry: LedgerEntry
+// @Return         appended entry: LedgerEntry
+func (l *Ledger) appendLocked(account *ledgerAccount, entry LedgerEntry) LedgerEntry {
+	entry.ID = l.nextID
+	l.nextID++
+	account.balance += entry.Points
+	entry.Balance = account.balance
+	account.entries = append(account.entries, entry)
+	return entry
+}
+
+// recordLocked
+// @Description    Record the new state of a redemption, if the ledger has a recorder. The caller must hold l.mu.
+// @Param          redemption: *Redemption
+// @Return         error: error
+func (l *Ledger) recordLocked(redemption *Redemption) error {
+	if l.recorder == nil {
+		return nil
+	}
+	if err := l.recorder.Record(redemption.copy()); err != nil {
+		return fmt.Errorf("Failed to record redemption %s: %w", redemption.ID, err)
+	}
+	return nil
+}
+
+// hold
+// @Description    Take the points of a reserved redemption from its lots and hold them until it is settled.
+// @Param          redemption: *Redemption
+// @Return         none
+func (a *ledgerAccount) hold(redemption *Redemption) {
+	for _, consumed := range redemption.Consumed {
+		if lot, exists := a.lotIndex[consumed.ReceiptID]; exists {
+			lot.remaining -= min(lot.remaining, consumed.Points)
+		}
+	}
+	a.held += redemption.Points
+	a.redemptions[redemption.ID] = redemption
+}
+
+// takeBack
+// @Description    Take points back from the lots: from the lot of the receipt first, then from the other lots oldest first.
+//                 The points that are not in the lots (spent or held) become a debt.
+// @Param          receiptID: string, points: int64
+// @Return         points taken from each lot: []LotConsumption
+func (a *ledgerAccount) takeBack(receiptID string, points int64) []LotConsumption {
+	consumed := []LotConsumption{}
+	take := func(lot *ledgerLot) {
+		if taken := min(lot.remaining, points); taken > 0 {
+			lot.remaining -= taken
+			points -= taken
+			consumed = append(consumed, LotConsumption{ReceiptID: lot.receiptID, Points: taken})
+		}
+	}
+	if lot, exists := a.lotIndex[receiptID]; exists {
+		take(lot)
+	}
+	for _, lot := range a.lots {
+		if points == 0 {
+			break
+		}
+		take(lot)
+	}
+	return consumed
+}
+
+// debt
+// @Description    Get the points of the reversals that could not be taken from the lots, because they were spent already.
+//                 The lots are empty while the account has a debt.
+// @Param          none
+// @Return         debt: int64
+func (a *ledgerAccount) debt() int64 {
+	return max(0, a.held-a.balance)
+}
+
+// touch
+// @Description    Record an activity of the user, which postpones the expiration of their points on inactivity.
+// @Param          at: time.Time
+// @Return         none
+func (a *ledgerAccount) touch(at time.Time) {
+	if at.After(a.activeAt) {
+		a.activeAt = at
+	}
+}
+
+// redemptionLocked
+// @Description    Get a redemption of a user. The caller must hold l.mu.
+// @Param          userID: string, redemptionID: string
+// @Return         redemption: *Redemption, error: error (wrapping ErrRedemptionNotFound)
+func (l *Ledger) redemptionLocked(userID string, redemptionID string) (*Redemption, error) {
+	if account, exists := l.accounts[userID]; exists {
+		if redemption, exists := account.redemptions[redemptionID]; exists {
+			return redemption, nil
+		}
+	}
+	return nil, fmt.Errorf("Redemption %s of user %s: %w", redemptionID, userID, ErrRedemptionNotFound)
+}
+
+// copy
+// @Description    Copy a redemption, so that the caller does not share its consumed lots with the ledger.
+// @Param          none
+// @Return         copy of the redemption: Redemption
+func (r *Redemption) copy() Redemption {
+	redemption := *r
+	redemption.Consumed = append([]LotConsumption{}, r.Consumed...)
+	return redemption
+}
+
+// newRedemptionID
+// @Description    Generate a random redemption ID.
+// @Param          none
+// @Return         redemption ID: string, error: error
+func newRedemptionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,445 @@
+// services/ledger_test.go
+// Tests for the points ledger of the users.
+
+package services
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test on crediting the points of receipts
+// expected: one entry per receipt with the running balance, crediting a receipt again is a no-op
+func TestLedger_Credit(t *testing.T) {
+	ledger := NewLedger()
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	entry, created, err := ledger.Credit("alice", "receipt-1", 28, at, at)
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, LedgerEntry{ID: 1, UserID: "alice", Type: EntryCredit, Points: 28, Balance: 28, ReceiptID: "receipt-1", CreatedAt: at}, entry)
+
+	_, _, err = ledger.Credit("bob", "receipt-2", 109, at, at)
+	assert.NoError(t, err)
+	entry, created, err = ledger.Credit("alice", "receipt-3", 10, at.Add(time.Hour), at.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, int64(3), entry.ID)
+	assert.Equal(t, int64(38), entry.Balance)
+
+	again, created, err := ledger.Credit("alice", "receipt-1", 28, at.Add(2*time.Hour), at.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, int64(1), again.ID)
+
+	assert.Equal(t, int64(38), ledger.Balance("alice"))
+	assert.Equal(t, int64(109), ledger.Balance("bob"))
+	assert.Equal(t, int64(0), ledger.Balance("carol"))
+	assert.Len(t, ledger.Entries("alice"), 2)
+	assert.Equal(t, []LedgerEntry{}, ledger.Entries("carol"))
+
+	_, _, err = ledger.Credit("", "receipt-4", 1, at, at)
+	assert.Error(t, err)
+	_, _, err = ledger.Credit("alice", "receipt-4", -1, at, at)
+	assert.Error(t, err)
+
+	assert.True(t, ledger.EraseUser("alice"))
+	assert.False(t, ledger.EraseUser("alice"))
+	assert.Equal(t, int64(0), ledger.Balance("alice"))
+	assert.Equal(t, int64(109), ledger.Balance("bob"))
+}
+
+// Test on crediting concurrently
+// expected: the balance is the sum of the distinct receipts, whatever the interleaving
+func TestLedger_ConcurrentCredits(t *testing.T) {
+	ledger := NewLedger()
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				// every worker credits the same 100 receipts
+				ledger.Credit("alice", fmt.Sprintf("receipt-%d", i), int64(i), time.Now(), time.Now())
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(4950), ledger.Balance("alice"))
+	entries := ledger.Entries("alice")
+	assert.Len(t, entries, 100)
+	var balance int64
+	for _, entry := range entries {
+		balance += entry.Points
+		assert.Equal(t, balance, entry.Balance)
+	}
+}
+
+// newFundedLedger creates a ledger where alice has 3 receipts of 10, 20 and 30 points, credited in that order.
+func newFundedLedger(t *testing.T) *Ledger {
+	ledger := NewLedger()
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, points := range []int64{10, 20, 30} {
+		_, _, err := ledger.Credit("alice", fmt.Sprintf("receipt-%d", i+1), points, at, at.Add(time.Duration(i)*time.Hour))
+		assert.NoError(t, err)
+	}
+	return ledger
+}
+
+// Test on reserving then confirming a redemption
+// expected: the oldest lots are consumed first, the points are held until the confirmation writes the redemption entry
+func TestLedger_ReserveConfirm(t *testing.T) {
+	ledger := newFundedLedger(t)
+	at := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	redemption, err := ledger.Reserve("alice", 25, at)
+	assert.NoError(t, err)
+	assert.Equal(t, RedemptionReserved, redemption.Status)
+	assert.Equal(t, []LotConsumption{{ReceiptID: "receipt-1", Points: 10}, {ReceiptID: "receipt-2", Points: 15}}, redemption.Consumed)
+	available, held := ledger.Available("alice")
+	assert.Equal(t, int64(35), available)
+	assert.Equal(t, int64(25), held)
+	assert.Equal(t, int64(60), ledger.Balance("alice"))
+	assert.Len(t, ledger.Entries("alice"), 3)
+
+	confirmed, err := ledger.Confirm("alice", redemption.ID, at.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, RedemptionConfirmed, confirmed.Status)
+	assert.Equal(t, int64(35), ledger.Balance("alice"))
+	available, held = ledger.Available("alice")
+	assert.Equal(t, int64(35), available)
+	assert.Equal(t, int64(0), held)
+
+	entries := ledger.Entries("alice")
+	last := entries[len(entries)-1]
+	assert.Equal(t, EntryRedemption, last.Type)
+	assert.Equal(t, int64(-25), last.Points)
+	assert.Equal(t, int64(35), last.Balance)
+	assert.Equal(t, redemption.ID, last.RedemptionID)
+	assert.Equal(t, redemption.Consumed, last.Consumed)
+	assert.Equal(t, last.ID, confirmed.EntryID)
+
+	// confirming again is a no-op, cancelling a confirmed redemption is refused
+	again, err := ledger.Confirm("alice", redemption.ID, at.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, confirmed, again)
+	assert.Len(t, ledger.Entries("alice"), 4)
+	_, err = ledger.Cancel("alice", redemption.ID, at)
+	assert.ErrorIs(t, err, ErrRedemptionSettled)
+
+	// the next redemption starts where the previous one stopped
+	redemption, err = ledger.Reserve("alice", 10, at)
+	assert.NoError(t, err)
+	assert.Equal(t, []LotConsumption{{ReceiptID: "receipt-2", Points: 5}, {ReceiptID: "receipt-3", Points: 5}}, redemption.Consumed)
+}
+
+// Test on cancelling a reserved redemption
+// expected: the points go back to their lots and are available again, nothing is written to the ledger
+func TestLedger_ReserveCancel(t *testing.T) {
+	ledger := newFundedLedger(t)
+	at := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	redemption, err := ledger.Reserve("alice", 15, at)
+	assert.NoError(t, err)
+	cancelled, err := ledger.Cancel("alice", redemption.ID, at)
+	assert.NoError(t, err)
+	assert.Equal(t, RedemptionCancelled, cancelled.Status)
+	assert.Equal(t, &at, cancelled.SettledAt)
+	available, held := ledger.Available("alice")
+	assert.Equal(t, int64(60), available)
+	assert.Equal(t, int64(0), held)
+	assert.Len(t, ledger.Entries("alice"), 3)
+
+	_, err = ledger.Cancel("alice", redemption.ID, at)
+	assert.NoError(t, err)
+	_, err = ledger.Confirm("alice", redemption.ID, at)
+	assert.ErrorIs(t, err, ErrRedemptionSettled)
+
+	// the released points are consumed first again
+	redemption, err = ledger.Reserve("alice", 15, at)
+	assert.NoError(t, err)
+	assert.Equal(t, []LotConsumption{{ReceiptID: "receipt-1", Points: 10}, {ReceiptID: "receipt-2", Points: 5}}, redemption.Consumed)
+}
+
+// Test on the redemptions that cannot be made
+// expected: overdrawing, unknown redemptions and redemptions of another user are refused
+func TestLedger_RedemptionErrors(t *testing.T) {
+	ledger := newFundedLedger(t)
+	at := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	_, err := ledger.Reserve("alice", 61, at)
+	assert.ErrorIs(t, err, ErrInsufficientPoints)
+	_, err = ledger.Reserve("bob", 1, at)
+	assert.ErrorIs(t, err, ErrInsufficientPoints)
+	_, err = ledger.Reserve("alice", 0, at)
+	assert.Error(t, err)
+
+	redemption, err := ledger.Reserve("alice", 50, at)
+	assert.NoError(t, err)
+	// the held points are not available anymore
+	_, err = ledger.Reserve("alice", 11, at)
+	assert.ErrorIs(t, err, ErrInsufficientPoints)
+
+	_, err = ledger.Confirm("alice", "unknown", at)
+	assert.ErrorIs(t, err, ErrRedemptionNotFound)
+	_, err = ledger.Confirm("bob", redemption.ID, at)
+	assert.ErrorIs(t, err, ErrRedemptionNotFound)
+	_, err = ledger.Redemption("alice", redemption.ID)
+	assert.NoError(t, err)
+}
+
+// Test on concurrent redemptions
+// expected: the points are never double spent, the redeemed points never exceed the balance
+func TestLedger_ConcurrentRedemptions(t *testing.T) {
+	ledger := newFundedLedger(t)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	redeemed := int64(0)
+	for worker := 0; worker < 20; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			redemption, err := ledger.Reserve("alice", 7, time.Now())
+			if err != nil {
+				assert.ErrorIs(t, err, ErrInsufficientPoints)
+				return
+			}
+			if _, err := ledger.Confirm("alice", redemption.ID, time.Now()); assert.NoError(t, err) {
+				mu.Lock()
+				redeemed += redemption.Points
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(56), redeemed) // 8 redemptions of 7 points out of 60
+	assert.Equal(t, int64(4), ledger.Balance("alice"))
+	consumed := map[string]int64{}
+	for _, entry := range ledger.Entries("alice") {
+		for _, lot := range entry.Consumed {
+			consumed[lot.ReceiptID] += lot.Points
+		}
+	}
+	assert.Equal(t, map[string]int64{"receipt-1": 10, "receipt-2": 20, "receipt-3": 26}, consumed)
+}
+
+// lotPoints sums the remaining points of the lots of a user.
+func lotPoints(ledger *Ledger, userID string) int64 {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+	var points int64
+	for _, lot := range ledger.accounts[userID].lots {
+		points += lot.remaining
+	}
+	return points
+}
+
+// assertLots checks that the lots of a user hold exactly the points available, none while the user has a debt.
+func assertLots(t *testing.T, ledger *Ledger, userID string) {
+	available, _ := ledger.Available(userID)
+	assert.Equal(t, max(0, available), lotPoints(ledger, userID))
+}
+
+// Test on recording the redemptions
+// expected: every new state is recorded before it is applied, a redemption that cannot be recorded does not change
+func TestLedger_RedemptionRecorder(t *testing.T) {
+	ledger := newFundedLedger(t)
+	at := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	recorded := []Redemption{}
+	fail := false
+	ledger.WithRedemptionRecorder(RedemptionRecorderFunc(func(redemption Redemption) error {
+		if fail {
+			return fmt.Errorf("disk full")
+		}
+		recorded = append(recorded, redemption)
+		return nil
+	}))
+
+	reserved, err := ledger.Reserve("alice", 25, at)
+	assert.NoError(t, err)
+	confirmed, err := ledger.Confirm("alice", reserved.ID, at.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, []Redemption{reserved, confirmed}, recorded)
+
+	// nothing changes when the recorder fails
+	fail = true
+	_, err = ledger.Reserve("alice", 10, at)
+	assert.Error(t, err)
+	available, _ := ledger.Available("alice")
+	assert.Equal(t, int64(35), available)
+	fail = false
+	held, err := ledger.Reserve("alice", 10, at)
+	assert.NoError(t, err)
+	fail = true
+	_, err = ledger.Cancel("alice", held.ID, at)
+	assert.Error(t, err)
+	_, err = ledger.Confirm("alice", held.ID, at)
+	assert.Error(t, err)
+	held, _ = ledger.Redemption("alice", held.ID)
+	assert.Equal(t, RedemptionReserved, held.Status)
+	assert.Equal(t, int64(35), ledger.Balance("alice"))
+	assertLots(t, ledger, "alice")
+}
+
+// Test on restoring the recorded redemptions in a new ledger
+// expected: the redemptions take the same lots, and are then confirmed or cancelled like new ones
+func TestLedger_RestoreRedemption(t *testing.T) {
+	ledger := newFundedLedger(t)
+	at := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	confirmed, _ := ledger.Reserve("alice", 25, at)
+	confirmed, _ = ledger.Confirm("alice", confirmed.ID, at)
+	reserved, _ := ledger.Reserve("alice", 10, at)
+
+	restored := newFundedLedger(t)
+	assert.NoError(t, restored.RestoreRedemption(confirmed))
+	assert.NoError(t, restored.RestoreRedemption(reserved))
+	assert.Error(t, restored.RestoreRedemption(reserved))
+	_, err := restored.Confirm("alice", confirmed.ID, *confirmed.SettledAt)
+	assert.NoError(t, err)
+
+	assert.Equal(t, ledger.Balance("alice"), restored.Balance("alice"))
+	available, held := restored.Available("alice")
+	assert.Equal(t, int64(25), available)
+	assert.Equal(t, int64(10), held)
+	assert.Equal(t, lotPoints(ledger, "alice"), lotPoints(restored, "alice"))
+	redemption, err := restored.Redemption("alice", reserved.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, reserved, redemption)
+	_, err = restored.Cancel("alice", reserved.ID, at)
+	assert.NoError(t, err)
+	assertLots(t, restored, "alice")
+}
+
+// Test on reversing the points of a receipt
+// expected: the lot of the receipt is taken first, then the oldest lots, each reversal is applied only once
+func TestLedger_Reverse(t *testing.T) {
+	ledger := newFundedLedger(t)
+	at := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	entry, created, err := ledger.Reverse("alice", "receipt-2", 0, 25, at)
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, EntryReversal, entry.Type)
+	assert.Equal(t, int64(-25), entry.Points)
+	assert.Equal(t, int64(35), entry.Balance)
+	assert.Equal(t, "receipt-2", entry.ReceiptID)
+	assert.Equal(t, []LotConsumption{{ReceiptID: "receipt-2", Points: 20}, {ReceiptID: "receipt-1", Points: 5}}, entry.Consumed)
+	assert.Equal(t, int64(35), ledger.Balance("alice"))
+	assertLots(t, ledger, "alice")
+
+	// applying the same reversal again is a no-op, a reversal without points writes no entry
+	again, created, err := ledger.Reverse("alice", "receipt-2", 0, 25, at.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, entry, again)
+	_, created, err = ledger.Reverse("alice", "receipt-2", 1, 0, at)
+	assert.NoError(t, err)
+	assert.False(t, created)
+	assert.Len(t, ledger.Entries("alice"), 4)
+
+	// applying the same reversal with other points is refused
+	_, _, err = ledger.Reverse("alice", "receipt-2", 0, 20, at)
+	assert.ErrorIs(t, err, ErrReversalConflict)
+	assert.Len(t, ledger.Entries("alice"), 4)
+
+	// invalid reversals
+	_, _, err = ledger.Reverse("", "receipt-2", 2, 5, at)
+	assert.Error(t, err)
+	_, _, err = ledger.Reverse("alice", "receipt-2", 2, -5, at)
+	assert.Error(t, err)
+}
+
+// Test on reversing points that are spent already
+// expected: the missing points become a debt, the available balance is negative until the next credits repay it
+func TestLedger_ReverseDebt(t *testing.T) {
+	ledger := newFundedLedger(t)
+	at := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	redemption, err := ledger.Reserve("alice", 50, at)
+	assert.NoError(t, err)
+	_, err = ledger.Confirm("alice", redemption.ID, at)
+	assert.NoError(t, err)
+
+	entry, _, err := ledger.Reverse("alice", "receipt-1", 0, 30, at)
+	assert.NoError(t, err)
+	assert.Equal(t, []LotConsumption{{ReceiptID: "receipt-3", Points: 10}}, entry.Consumed)
+	assert.Equal(t, int64(-20), ledger.Balance("alice"))
+	available, _ := ledger.Available("alice")
+	assert.Equal(t, int64(-20), available)
+	assertLots(t, ledger, "alice")
+	_, err = ledger.Reserve("alice", 1, at)
+	assert.ErrorIs(t, err, ErrInsufficientPoints)
+
+	// the next credits repay the debt first
+	_, _, err = ledger.Credit("alice", "receipt-4", 15, at, at)
+	assert.NoError(t, err)
+	available, _ = ledger.Available("alice")
+	assert.Equal(t, int64(-5), available)
+	assertLots(t, ledger, "alice")
+	_, _, err = ledger.Credit("alice", "receipt-5", 10, at, at)
+	assert.NoError(t, err)
+	available, _ = ledger.Available("alice")
+	assert.Equal(t, int64(5), available)
+	assertLots(t, ledger, "alice")
+}
+
+// Test on reversing points held by a reserved redemption, then cancelling it
+// expected: the held points are not taken, cancelling the redemption repays the debt before returning points to the lots
+func TestLedger_ReverseHeld(t *testing.T) {
+	ledger := newFundedLedger(t)
+	at := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	redemption, err := ledger.Reserve("alice", 60, at)
+	assert.NoError(t, err)
+	entry, _, err := ledger.Reverse("alice", "receipt-3", 0, 30, at)
+	assert.NoError(t, err)
+	assert.Empty(t, entry.Consumed)
+	available, held := ledger.Available("alice")
+	assert.Equal(t, int64(-30), available)
+	assert.Equal(t, int64(60), held)
+	assertLots(t, ledger, "alice")
+
+	_, err = ledger.Cancel("alice", redemption.ID, at)
+	assert.NoError(t, err)
+	available, held = ledger.Available("alice")
+	assert.Equal(t, int64(30), available)
+	assert.Equal(t, int64(0), held)
+	assertLots(t, ledger, "alice")
+}
+
+// Test on removing a deleted receipt
+// expected: its points less its reversals are taken back, and the receipt can be credited again
+func TestLedger_RemoveReceipt(t *testing.T) {
+	ledger := newFundedLedger(t)
+	at := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	_, _, err := ledger.Reverse("alice", "receipt-2", 0, 5, at)
+	assert.NoError(t, err)
+
+	entry, removed := ledger.RemoveReceipt("alice", "receipt-2", at)
+	assert.True(t, removed)
+	assert.Equal(t, EntryDeletion, entry.Type)
+	assert.Equal(t, int64(-15), entry.Points)
+	assert.Equal(t, []LotConsumption{{ReceiptID: "receipt-2", Points: 15}}, entry.Consumed)
+	assert.Equal(t, int64(40), ledger.Balance("alice"))
+	assertLots(t, ledger, "alice")
+
+	// removing it again, or an unknown receipt, is a no-op
+	_, removed = ledger.RemoveReceipt("alice", "receipt-2", at)
+	assert.False(t, removed)
+	_, removed = ledger.RemoveReceipt("bob", "receipt-2", at)
+	assert.False(t, removed)
+
+	// the receipt processed again after its deletion is credited again
+	_, created, err := ledger.Credit("alice", "receipt-2", 20, at, at)
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, int64(60), ledger.Balance("alice"))
+}
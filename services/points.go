@@ -16,51 +16,134 @@ import (
 ////////////////////////////
 
 
+// PointsResult is the outcome of scoring a receipt: its points, their breakdown, and the version of the rules used.
+type PointsResult struct {
+	Points      int64
+	Breakdown   []models.PointsBreakdownEntry
+	RuleVersion string
+}
+
+
 // CalculateTotalPoints
-// @Description    calculates the points earned from a given receipt.
+// @Description    calculates the points earned from a given receipt, using the rules of the registry.
 //				   Assumptions:
 // 						any error during the process will stop the calculation and return 0 points with an error.
 // @Param          pointer to the receipt object: *models.Receipt
 // @Return         total points earned: int64, error: error
 func CalculateTotalPoints(receipt *models.Receipt) (int64, error) {
+	return CalculatePointsWithRules(receipt, GetRuleRegistry().Active().Rules)
+}
+
+
+// CalculatePointsWithRules
+// @Description    calculates the points earned from a given receipt under the given rules.
+//				   Assumptions:
+// 						any error during the process will stop the calculation and return 0 points with an error.
+// @Param          pointer to the receipt object: *models.Receipt, rules: []Rule
+// @Return         total points earned: int64, error: error
+func CalculatePointsWithRules(receipt *models.Receipt, rules []Rule) (int64, error) {
 	var totalPoints int64 = 0 // assuming int64 is large enough to avoid overflow, and aligns with the API definition
 
-	// Points calculation rules are based on the following information on a receipt:
+	for _, rule := range rules {
+		points, err := rule.Evaluate(receipt)
+		if err != nil {
+			return 0, fmt.Errorf("[CalculateTotalPoints] Failed to apply rule %v for receipt ID %v: %w", rule.Name(), receipt.ID, err)
+		}
+		totalPoints += points
+	}
+
+	return totalPoints, nil
+}
+
 
-	// points received from retailer's name
-	retailerNamePoints, err := calculateRetailerNamePoints(receipt.Retailer)
-	if err != nil {
-		return 0, fmt.Errorf("[CalculateTotalPoints] Failed to calculate retailer name points for receipt ID %v: %w", receipt.ID, err)
+// CalculatePointsBreakdown
+// @Description    calculates the points earned from a given receipt, itemized per rule (and per item for the per-item rules).
+//				   Assumptions:
+// 						any error during the process will stop the calculation and return 0 points with an error.
+// @Param          pointer to the receipt object: *models.Receipt
+// @Return         total points earned: int64, breakdown: []models.PointsBreakdownEntry, error: error
+func CalculatePointsBreakdown(receipt *models.Receipt) (int64, []models.PointsBreakdownEntry, error) {
+	return CalculatePointsBreakdownWithRules(receipt, GetRuleRegistry().Active().Rules)
+}
+
+
+// CalculatePointsBreakdownWithRules
+// @Description    calculates the points earned from a given receipt under the given rules, itemized per rule.
+//				   The breakdown entries always sum up to the total points.
+// @Param          pointer to the receipt object: *models.Receipt, rules: []Rule
+// @Return         total points earned: int64, breakdown: []models.PointsBreakdownEntry, error: error
+func CalculatePointsBreakdownWithRules(receipt *models.Receipt, rules []Rule) (int64, []models.PointsBreakdownEntry, error) {
+	var totalPoints int64 = 0
+	breakdown := []models.PointsBreakdownEntry{}
+
+	for _, rule := range rules {
+		entries, err := itemizeRule(rule, receipt)
+		if err != nil {
+			return 0, nil, fmt.Errorf("[CalculatePointsBreakdown] Failed to apply rule %v for receipt ID %v: %w", rule.Name(), receipt.ID, err)
+		}
+		for _, entry := range entries {
+			totalPoints += entry.Points
+		}
+		breakdown = append(breakdown, entries...)
 	}
-	totalPoints += retailerNamePoints
 
-	// points received from puchase date
-	puchaseDatePoints, err := calculatePurchaseDatePoints(receipt.PurchaseDate)
-	if err != nil {
-		return 0, fmt.Errorf("[CalculateTotalPoints] Failed to calculate purchase date points for receipt ID %v: %w", receipt.ID, err)
+	return totalPoints, breakdown, nil
+}
+
+
+// ScoreReceipt
+// @Description    scores a receipt under the active rule set, recording the version of the rules used.
+// @Param          pointer to the receipt object: *models.Receipt
+// @Return         points, breakdown and rule version: PointsResult, error: error
+func ScoreReceipt(receipt *models.Receipt) (PointsResult, error) {
+	return GetRuleRegistry().Active().Score(receipt)
+}
+
+
+// RescoreReceipt
+// @Description    scores a receipt again under the exact rule set version it was originally processed with.
+// @Param          pointer to the receipt object: *models.Receipt, version: string
+// @Return         points, breakdown and rule version: PointsResult, error: error (unknown version or invalid receipt)
+func RescoreReceipt(receipt *models.Receipt, version string) (PointsResult, error) {
+	set, found := GetRuleRegistry().Version(version)
+	if !found {
+		return PointsResult{}, fmt.Errorf("[RescoreReceipt] Unknown rule set version %v", version)
 	}
-	totalPoints += puchaseDatePoints
+	return set.Score(receipt)
+}
 
-	// points received from purchase time
-	purchaseTimePoints, err := calculatePurchaseTimePoints(receipt.PurchaseTime)
-	if err != nil {
-		return 0, fmt.Errorf("[CalculateTotalPoints] Failed to calculate purchase time points for receipt ID %v: %w", receipt.ID, err)
+
+// TotalCheckRuleID is the rule id of the breakdown entry flagging a total not matching the sum of the item prices.
+const TotalCheckRuleID = "total-consistency"
+
+
+// Score
+// @Description    scores a receipt under the rule set.
+//				   The total is checked against the sum of the item prices first, according to the total check policy of the set:
+//				   a mismatch is either refused (models.FieldErrors error), or flagged with a 0 point breakdown entry.
+// @Param          pointer to the receipt object: *models.Receipt
+// @Return         points, breakdown and rule version: PointsResult, error: error
+func (s *RuleSet) Score(receipt *models.Receipt) (PointsResult, error) {
+	mismatch := receipt.CheckTotal(s.TotalCheck)
+	if mismatch != nil && s.TotalCheck.OnMismatch != models.TotalMismatchFlag {
+		return PointsResult{}, models.FieldErrors{*mismatch}
 	}
-	totalPoints += purchaseTimePoints
 
-	// points received from items
-	itemsPoints, err := calculateItemsPoints(receipt.Items)
+	points, breakdown, err := CalculatePointsBreakdownWithRules(receipt, s.Rules)
 	if err != nil {
-		return 0, fmt.Errorf("[CalculateTotalPoints] Failed to calculate items points for receipt ID %v: %w", receipt.ID, err)
+		return PointsResult{}, err
 	}
-	totalPoints += itemsPoints
-	
-	// points received from total amount
-	totalAmountPoints, err := calculateTotalAmountPoints(receipt.Total)
-	if err != nil {
-		return 0, fmt.Errorf("[CalculateTotalPoints] Failed to calculate total amount points for receipt ID %v: %w", receipt.ID, err)
+	if mismatch != nil {
+		breakdown = append([]models.PointsBreakdownEntry{{
+			RuleID: TotalCheckRuleID,
+			Reason: "Flagged for review: " + mismatch.Message,
+			Points: 0,
+			Source: mismatch.Field,
+		}}, breakdown...)
 	}
-	totalPoints += totalAmountPoints
-
-	return totalPoints, nil
-}
\ No newline at end of file
+	return PointsResult{
+		Points:      points,
+		Breakdown:   breakdown,
+		RuleVersion: s.Version,
+	}, nil
+}
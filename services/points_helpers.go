@@ -27,6 +27,8 @@ import (
 	6. 6 points if the day in the purchase date is odd.
 	7. 10 points if the time of purchase is after 2:00pm and before 4:00pm.
 	...
+
+	The numbers above are the defaults, every rule can be parametrized from the rule configuration (see rules_config.go).
 */
 
 
@@ -44,6 +46,14 @@ import (
 // @Param          retailer: string
 // @Return         points from retailer name: int64, error: error
 func calculateRetailerNamePoints(retailer string) (int64, error) {
+	return calculateRetailerNamePointsWith(retailer, 1)
+}
+
+// calculateRetailerNamePointsWith
+// @Description    Same as calculateRetailerNamePoints, with a configurable number of points per character.
+// @Param          retailer: string, pointsPerChar: int64
+// @Return         points from retailer name: int64, error: error
+func calculateRetailerNamePointsWith(retailer string, pointsPerChar int64) (int64, error) {
 	var points int64 = 0
 
 	// Assumption: retailer name compiles pattern "^[\\w\\s\\-&]+$" 
@@ -55,7 +65,7 @@ func calculateRetailerNamePoints(retailer string) (int64, error) {
 
 	// 1: One point for every alphanumeric character in the retailer name.
 	count := countAlphanumericChar(retailer)
-	points += count
+	points += count * pointsPerChar
 	
 	return points, nil 
 }
@@ -68,23 +78,44 @@ func calculateRetailerNamePoints(retailer string) (int64, error) {
 // @Param          purchaseDate: string
 // @Return         points from purchase date: int64, error: error
 func calculatePurchaseDatePoints(purchaseDate string) (int64, error) {
+	return calculatePurchaseDatePointsWith(purchaseDate, 6)
+}
+
+// calculatePurchaseDatePointsWith
+// @Description    Same as calculatePurchaseDatePoints, with configurable points for an odd day.
+// @Param          purchaseDate: string, oddDayPoints: int64
+// @Return         points from purchase date: int64, error: error
+func calculatePurchaseDatePointsWith(purchaseDate string, oddDayPoints int64) (int64, error) {
 	var points int64 = 0
 
 	// parse purchase date
-	date, err := time.Parse("2006-01-02", purchaseDate)
+	date, err := parsePurchaseDate(purchaseDate)
 	if err != nil {
-		return 0, fmt.Errorf("[calculatePurchaseDatePoints] Failed to parse purchase date %v: %w", purchaseDate, err)
+		return 0, fmt.Errorf("[calculatePurchaseDatePoints] %w", err)
 	}
 
 	// 6: 6 points if the day in the purchase date is odd.
 	if date.Day() & 1 == 1 {
-		points += 6
+		points += oddDayPoints
 	}
 
 	return points, nil
 }
 
 
+// parsePurchaseDate
+// @Description    Parse the purchase date of a receipt (YYYY-MM-DD), as midnight UTC of that day.
+// @Param          purchaseDate: string
+// @Return         purchase date: time.Time, error: error
+func parsePurchaseDate(purchaseDate string) (time.Time, error) {
+	date, err := time.Parse("2006-01-02", purchaseDate)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to parse purchase date %v: %w", purchaseDate, err)
+	}
+	return date, nil
+}
+
+
 // calculatePurchaseTimePoints
 // @Description    Calculate points based on purchase time.
 //                 Included rules:
@@ -92,6 +123,14 @@ func calculatePurchaseDatePoints(purchaseDate string) (int64, error) {
 // @Param          purchaseTime: string
 // @Return         points from purchase time: int64, error: error
 func calculatePurchaseTimePoints(purchaseTime string) (int64, error) {
+	return calculatePurchaseTimePointsWith(purchaseTime, 14 * 60, 16 * 60, 10)
+}
+
+// calculatePurchaseTimePointsWith
+// @Description    Same as calculatePurchaseTimePoints, with a configurable time window [start, end) and points.
+// @Param          purchaseTime: string, startMinute: int (minutes since midnight), endMinute: int (minutes since midnight), windowPoints: int64
+// @Return         points from purchase time: int64, error: error
+func calculatePurchaseTimePointsWith(purchaseTime string, startMinute int, endMinute int, windowPoints int64) (int64, error) {
 	var points int64 = 0
 
 	// parse purchase time
@@ -102,8 +141,9 @@ func calculatePurchaseTimePoints(purchaseTime string) (int64, error) {
 
 	// 7: 10 points if the time of purchase is after 2:00pm and before 4:00pm.
 	// TODO: definition check for before and after
-	if time.Hour() >= 14 && time.Hour() < 16 {	
-		points += 10
+	minute := time.Hour() * 60 + time.Minute()
+	if minute >= startMinute && minute < endMinute {
+		points += windowPoints
 	}
 
 	return points, nil
@@ -123,46 +163,121 @@ func calculatePurchaseTimePoints(purchaseTime string) (int64, error) {
 // @Param          items: []models.Item
 // @Return         points from items: int64, error: error
 func calculateItemsPoints(items []models.Item) (int64, error) {
-	var points int64 = 0
-
 	// 4: 5 points for every two items on the receipt.
-	itemsCount := len(items)
-	// Assumption: items should have at least 1 item.
-	if itemsCount == 0 { 
-		return 0, fmt.Errorf("[calculateItemsPoints] No items found in the receipt %v", items)
+	pairsPoints, err := calculateItemPairsPoints(items)
+	if err != nil {
+		return 0, err
 	}
-	points += int64(itemsCount / 2 * 5)
 
 	// 5: If the trimmed length of the item description is a multiple of 3,
 	//    multiply the price by 0.2 and round up to the nearest integer.
-	for _, item := range items {
+	descriptionPoints, err := calculateItemDescriptionPoints(items)
+	if err != nil {
+		return 0, err
+	}
+
+	return pairsPoints + descriptionPoints, nil
+}
+
+
+// calculateItemPairsPoints
+// @Description    Calculate points based on the number of items.
+//                 Included rules:
+//				   		4: 5 points for every two items on the receipt.
+// 				   Assumptions:
+//						items should have at least 1 item.
+// @Param          items: []models.Item
+// @Return         points from item pairs: int64, error: error
+func calculateItemPairsPoints(items []models.Item) (int64, error) {
+	return calculateItemPairsPointsWith(items, 5)
+}
+
+// calculateItemPairsPointsWith
+// @Description    Same as calculateItemPairsPoints, with configurable points per pair of items.
+// @Param          items: []models.Item, pointsPerPair: int64
+// @Return         points from item pairs: int64, error: error
+func calculateItemPairsPointsWith(items []models.Item, pointsPerPair int64) (int64, error) {
+	itemsCount := len(items)
+	// Assumption: items should have at least 1 item.
+	if itemsCount == 0 {
+		return 0, fmt.Errorf("[calculateItemPairsPoints] No items found in the receipt %v", items)
+	}
+
+	// 4: 5 points for every two items on the receipt.
+	return int64(itemsCount / 2) * pointsPerPair, nil
+}
+
+
+// calculateItemDescriptionPoints
+// @Description    Calculate points based on item descriptions and prices.
+//                 Included rules:
+//				   		5: If the trimmed length of the item description is a multiple of 3,
+//						   multiply the price by 0.2 and round up to the nearest integer.
+// 				   Assumptions:
+//						items should have at least 1 item.
+//						trimmed description should not be empty.
+//						item price compiles pattern "^\\d+\\.\\d{2}$", which is a non-negative float with 2 decimal places.
+// @Param          items: []models.Item
+// @Return         points from item descriptions: int64, error: error
+func calculateItemDescriptionPoints(items []models.Item) (int64, error) {
+	var points int64 = 0
+
+	perItemPoints, err := calculatePerItemDescriptionPoints(items)
+	if err != nil {
+		return 0, err
+	}
+	for _, itemPoints := range perItemPoints {
+		points += itemPoints
+	}
+	return points, nil
+}
+
+
+// calculatePerItemDescriptionPoints
+// @Description    Calculate the rule 5 points of every single item (same order as the items).
+//                 Included rules:
+//				   		5: If the trimmed length of the item description is a multiple of 3,
+//						   multiply the price by 0.2 and round up to the nearest integer.
+// 				   Assumptions:
+//						same as calculateItemDescriptionPoints.
+// @Param          items: []models.Item
+// @Return         points of each item: []int64, error: error
+func calculatePerItemDescriptionPoints(items []models.Item) ([]int64, error) {
+	return calculatePerItemDescriptionPointsWith(items, 3, priceMultiplier{num: 2, den: 10})
+}
+
+// calculatePerItemDescriptionPointsWith
+// @Description    Same as calculatePerItemDescriptionPoints, with a configurable length multiple and price multiplier.
+// @Param          items: []models.Item, lengthMultiple: int, multiplier: priceMultiplier
+// @Return         points of each item: []int64, error: error
+func calculatePerItemDescriptionPointsWith(items []models.Item, lengthMultiple int, multiplier priceMultiplier) ([]int64, error) {
+	// Assumption: items should have at least 1 item.
+	if len(items) == 0 {
+		return nil, fmt.Errorf("[calculateItemDescriptionPoints] No items found in the receipt %v", items)
+	}
+
+	perItemPoints := make([]int64, len(items))
+	for i, item := range items {
 		trimmed, err := trimDescription(item.ShortDescription)
 		if err != nil {
-			return 0, fmt.Errorf("[calculateItemsPoints] Failed to check item description %v: %w", item.ShortDescription, err)
+			return nil, fmt.Errorf("[calculateItemDescriptionPoints] Failed to check item description %v: %w", item.ShortDescription, err)
 		}
 
-		// Assumption: trimmed description should not be empty
-		if len(trimmed) == 0 { 
-			return 0, fmt.Errorf("[calculateItemsPoints] Trimmed description is empty for %v", item.ShortDescription)
-		}
-
-		// Assumption: item price compiles pattern "^\\d+\\.\\d{2}$", which is a non-negative float with 2 decimal places.
-		validItemPrice := regexp.MustCompile(`^\d+\.\d{2}$`)
-		if !validItemPrice.MatchString(item.Price) {
-			return 0, fmt.Errorf("[calculateItemsPoints] Invalid item price %v", item.Price)
+		// Assumption: item price compiles pattern "^\\d+\\.\\d{2}$", which is a non-negative amount with 2 decimal places.
+		price, err := item.PriceAmount()
+		if err != nil {
+			return nil, fmt.Errorf("[calculateItemDescriptionPoints] Invalid item price %v: %w", item.Price, err)
 		}
 		// check if the trimmed length of the item description is a multiple of 3
-		if len(trimmed) % 3 == 0 {
-			// parse item price
-			price, err := strconv.ParseFloat(item.Price, 64)
+		if len(trimmed) % lengthMultiple == 0 {
+			// Round up to the nearest integer, exactly (e.g. 12.25 * 0.2 = 2.45 -> 3)
+			perItemPoints[i], err = price.MulRatCeilDollars(multiplier.num, multiplier.den)
 			if err != nil {
-				return 0, fmt.Errorf("[calculateItemsPoints] Failed to parse item price %v: %w", item.Price, err)
+				return nil, fmt.Errorf("[calculateItemDescriptionPoints] %w", err)
 			}
-			// Round up to the nearest integer
-			points += int64(math.Ceil(price * 0.2)) 
 		}
 	}
-	return points, nil
+	return perItemPoints, nil
 }
 
 // calculateTotalAmountPoints
@@ -175,33 +290,75 @@ func calculateItemsPoints(items []models.Item) (int64, error) {
 // @Param          total: string
 // @Return         points from total amount: int64, error: error
 func calculateTotalAmountPoints(total string) (int64, error) {
-	var points int64 = 0
-	
-	// Assumption: total amount compiles pattern "^\\d+\\.\\d{2}$", which is a non-negative float with 2 decimal places.
-	validTotal := regexp.MustCompile(`^\d+\.\d{2}$`)
-	if !validTotal.MatchString(total) {
-		return 0, fmt.Errorf("[calculateTotalAmountPoints] Invalid total amount %v", total)
+	// 2: 50 points if the total is a round dollar amount with no cents.
+	roundDollarPoints, err := calculateRoundDollarPoints(total)
+	if err != nil {
+		return 0, err
 	}
 
-	// parse total amount
-	floatTotal, err := strconv.ParseFloat(total, 64)
+	// 3: 25 points if the total is a multiple of 0.25.
+	quarterMultiplePoints, err := calculateQuarterMultiplePoints(total)
 	if err != nil {
-		return 0, fmt.Errorf("[calculateTotalAmountPoints] Failed to parse total amount %v: %w", total, err)
+		return 0, err
 	}
 
-	totalToCents := int64(floatTotal * 100)
+	return roundDollarPoints + quarterMultiplePoints, nil
+}
+
+
+// calculateRoundDollarPoints
+// @Description    Calculate points based on total amount.
+//                 Included rules:
+//				   		2: 50 points if the total is a round dollar amount with no cents.
+// @Param          total: string
+// @Return         points from round dollar total: int64, error: error
+func calculateRoundDollarPoints(total string) (int64, error) {
+	return calculateRoundDollarPointsWith(total, 50)
+}
+
+// calculateRoundDollarPointsWith
+// @Description    Same as calculateRoundDollarPoints, with configurable points for a round dollar total.
+// @Param          total: string, roundDollarPoints: int64
+// @Return         points from round dollar total: int64, error: error
+func calculateRoundDollarPointsWith(total string, roundDollarPoints int64) (int64, error) {
+	totalAmount, err := models.ParseMoney(total)
+	if err != nil {
+		return 0, fmt.Errorf("[calculateRoundDollarPoints] %w", err)
+	}
 
 	// 2: 50 points if the total is a round dollar amount with no cents.
-	if totalToCents % 100 == 0 {
-		points += 50
+	if totalAmount.IsWholeDollar() {
+		return roundDollarPoints, nil
 	}
+	return 0, nil
+}
 
-	// 3: 25 points if the total is a multiple of 0.25.
-	if totalToCents % 25 == 0 {
-		points += 25
+
+// calculateQuarterMultiplePoints
+// @Description    Calculate points based on total amount.
+//                 Included rules:
+//				   		3: 25 points if the total is a multiple of 0.25.
+// @Param          total: string
+// @Return         points from quarter multiple total: int64, error: error
+func calculateQuarterMultiplePoints(total string) (int64, error) {
+	return calculateTotalMultiplePointsWith(total, models.Money(25), 25)
+}
+
+// calculateTotalMultiplePointsWith
+// @Description    Generalization of calculateQuarterMultiplePoints, with a configurable multiple and points.
+// @Param          total: string, multiple: models.Money, multiplePoints: int64
+// @Return         points from multiple total: int64, error: error
+func calculateTotalMultiplePointsWith(total string, multiple models.Money, multiplePoints int64) (int64, error) {
+	totalAmount, err := models.ParseMoney(total)
+	if err != nil {
+		return 0, fmt.Errorf("[calculateQuarterMultiplePoints] %w", err)
 	}
 
-	return points, nil
+	// 3: 25 points if the total is a multiple of 0.25.
+	if totalAmount.IsMultipleOf(multiple) {
+		return multiplePoints, nil
+	}
+	return 0, nil
 }
 
 
@@ -209,6 +366,67 @@ func calculateTotalAmountPoints(total string) (int64, error) {
 // HELPERS OF HELPERS //
 ////////////////////////
 
+// priceMultiplier is an exact decimal multiplier num/den (e.g. 0.2 is 2/10), applied to the item prices.
+type priceMultiplier struct {
+	num int64
+	den int64
+}
+
+// maxMultiplierDecimals is the maximum number of decimals of a price multiplier.
+const maxMultiplierDecimals = 6
+
+// parsePriceMultiplier
+// @Description    Convert a multiplier of the rule configuration (e.g. 0.2) to an exact fraction, from its shortest decimal representation,
+//                 so that the price is not multiplied by the binary approximation of the multiplier (0.2000000000000000111...).
+// @Param          value: float64
+// @Return         multiplier: priceMultiplier, error: error
+func parsePriceMultiplier(value float64) (priceMultiplier, error) {
+	if value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return priceMultiplier{}, fmt.Errorf("[parsePriceMultiplier] Invalid multiplier %v", value)
+	}
+	decimal := strconv.FormatFloat(value, 'f', -1, 64)
+	whole, fraction, _ := strings.Cut(decimal, ".")
+	if len(fraction) > maxMultiplierDecimals {
+		return priceMultiplier{}, fmt.Errorf("[parsePriceMultiplier] Multiplier %v has more than %d decimals", value, maxMultiplierDecimals)
+	}
+	num, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return priceMultiplier{}, fmt.Errorf("[parsePriceMultiplier] Multiplier %v is too large: %w", value, err)
+	}
+	den := int64(1)
+	for range fraction {
+		den *= 10
+	}
+	return priceMultiplier{num: num, den: den}, nil
+}
+
+// applyFloor
+// @Description    Multiply points by the multiplier, rounding down.
+// @Param          points: int64 (not negative)
+// @Return         multiplied points: int64, error: error (overflow)
+func (m priceMultiplier) applyFloor(points int64) (int64, error) {
+	if m.num != 0 && points > math.MaxInt64/m.num {
+		return 0, fmt.Errorf("[applyFloor] %d points multiplied by %v overflow", points, m)
+	}
+	return points * m.num / m.den, nil
+}
+
+// String
+// @Description    Format the multiplier as a decimal, e.g. 1.25.
+// @Param          none
+// @Return         multiplier: string
+func (m priceMultiplier) String() string {
+	if m.den <= 1 {
+		return strconv.FormatInt(m.num, 10)
+	}
+	decimals := len(strconv.FormatInt(m.den, 10)) - 1
+	fraction := strings.TrimRight(fmt.Sprintf("%0*d", decimals, m.num%m.den), "0")
+	if fraction == "" {
+		return strconv.FormatInt(m.num/m.den, 10)
+	}
+	return strconv.FormatInt(m.num/m.den, 10) + "." + fraction
+}
+
 // countAlphanumericChar
 // @Description    Count the number of alphanumeric characters in a string.
 // @Param          str: string
@@ -230,9 +448,10 @@ func countAlphanumericChar(str string) int64 {
 // @Description    Trim the description string.
 // 				   Assumption:
 // 						trimmed description should not be empty 
+// 						the receipts are scored as submitted (models.Receipt.Normalize only applies to their IDs):
+// 						only the leading and trailing whitespace is ignored, the inner whitespace counts in the length
 // @Param          description: string
 // @Return         trimmed description: string, error: error
-// TODO: might not need it if receipt data were always normalized (trimmed) before hashing, which is prior to calculations.
 func trimDescription(description string) (string, error) {
 	trimmed := strings.TrimSpace(description)
 	
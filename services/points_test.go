@@ -557,4 +557,67 @@ func TestCalculateTotalPoints_InvalidTotal(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, int64(0), points)
 
-}
\ No newline at end of file
+}
+
+// Tests on the per-rule helpers split out of `calculateItemsPoints` and `calculateTotalAmountPoints`
+// expected: the split helpers add up to the combined helpers
+func TestCalculateSplitHelpers(t *testing.T) {
+	items := []models.Item{
+		{ShortDescription: "abc", Price: "5.01"}, // 2 points
+		{ShortDescription: "jklm", Price: "5.01"}, // 0 points
+		{ShortDescription: "def", Price: "5.00"}, // 1 point
+		// + 5 points for every two items
+	}
+	pairsPoints, err := calculateItemPairsPoints(items)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), pairsPoints)
+
+	descriptionPoints, err := calculateItemDescriptionPoints(items)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), descriptionPoints)
+
+	_, err = calculateItemPairsPoints([]models.Item{})
+	assert.Error(t, err)
+
+	roundDollarPoints, err := calculateRoundDollarPoints("35.00")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50), roundDollarPoints)
+
+	quarterMultiplePoints, err := calculateQuarterMultiplePoints("35.00")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(25), quarterMultiplePoints)
+
+	quarterMultiplePoints, err = calculateQuarterMultiplePoints("35.10")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), quarterMultiplePoints)
+
+	_, err = calculateRoundDollarPoints("5.001")
+	assert.Error(t, err)
+}
+
+// Tests on the amounts that float64 gets wrong
+// expected: the points are computed exactly
+func TestCalculatePoints_FloatPitfalls(t *testing.T) {
+	// 4.35 * 100 is 434.99999999999994 in float64, 2.30 * 100 is 229.99999999999997
+	for _, total := range []string{"4.35", "1.15", "2.30"} {
+		points, err := calculateTotalMultiplePointsWith(total, models.Money(5), 10)
+		assert.NoError(t, err, total)
+		assert.Equal(t, int64(10), points, total)
+	}
+
+	// 50.00 * 1.1 is 55.00000000000001 in float64, which would be rounded up to 56
+	multiplier, err := parsePriceMultiplier(1.1)
+	assert.NoError(t, err)
+	assert.Equal(t, priceMultiplier{num: 11, den: 10}, multiplier)
+	perItemPoints, err := calculatePerItemDescriptionPointsWith([]models.Item{
+		{ShortDescription: "abc", Price: "50.00"},
+		{ShortDescription: "def", Price: "12.25"},
+	}, 3, multiplier)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{55, 14}, perItemPoints)
+
+	_, err = parsePriceMultiplier(0.1234567)
+	assert.Error(t, err)
+	_, err = parsePriceMultiplier(-0.2)
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+// services/v1/reversal.go
+// Reversal of the receipts (e.g. refunds): the points of a receipt are computed again without its reversed items,
+// under the rule set version it was processed with, and the points it no longer earns are taken back.
+
+package services
+
+import (
+	"fmt"
+
+	"receipt-processor/models"
+)
+
+// ReversalPoints is the points of a receipt after some of its items are reversed.
+type ReversalPoints struct {
+	Base int64 // points of the remaining items under the rules, before the tier of the user
+	Net  int64 // points of the remaining items, the tier of the user included
+}
+
+// ReversedReceiptPoints
+// @Description    Compute the points of a processed receipt without its reversed items. The remaining items are scored
+//                 again under the rule set version of the receipt, with the total less the prices of the reversed items.
+//                 The tier applied to the receipt scales the points the same way (its multiplier and bonus are kept).
+//                 Reversing items never adds points, e.g. when the remaining total becomes a round dollar amount.
+//                 Reversing every item leaves no points.
+// @Param          receipt: *models.Receipt (as stored), stored: PointsResult (as stored, with its tier entry),
+//                 reversed: map[int]bool (indexes of all the reversed items of the receipt)
+// @Return         points: ReversalPoints, error: error (unknown rule set version, invalid receipt)
+func ReversedReceiptPoints(receipt *models.Receipt, stored PointsResult, reversed map[int]bool) (ReversalPoints, error) {
+	base := stored.Points - tierPointsOf(stored.Breakdown)
+	remaining := *receipt
+	remaining.Items = []models.Item{}
+	total, err := receipt.TotalAmount()
+	if err != nil {
+		return ReversalPoints{}, fmt.Errorf("[ReversedReceiptPoints] %w", err)
+	}
+	for i, item := range receipt.Items {
+		if !reversed[i] {
+			remaining.Items = append(remaining.Items, item)
+			continue
+		}
+		price, err := item.PriceAmount()
+		if err != nil {
+			return ReversalPoints{}, fmt.Errorf("[ReversedReceiptPoints] Item %d: %w", i, err)
+		}
+		if total, err = total.Sub(price); err != nil {
+			return ReversalPoints{}, fmt.Errorf("[ReversedReceiptPoints] %w", err)
+		}
+	}
+	if len(remaining.Items) == 0 {
+		return ReversalPoints{}, nil
+	}
+	remaining.Total = max(total, 0).String()
+
+	result, err := RescoreReceipt(&remaining, stored.RuleVersion)
+	if err != nil {
+		return ReversalPoints{}, fmt.Errorf("[ReversedReceiptPoints] %w", err)
+	}
+	points := ReversalPoints{Base: min(max(result.Points, 0), base), Net: stored.Points}
+	if base > 0 {
+		points.Net = stored.Points * points.Base / base
+	}
+	return points, nil
+}
+
+// tierPointsOf
+// @Description    Sum the points of the tier entries of a breakdown, the points added by the tier of the user.
+// @Param          breakdown: []models.PointsBreakdownEntry
+// @Return         tier points: int64
+func tierPointsOf(breakdown []models.PointsBreakdownEntry) int64 {
+	var points int64
+	for _, entry := range breakdown {
+		if entry.RuleID == TierRuleID {
+			points += entry.Points
+		}
+	}
+	return points
+}
@@ -0,0 +1,63 @@
+// services/reversal_test.go
+// Tests for the points of the reversed receipts.
+
+package services
+
+import (
+	"testing"
+
+	"receipt-processor/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test on reversing items of the example receipt (109 points)
+// expected: the remaining items are scored again, without the reversed items nor their prices in the total
+func TestReversedReceiptPoints(t *testing.T) {
+	stored := PointsResult{Points: 109, RuleVersion: DefaultRuleSetVersion}
+
+	// 3 items left, total 6.75: retailer 14, multiple of 0.25 25, 1 pair 5, purchase time 10
+	points, err := ReversedReceiptPoints(exampleReceipt(), stored, map[int]bool{3: true})
+	assert.NoError(t, err)
+	assert.Equal(t, ReversalPoints{Base: 54, Net: 54}, points)
+
+	// every item reversed
+	points, err = ReversedReceiptPoints(exampleReceipt(), stored, map[int]bool{0: true, 1: true, 2: true, 3: true})
+	assert.NoError(t, err)
+	assert.Equal(t, ReversalPoints{}, points)
+
+	// unknown rule set version
+	stored.RuleVersion = "unknown"
+	_, err = ReversedReceiptPoints(exampleReceipt(), stored, map[int]bool{3: true})
+	assert.Error(t, err)
+}
+
+// Test on reversing items of a receipt with a tier
+// expected: the tier scales the remaining points like the original points, rounded down
+func TestReversedReceiptPoints_Tier(t *testing.T) {
+	stored := PointsResult{
+		Points: 136,
+		Breakdown: []models.PointsBreakdownEntry{
+			{RuleID: "retailer-name", Points: 109},
+			{RuleID: TierRuleID, Points: 27},
+		},
+		RuleVersion: DefaultRuleSetVersion,
+	}
+	points, err := ReversedReceiptPoints(exampleReceipt(), stored, map[int]bool{3: true})
+	assert.NoError(t, err)
+	assert.Equal(t, ReversalPoints{Base: 54, Net: 67}, points)
+}
+
+// Test on reversing an item that makes the total a round dollar amount
+// expected: the points are not increased
+func TestReversedReceiptPoints_NoIncrease(t *testing.T) {
+	receipt := exampleReceipt()
+	receipt.Items = append(receipt.Items, models.Item{ShortDescription: "Gum", Price: "0.25"})
+	receipt.Total = "9.25"
+	result, err := ScoreReceipt(receipt)
+	assert.NoError(t, err)
+
+	points, err := ReversedReceiptPoints(receipt, result, map[int]bool{4: true})
+	assert.NoError(t, err)
+	assert.Equal(t, ReversalPoints{Base: result.Points, Net: result.Points}, points)
+}
@@ -0,0 +1,344 @@
+// services/v1/rules.go
+// Rule abstraction and registry used by the points calculation.
+// New rules (e.g. promotions) can be registered without touching CalculateTotalPoints.
+
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"receipt-processor/models"
+)
+
+// Rule defines a single points rule applied to a receipt.
+type Rule interface {
+	// Name is the unique identifier of the rule.
+	Name() string
+	// Description is a human-readable summary of the rule.
+	Description() string
+	// Evaluate returns the points earned by the receipt under this rule.
+	Evaluate(receipt *models.Receipt) (int64, error)
+}
+
+// ItemizedRule is a Rule able to explain its points as breakdown entries
+// (e.g. one entry per item for the per-item rules).
+type ItemizedRule interface {
+	Rule
+	// Itemize returns the breakdown entries of the rule, which sum up to the result of Evaluate.
+	Itemize(receipt *models.Receipt) ([]models.PointsBreakdownEntry, error)
+}
+
+// funcRule is a Rule backed by a plain evaluation function.
+type funcRule struct {
+	name        string
+	description string
+	evaluate    func(receipt *models.Receipt) (int64, error)
+}
+
+func (r *funcRule) Name() string        { return r.name }
+func (r *funcRule) Description() string { return r.description }
+func (r *funcRule) Evaluate(receipt *models.Receipt) (int64, error) {
+	return r.evaluate(receipt)
+}
+
+// NewRule
+// @Description    Create a rule from a name, a description and an evaluation function.
+// @Param          name: string, description: string, evaluate: func(*models.Receipt) (int64, error)
+// @Return         the new rule: Rule
+func NewRule(name string, description string, evaluate func(receipt *models.Receipt) (int64, error)) Rule {
+	return &funcRule{
+		name:        name,
+		description: description,
+		evaluate:    evaluate,
+	}
+}
+
+// itemizedFuncRule is an ItemizedRule backed by a plain itemization function.
+type itemizedFuncRule struct {
+	name        string
+	description string
+	itemize     func(receipt *models.Receipt) ([]models.PointsBreakdownEntry, error)
+}
+
+func (r *itemizedFuncRule) Name() string        { return r.name }
+func (r *itemizedFuncRule) Description() string { return r.description }
+func (r *itemizedFuncRule) Itemize(receipt *models.Receipt) ([]models.PointsBreakdownEntry, error) {
+	return r.itemize(receipt)
+}
+func (r *itemizedFuncRule) Evaluate(receipt *models.Receipt) (int64, error) {
+	entries, err := r.itemize(receipt)
+	if err != nil {
+		return 0, err
+	}
+	var points int64 = 0
+	for _, entry := range entries {
+		points += entry.Points
+	}
+	return points, nil
+}
+
+// NewItemizedRule
+// @Description    Create a rule from a name, a description and an itemization function.
+//                 The points of the rule are the sum of the returned entries.
+// @Param          name: string, description: string, itemize: func(*models.Receipt) ([]models.PointsBreakdownEntry, error)
+// @Return         the new rule: Rule
+func NewItemizedRule(name string, description string, itemize func(receipt *models.Receipt) ([]models.PointsBreakdownEntry, error)) Rule {
+	return &itemizedFuncRule{
+		name:        name,
+		description: description,
+		itemize:     itemize,
+	}
+}
+
+// itemizeRule
+// @Description    Get the breakdown entries of any rule. Rules that are not itemized produce a single entry.
+// @Param          rule: Rule, receipt: *models.Receipt
+// @Return         breakdown entries: []models.PointsBreakdownEntry, error: error
+func itemizeRule(rule Rule, receipt *models.Receipt) ([]models.PointsBreakdownEntry, error) {
+	if itemized, ok := rule.(ItemizedRule); ok {
+		entries, err := itemized.Itemize(receipt)
+		if err != nil {
+			return nil, err
+		}
+		// the rule id is always the rule name, whatever the itemization returned
+		for i := range entries {
+			entries[i].RuleID = rule.Name()
+		}
+		return entries, nil
+	}
+
+	points, err := rule.Evaluate(receipt)
+	if err != nil {
+		return nil, err
+	}
+	return []models.PointsBreakdownEntry{{
+		RuleID: rule.Name(),
+		Reason: rule.Description(),
+		Points: points,
+	}}, nil
+}
+
+////////////////////////////
+//     RULE REGISTRY      //
+////////////////////////////
+
+// RuleSet is an immutable, versioned list of rules.
+// Every change of the rules applied by the registry produces a new rule set, old ones are kept for re-scoring.
+type RuleSet struct {
+	Version     string
+	Rules       []Rule
+	TotalCheck  models.TotalCheckPolicy // consistency check of the total, run before the rules (zero value: off)
+	fingerprint string                  // identifies the configuration the set was built from, empty for programmatic sets
+	config      *RuleConfig             // configuration the set was built from, nil for programmatic sets
+}
+
+// NewRuleSet
+// @Description    Create a rule set with the given version and rules, in order.
+// @Param          version: string, rules: ...Rule
+// @Return         pointer to the rule set: *RuleSet, error: error (empty version, nil rule or duplicate rule names)
+func NewRuleSet(version string, rules ...Rule) (*RuleSet, error) {
+	if version == "" {
+		return nil, fmt.Errorf("[NewRuleSet] Rule set version is required")
+	}
+
+	names := map[string]bool{}
+	for _, rule := range rules {
+		if rule == nil {
+			return nil, fmt.Errorf("[NewRuleSet] Rule must not be nil")
+		}
+		if names[rule.Name()] {
+			return nil, fmt.Errorf("[NewRuleSet] Rule %v is already registered", rule.Name())
+		}
+		names[rule.Name()] = true
+	}
+
+	return &RuleSet{
+		Version: version,
+		Rules:   append([]Rule{}, rules...),
+	}, nil
+}
+
+// RuleRegistry holds the active rule set applied by CalculateTotalPoints, and the history of all the rule sets.
+type RuleRegistry struct {
+	mu       sync.RWMutex
+	active   *RuleSet
+	history  map[string]*RuleSet
+	versions []string     // versions in activation order
+	revision int          // number of programmatic changes (Register/Unregister)
+	recorder *RuleHistory // records the new versions built from a configuration (see WithHistory), nil if not persisted
+}
+
+// DefaultRuleSetVersion is the version of the built-in rules.
+const DefaultRuleSetVersion = "builtin"
+
+// ensuring the singleton pattern
+var (
+	registryInstance *RuleRegistry
+	registryOnce     sync.Once
+)
+
+// NewRuleRegistry
+// @Description    Create a registry with the given rule set as the active one.
+// @Param          initial: *RuleSet
+// @Return         pointer to the registry: *RuleRegistry, error: error
+func NewRuleRegistry(initial *RuleSet) (*RuleRegistry, error) {
+	registry := &RuleRegistry{
+		history: map[string]*RuleSet{},
+	}
+	if err := registry.Activate(initial); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// GetRuleRegistry
+// @Description    Get the singleton registry used by CalculateTotalPoints, initialized with the default rules.
+// @Param          none
+// @Return         pointer to the registry: *RuleRegistry
+func GetRuleRegistry() *RuleRegistry {
+	registryOnce.Do(func() {
+		set, err := DefaultRuleConfig().BuildRuleSet()
+		if err == nil {
+			registryInstance, err = NewRuleRegistry(set)
+		}
+		if err != nil {
+			// the default configuration is static, failing here is a programming error
+			panic(err)
+		}
+	})
+	return registryInstance
+}
+
+// Activate
+// @Description    Make the given rule set the active one, and keep it in the history.
+//                 Calculations already running keep the rule set they started with.
+//                 A version can be activated again only with the same configuration.
+//                 With a history (see WithHistory), a new version is recorded in it before being activated.
+// @Param          set: *RuleSet
+// @Return         error: error (the active rule set is then left untouched)
+func (r *RuleRegistry) Activate(set *RuleSet) error {
+	if set == nil || set.Version == "" {
+		return fmt.Errorf("[Activate] Rule set and its version are required")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, exists := r.history[set.Version]; exists {
+		if existing.fingerprint == "" || existing.fingerprint != set.fingerprint {
+			return fmt.Errorf("[Activate] Rule set version %v already exists with different rules", set.Version)
+		}
+		// same configuration, keep the original set so that the history does not change
+		r.active = existing
+		return nil
+	}
+	if r.recorder != nil {
+		if err := r.recorder.record(set, time.Now()); err != nil {
+			return fmt.Errorf("[Activate] %w", err)
+		}
+	}
+
+	r.history[set.Version] = set
+	r.versions = append(r.versions, set.Version)
+	r.active = set
+	return nil
+}
+
+// Register
+// @Description    Append a rule to the active rules, producing a new rule set version. Rule names must be unique.
+//                 Refused with a history (see WithHistory), as the new version could not be restored after a restart.
+// @Param          rule: Rule
+// @Return         error: error
+func (r *RuleRegistry) Register(rule Rule) error {
+	if rule == nil {
+		return fmt.Errorf("[Register] Rule must not be nil")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.active.Rules {
+		if existing.Name() == rule.Name() {
+			return fmt.Errorf("[Register] Rule %v is already registered", rule.Name())
+		}
+	}
+	return r.commitLocked(append(append([]Rule{}, r.active.Rules...), rule))
+}
+
+// Unregister
+// @Description    Remove a rule from the active rules by name, producing a new rule set version.
+//                 Refused with a history (see WithHistory), as the new version could not be restored after a restart.
+// @Param          name: string
+// @Return         true if the rule was found and removed (false if refused): bool
+func (r *RuleRegistry) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.active.Rules {
+		if existing.Name() == name {
+			rules := make([]Rule, 0, len(r.active.Rules)-1)
+			rules = append(rules, r.active.Rules[:i]...)
+			return r.commitLocked(append(rules, r.active.Rules[i+1:]...)) == nil
+		}
+	}
+	return false
+}
+
+// commitLocked
+// @Description    Activate a programmatic change of the rules, versioned as "<previous version>+<revision>".
+//                 The caller must hold r.mu.
+// @Param          rules: []Rule
+// @Return         error: error (a history is attached: programmatic rules cannot be recorded in it)
+func (r *RuleRegistry) commitLocked(rules []Rule) error {
+	if r.recorder != nil {
+		return fmt.Errorf("[commitLocked] Programmatic rules cannot be recorded in the rule history, change the rule configuration instead")
+	}
+	r.revision++
+	set := &RuleSet{
+		Version:    fmt.Sprintf("%v+%d", r.active.Version, r.revision),
+		Rules:      rules,
+		TotalCheck: r.active.TotalCheck,
+	}
+	r.history[set.Version] = set
+	r.versions = append(r.versions, set.Version)
+	r.active = set
+	return nil
+}
+
+// Active
+// @Description    Get the active rule set. Rule sets are immutable, the result can be used for a whole calculation.
+// @Param          none
+// @Return         active rule set: *RuleSet
+func (r *RuleRegistry) Active() *RuleSet {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.active
+}
+
+// Version
+// @Description    Get a rule set (active or historic) by version.
+// @Param          version: string
+// @Return         rule set: *RuleSet, found: bool
+func (r *RuleRegistry) Version(version string) (*RuleSet, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	set, found := r.history[version]
+	return set, found
+}
+
+// Versions
+// @Description    Get all the known rule set versions, in activation order.
+// @Param          none
+// @Return         versions: []string
+func (r *RuleRegistry) Versions() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string{}, r.versions...)
+}
+
+// Rules
+// @Description    Get a snapshot of the active rules, in evaluation order.
+// @Param          none
+// @Return         active rules: []Rule
+func (r *RuleRegistry) Rules() []Rule {
+	return append([]Rule{}, r.Active().Rules...)
+}
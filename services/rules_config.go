@@ -0,0 +1,607 @@
+// services/v1/rules_config.go
+// Declarative rule configuration: rule types, their parameters, and loading from a YAML/JSON file.
+// The default configuration produces the same points as the original hard-coded rules.
+
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"receipt-processor/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuleConfig is the declarative description of the rules applied by CalculateTotalPoints.
+// The rules are applied in the order of the list.
+// Version identifies the rule set built from the configuration, it defaults to a hash of the rules ("config-<hash>").
+type RuleConfig struct {
+	Version    string            `yaml:"version,omitempty" json:"version,omitempty"`
+	Rules      []RuleSpec        `yaml:"rules" json:"rules"`
+	TotalCheck *TotalCheckConfig `yaml:"total_check,omitempty" json:"total_check,omitempty"`
+}
+
+// TotalCheckConfig configures the consistency check of the receipt total against the sum of the item prices,
+// run before the rules. Missing fields default to mode "off" and on_mismatch "reject".
+type TotalCheckConfig struct {
+	Mode             string `yaml:"mode,omitempty" json:"mode,omitempty"`                           // off, strict or tolerant
+	Tolerance        string `yaml:"tolerance,omitempty" json:"tolerance,omitempty"`                 // absolute allowance, e.g. "1.50" (tolerant mode)
+	TolerancePercent int64  `yaml:"tolerance_percent,omitempty" json:"tolerance_percent,omitempty"` // allowance in percent of the sum (tolerant mode)
+	OnMismatch       string `yaml:"on_mismatch,omitempty" json:"on_mismatch,omitempty"`             // reject or flag
+}
+
+// RuleSpec describes a single rule of the configuration.
+// Name defaults to Type, it must be unique in the configuration.
+// Missing params take the default values of the rule type.
+type RuleSpec struct {
+	Type   string         `yaml:"type" json:"type"`
+	Name   string         `yaml:"name,omitempty" json:"name,omitempty"`
+	Params map[string]any `yaml:"params,omitempty" json:"params,omitempty"`
+}
+
+// ParamsDecoder decodes the params of a RuleSpec into a struct (yaml tags), refusing unknown params.
+type ParamsDecoder func(out any) error
+
+// RuleFactory builds a rule of a given type from its name and params.
+type RuleFactory func(name string, decodeParams ParamsDecoder) (Rule, error)
+
+var (
+	ruleTypesMu sync.RWMutex
+	ruleTypes   = map[string]RuleFactory{
+		"retailer-name":        newRetailerNameRule,
+		"round-dollar-total":   newRoundDollarTotalRule,
+		"total-multiple":       newTotalMultipleRule,
+		"item-pairs":           newItemPairsRule,
+		"item-description":     newItemDescriptionRule,
+		"odd-purchase-day":     newOddPurchaseDayRule,
+		"purchase-time-window": newPurchaseTimeWindowRule,
+	}
+)
+
+// RegisterRuleType
+// @Description    Register a new rule type, so that it can be used from the rule configuration.
+// @Param          ruleType: string, factory: RuleFactory
+// @Return         error: error (empty type, nil factory or type already registered)
+func RegisterRuleType(ruleType string, factory RuleFactory) error {
+	if ruleType == "" || factory == nil {
+		return fmt.Errorf("[RegisterRuleType] Rule type and factory are required")
+	}
+
+	ruleTypesMu.Lock()
+	defer ruleTypesMu.Unlock()
+	if _, exists := ruleTypes[ruleType]; exists {
+		return fmt.Errorf("[RegisterRuleType] Rule type %v is already registered", ruleType)
+	}
+	ruleTypes[ruleType] = factory
+	return nil
+}
+
+
+////////////////////////////
+//   LOADING & BUILDING   //
+////////////////////////////
+
+
+// DefaultRuleConfig
+// @Description    Get the configuration of the seven built-in rules (see the summary in points_helpers.go).
+// @Param          none
+// @Return         default configuration: *RuleConfig
+func DefaultRuleConfig() *RuleConfig {
+	return &RuleConfig{
+		Version: DefaultRuleSetVersion,
+		Rules: []RuleSpec{
+			{Type: "retailer-name", Params: map[string]any{"points_per_character": 1}},
+			{Type: "round-dollar-total", Params: map[string]any{"points": 50}},
+			{Type: "total-multiple", Name: "quarter-multiple-total", Params: map[string]any{"multiple": "0.25", "points": 25}},
+			{Type: "item-pairs", Params: map[string]any{"points_per_pair": 5}},
+			{Type: "item-description", Params: map[string]any{"length_multiple": 3, "price_multiplier": 0.2}},
+			{Type: "odd-purchase-day", Params: map[string]any{"points": 6}},
+			{Type: "purchase-time-window", Params: map[string]any{"start": "14:00", "end": "16:00", "points": 10}},
+		},
+	}
+}
+
+// DefaultRules
+// @Description    Build the seven built-in rules from the default configuration.
+// @Param          none
+// @Return         built-in rules: []Rule
+func DefaultRules() []Rule {
+	rules, err := DefaultRuleConfig().BuildRules()
+	if err != nil {
+		// the default configuration is static, failing here is a programming error
+		panic(err)
+	}
+	return rules
+}
+
+// ParseRuleConfig
+// @Description    Parse a rule configuration from YAML or JSON (JSON being a subset of YAML).
+//                 Unknown fields are refused. The rules are not validated until BuildRules.
+// @Param          data: []byte
+// @Return         parsed configuration: *RuleConfig, error: error
+func ParseRuleConfig(data []byte) (*RuleConfig, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	var config RuleConfig
+	if err := decoder.Decode(&config); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("[ParseRuleConfig] Rule configuration is empty")
+		}
+		return nil, fmt.Errorf("[ParseRuleConfig] Failed to parse rule configuration: %w", err)
+	}
+	return &config, nil
+}
+
+// LoadRuleConfig
+// @Description    Read and parse a rule configuration file (.yaml, .yml or .json).
+// @Param          path: string
+// @Return         parsed configuration: *RuleConfig, error: error
+func LoadRuleConfig(path string) (*RuleConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("[LoadRuleConfig] Failed to read rule configuration %v: %w", path, err)
+	}
+
+	config, err := ParseRuleConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("[LoadRuleConfig] Invalid rule configuration %v: %w", path, err)
+	}
+	return config, nil
+}
+
+// LoadRules
+// @Description    Read, parse and validate a rule configuration file, and build its rules.
+// @Param          path: string
+// @Return         rules: []Rule, error: error
+func LoadRules(path string) ([]Rule, error) {
+	config, err := LoadRuleConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := config.BuildRules()
+	if err != nil {
+		return nil, fmt.Errorf("[LoadRules] Invalid rule configuration %v: %w", path, err)
+	}
+	return rules, nil
+}
+
+// LoadRuleSet
+// @Description    Read, parse and validate a rule configuration file, and build its versioned rule set.
+// @Param          path: string
+// @Return         rule set: *RuleSet, error: error
+func LoadRuleSet(path string) (*RuleSet, error) {
+	config, err := LoadRuleConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	set, err := config.BuildRuleSet()
+	if err != nil {
+		return nil, fmt.Errorf("[LoadRuleSet] Invalid rule configuration %v: %w", path, err)
+	}
+	return set, nil
+}
+
+// BuildRuleSet
+// @Description    Validate the configuration and build its versioned rule set.
+// @Param          none
+// @Return         rule set: *RuleSet, error: error
+func (c *RuleConfig) BuildRuleSet() (*RuleSet, error) {
+	rules, err := c.BuildRules()
+	if err != nil {
+		return nil, err
+	}
+
+	totalCheck, err := c.TotalCheck.policy()
+	if err != nil {
+		return nil, fmt.Errorf("[BuildRuleSet] total_check: %w", err)
+	}
+
+	// the fingerprint ignores the version, so that an unversioned configuration is identified by its rules only
+	// (and its total check when enabled, so that the fingerprints of the configurations without it are unchanged)
+	var fingerprinted any = c.Rules
+	if totalCheck.Mode != models.TotalCheckOff {
+		fingerprinted = struct {
+			Rules      []RuleSpec
+			TotalCheck models.TotalCheckPolicy
+		}{c.Rules, totalCheck}
+	}
+	data, err := yaml.Marshal(fingerprinted)
+	if err != nil {
+		return nil, fmt.Errorf("[BuildRuleSet] Failed to fingerprint the rule configuration: %w", err)
+	}
+	hash := sha256.Sum256(data)
+	fingerprint := hex.EncodeToString(hash[:])
+
+	version := c.Version
+	if version == "" {
+		version = "config-" + fingerprint[:12]
+	}
+
+	set, err := NewRuleSet(version, rules...)
+	if err != nil {
+		return nil, fmt.Errorf("[BuildRuleSet] %w", err)
+	}
+	set.fingerprint = fingerprint
+	set.TotalCheck = totalCheck
+	config := *c
+	set.config = &config
+	return set, nil
+}
+
+// policy
+// @Description    Validate the total check configuration and convert it to a policy (mode off if not configured).
+// @Param          none
+// @Return         policy: models.TotalCheckPolicy, error: error
+func (c *TotalCheckConfig) policy() (models.TotalCheckPolicy, error) {
+	policy := models.TotalCheckPolicy{Mode: models.TotalCheckOff, OnMismatch: models.TotalMismatchReject}
+	if c == nil {
+		return policy, nil
+	}
+	if c.Mode != "" {
+		policy.Mode = models.TotalCheckMode(c.Mode)
+	}
+	if c.OnMismatch != "" {
+		policy.OnMismatch = models.TotalMismatchAction(c.OnMismatch)
+	}
+	if c.Tolerance != "" {
+		tolerance, err := models.ParseMoney(c.Tolerance)
+		if err != nil {
+			return policy, fmt.Errorf("tolerance must be an amount with 2 decimals (e.g. \"1.50\"), got %q", c.Tolerance)
+		}
+		policy.Tolerance = tolerance
+	}
+	policy.TolerancePercent = c.TolerancePercent
+	if err := policy.Validate(); err != nil {
+		return policy, err
+	}
+	return policy, nil
+}
+
+// BuildRules
+// @Description    Validate the configuration and build its rules, in order.
+// @Param          none
+// @Return         rules: []Rule, error: error (the first invalid rule, e.g. "rules[3] (item-pairs): ...")
+func (c *RuleConfig) BuildRules() ([]Rule, error) {
+	if len(c.Rules) == 0 {
+		return nil, fmt.Errorf("[BuildRules] At least one rule is required")
+	}
+
+	rules := make([]Rule, 0, len(c.Rules))
+	names := map[string]bool{}
+	for i, spec := range c.Rules {
+		name := spec.Name
+		if name == "" {
+			name = spec.Type
+		}
+
+		ruleTypesMu.RLock()
+		factory, exists := ruleTypes[spec.Type]
+		ruleTypesMu.RUnlock()
+		if !exists {
+			return nil, fmt.Errorf("[BuildRules] rules[%d]: unknown rule type %q", i, spec.Type)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("[BuildRules] rules[%d] (%v): duplicate rule name %q", i, spec.Type, name)
+		}
+		names[name] = true
+
+		rule, err := factory(name, paramsDecoder(spec.Params))
+		if err != nil {
+			return nil, fmt.Errorf("[BuildRules] rules[%d] (%v): %w", i, name, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// paramsDecoder
+// @Description    Build the decoder of raw params. The target struct keeps its values for missing params.
+// @Param          params: map[string]any
+// @Return         decoder: ParamsDecoder
+func paramsDecoder(params map[string]any) ParamsDecoder {
+	return func(out any) error {
+		if len(params) == 0 {
+			return nil
+		}
+		data, err := yaml.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("invalid params: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(out); err != nil {
+			return fmt.Errorf("invalid params: %w", err)
+		}
+		return nil
+	}
+}
+
+
+////////////////////////////
+//   BUILT-IN RULE TYPES  //
+////////////////////////////
+
+
+// newRetailerNameRule
+// @Description    Rule 1: points for every alphanumeric character in the retailer name.
+//                 Params: points_per_character (default 1)
+// @Param          name: string, decodeParams: ParamsDecoder
+// @Return         rule: Rule, error: error
+func newRetailerNameRule(name string, decodeParams ParamsDecoder) (Rule, error) {
+	params := struct {
+		PointsPerCharacter int64 `yaml:"points_per_character"`
+	}{PointsPerCharacter: 1}
+	if err := decodeParams(&params); err != nil {
+		return nil, err
+	}
+	if params.PointsPerCharacter < 0 {
+		return nil, fmt.Errorf("params.points_per_character must not be negative, got %d", params.PointsPerCharacter)
+	}
+
+	description := fmt.Sprintf("%d point(s) for every alphanumeric character in the retailer name.", params.PointsPerCharacter)
+	return NewItemizedRule(name, description, func(receipt *models.Receipt) ([]models.PointsBreakdownEntry, error) {
+		points, err := calculateRetailerNamePointsWith(receipt.Retailer, params.PointsPerCharacter)
+		if err != nil {
+			return nil, err
+		}
+		return singleEntry(points, "retailer", "%d alphanumeric characters in the retailer name %q", countAlphanumericChar(receipt.Retailer), receipt.Retailer), nil
+	}), nil
+}
+
+// newRoundDollarTotalRule
+// @Description    Rule 2: points if the total is a round dollar amount with no cents.
+//                 Params: points (default 50)
+// @Param          name: string, decodeParams: ParamsDecoder
+// @Return         rule: Rule, error: error
+func newRoundDollarTotalRule(name string, decodeParams ParamsDecoder) (Rule, error) {
+	params := struct {
+		Points int64 `yaml:"points"`
+	}{Points: 50}
+	if err := decodeParams(&params); err != nil {
+		return nil, err
+	}
+	if params.Points < 0 {
+		return nil, fmt.Errorf("params.points must not be negative, got %d", params.Points)
+	}
+
+	description := fmt.Sprintf("%d points if the total is a round dollar amount with no cents.", params.Points)
+	return NewItemizedRule(name, description, func(receipt *models.Receipt) ([]models.PointsBreakdownEntry, error) {
+		points, err := calculateRoundDollarPointsWith(receipt.Total, params.Points)
+		if err != nil {
+			return nil, err
+		}
+		if points > 0 {
+			return singleEntry(points, "total", "Total %v is a round dollar amount", receipt.Total), nil
+		}
+		return singleEntry(points, "total", "Total %v is not a round dollar amount", receipt.Total), nil
+	}), nil
+}
+
+// newTotalMultipleRule
+// @Description    Rule 3: points if the total is a multiple of an amount.
+//                 Params: multiple (amount with 2 decimals, default "0.25"), points (default 25)
+// @Param          name: string, decodeParams: ParamsDecoder
+// @Return         rule: Rule, error: error
+func newTotalMultipleRule(name string, decodeParams ParamsDecoder) (Rule, error) {
+	params := struct {
+		Multiple string `yaml:"multiple"`
+		Points   int64  `yaml:"points"`
+	}{Multiple: "0.25", Points: 25}
+	if err := decodeParams(&params); err != nil {
+		return nil, err
+	}
+	multiple, err := models.ParseMoney(params.Multiple)
+	if err != nil || multiple <= 0 {
+		return nil, fmt.Errorf("params.multiple must be a positive amount with 2 decimals (e.g. \"0.25\"), got %q", params.Multiple)
+	}
+	if params.Points < 0 {
+		return nil, fmt.Errorf("params.points must not be negative, got %d", params.Points)
+	}
+
+	description := fmt.Sprintf("%d points if the total is a multiple of %v.", params.Points, params.Multiple)
+	return NewItemizedRule(name, description, func(receipt *models.Receipt) ([]models.PointsBreakdownEntry, error) {
+		points, err := calculateTotalMultiplePointsWith(receipt.Total, multiple, params.Points)
+		if err != nil {
+			return nil, err
+		}
+		if points > 0 {
+			return singleEntry(points, "total", "Total %v is a multiple of %v", receipt.Total, params.Multiple), nil
+		}
+		return singleEntry(points, "total", "Total %v is not a multiple of %v", receipt.Total, params.Multiple), nil
+	}), nil
+}
+
+// newItemPairsRule
+// @Description    Rule 4: points for every two items on the receipt.
+//                 Params: points_per_pair (default 5)
+// @Param          name: string, decodeParams: ParamsDecoder
+// @Return         rule: Rule, error: error
+func newItemPairsRule(name string, decodeParams ParamsDecoder) (Rule, error) {
+	params := struct {
+		PointsPerPair int64 `yaml:"points_per_pair"`
+	}{PointsPerPair: 5}
+	if err := decodeParams(&params); err != nil {
+		return nil, err
+	}
+	if params.PointsPerPair < 0 {
+		return nil, fmt.Errorf("params.points_per_pair must not be negative, got %d", params.PointsPerPair)
+	}
+
+	description := fmt.Sprintf("%d points for every two items on the receipt.", params.PointsPerPair)
+	return NewItemizedRule(name, description, func(receipt *models.Receipt) ([]models.PointsBreakdownEntry, error) {
+		points, err := calculateItemPairsPointsWith(receipt.Items, params.PointsPerPair)
+		if err != nil {
+			return nil, err
+		}
+		return singleEntry(points, "items", "%d items on the receipt make %d pair(s)", len(receipt.Items), len(receipt.Items)/2), nil
+	}), nil
+}
+
+// newItemDescriptionRule
+// @Description    Rule 5: if the trimmed length of the item description is a multiple of a number,
+//                 multiply the price by a multiplier and round up to the nearest integer. One breakdown entry per item.
+//                 Params: length_multiple (default 3), price_multiplier (default 0.2)
+// @Param          name: string, decodeParams: ParamsDecoder
+// @Return         rule: Rule, error: error
+func newItemDescriptionRule(name string, decodeParams ParamsDecoder) (Rule, error) {
+	params := struct {
+		LengthMultiple  int     `yaml:"length_multiple"`
+		PriceMultiplier float64 `yaml:"price_multiplier"`
+	}{LengthMultiple: 3, PriceMultiplier: 0.2}
+	if err := decodeParams(&params); err != nil {
+		return nil, err
+	}
+	if params.LengthMultiple <= 0 {
+		return nil, fmt.Errorf("params.length_multiple must be positive, got %d", params.LengthMultiple)
+	}
+	if params.PriceMultiplier < 0 {
+		return nil, fmt.Errorf("params.price_multiplier must not be negative, got %v", params.PriceMultiplier)
+	}
+	multiplier, err := parsePriceMultiplier(params.PriceMultiplier)
+	if err != nil {
+		return nil, fmt.Errorf("params.price_multiplier must have at most %d decimals, got %v", maxMultiplierDecimals, params.PriceMultiplier)
+	}
+
+	description := fmt.Sprintf("If the trimmed length of the item description is a multiple of %d, multiply the price by %v and round up to the nearest integer.",
+		params.LengthMultiple, params.PriceMultiplier)
+	return NewItemizedRule(name, description, func(receipt *models.Receipt) ([]models.PointsBreakdownEntry, error) {
+		perItemPoints, err := calculatePerItemDescriptionPointsWith(receipt.Items, params.LengthMultiple, multiplier)
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]models.PointsBreakdownEntry, 0, len(receipt.Items))
+		for i, item := range receipt.Items {
+			// the description was already validated by calculatePerItemDescriptionPointsWith
+			trimmed, _ := trimDescription(item.ShortDescription)
+			source := fmt.Sprintf("items[%d]", i)
+			if len(trimmed) % params.LengthMultiple == 0 {
+				entries = append(entries, singleEntry(perItemPoints[i], source,
+					"Trimmed description %q has a length of %d (multiple of %d), price %v * %v rounded up",
+					trimmed, len(trimmed), params.LengthMultiple, item.Price, params.PriceMultiplier)...)
+			} else {
+				entries = append(entries, singleEntry(perItemPoints[i], source,
+					"Trimmed description %q has a length of %d (not a multiple of %d)", trimmed, len(trimmed), params.LengthMultiple)...)
+			}
+		}
+		return entries, nil
+	}), nil
+}
+
+// newOddPurchaseDayRule
+// @Description    Rule 6: points if the day in the purchase date is odd.
+//                 Params: points (default 6)
+// @Param          name: string, decodeParams: ParamsDecoder
+// @Return         rule: Rule, error: error
+func newOddPurchaseDayRule(name string, decodeParams ParamsDecoder) (Rule, error) {
+	params := struct {
+		Points int64 `yaml:"points"`
+	}{Points: 6}
+	if err := decodeParams(&params); err != nil {
+		return nil, err
+	}
+	if params.Points < 0 {
+		return nil, fmt.Errorf("params.points must not be negative, got %d", params.Points)
+	}
+
+	description := fmt.Sprintf("%d points if the day in the purchase date is odd.", params.Points)
+	return NewItemizedRule(name, description, func(receipt *models.Receipt) ([]models.PointsBreakdownEntry, error) {
+		points, err := calculatePurchaseDatePointsWith(receipt.PurchaseDate, params.Points)
+		if err != nil {
+			return nil, err
+		}
+		// already parsed successfully by calculatePurchaseDatePointsWith
+		date, _ := parsePurchaseDate(receipt.PurchaseDate)
+		if date.Day() & 1 == 1 {
+			return singleEntry(points, "purchaseDate", "Purchase date %v is on an odd day", receipt.PurchaseDate), nil
+		}
+		return singleEntry(points, "purchaseDate", "Purchase date %v is on an even day", receipt.PurchaseDate), nil
+	}), nil
+}
+
+// newPurchaseTimeWindowRule
+// @Description    Rule 7: points if the time of purchase is in the window [start, end).
+//                 Params: start (HH:MM, default "14:00"), end (HH:MM, default "16:00"), points (default 10)
+// @Param          name: string, decodeParams: ParamsDecoder
+// @Return         rule: Rule, error: error
+func newPurchaseTimeWindowRule(name string, decodeParams ParamsDecoder) (Rule, error) {
+	params := struct {
+		Start  string `yaml:"start"`
+		End    string `yaml:"end"`
+		Points int64  `yaml:"points"`
+	}{Start: "14:00", End: "16:00", Points: 10}
+	if err := decodeParams(&params); err != nil {
+		return nil, err
+	}
+	startMinute, err := parseMinuteOfDay(params.Start)
+	if err != nil {
+		return nil, fmt.Errorf("params.start must be a time formatted as HH:MM, got %q", params.Start)
+	}
+	endMinute, err := parseMinuteOfDay(params.End)
+	if err != nil {
+		return nil, fmt.Errorf("params.end must be a time formatted as HH:MM, got %q", params.End)
+	}
+	if startMinute >= endMinute {
+		return nil, fmt.Errorf("params.start (%v) must be before params.end (%v)", params.Start, params.End)
+	}
+	if params.Points < 0 {
+		return nil, fmt.Errorf("params.points must not be negative, got %d", params.Points)
+	}
+
+	description := fmt.Sprintf("%d points if the time of purchase is between %v (included) and %v (excluded).", params.Points, params.Start, params.End)
+	return NewItemizedRule(name, description, func(receipt *models.Receipt) ([]models.PointsBreakdownEntry, error) {
+		points, err := calculatePurchaseTimePointsWith(receipt.PurchaseTime, startMinute, endMinute, params.Points)
+		if err != nil {
+			return nil, err
+		}
+		if points > 0 {
+			return singleEntry(points, "purchaseTime", "Purchase time %v is between %v and %v", receipt.PurchaseTime, params.Start, params.End), nil
+		}
+		return singleEntry(points, "purchaseTime", "Purchase time %v is not between %v and %v", receipt.PurchaseTime, params.Start, params.End), nil
+	}), nil
+}
+
+
+////////////////////////////
+//         HELPERS        //
+////////////////////////////
+
+
+// singleEntry
+// @Description    Build a breakdown made of a single entry, with a formatted reason.
+//                 The rule id is filled in by itemizeRule.
+// @Param          points: int64, source: string, format: string, args: ...any
+// @Return         breakdown entries: []models.PointsBreakdownEntry
+func singleEntry(points int64, source string, format string, args ...any) []models.PointsBreakdownEntry {
+	return []models.PointsBreakdownEntry{{
+		Reason: fmt.Sprintf(format, args...),
+		Points: points,
+		Source: source,
+	}}
+}
+
+// parseMinuteOfDay
+// @Description    Parse a HH:MM time into minutes since midnight.
+// @Param          value: string
+// @Return         minutes since midnight: int, error: error
+func parseMinuteOfDay(value string) (int, error) {
+	if !regexp.MustCompile(`^\d{2}:\d{2}$`).MatchString(value) {
+		return 0, fmt.Errorf("[parseMinuteOfDay] Invalid time %v", value)
+	}
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("[parseMinuteOfDay] Invalid time %v: %w", value, err)
+	}
+	return parsed.Hour() * 60 + parsed.Minute(), nil
+}
@@ -0,0 +1,205 @@
+// services/rules_config_test.go
+// Tests for the declarative rule configuration.
+
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"receipt-processor/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test on the shipped configuration file and the default configuration
+// expected: same points as the original hard-coded rules
+func TestLoadRules_DefaultConfigFile(t *testing.T) {
+	rules, err := LoadRules(filepath.Join("..", "config", "rules.yaml"))
+	assert.NoError(t, err)
+	assert.Len(t, rules, 7)
+
+	set, err := LoadRuleSet(filepath.Join("..", "config", "rules.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", set.Version)
+
+	for i, rule := range DefaultRules() {
+		assert.Equal(t, rule.Name(), rules[i].Name())
+		assert.Equal(t, rule.Description(), rules[i].Description())
+	}
+
+	points, err := CalculatePointsWithRules(exampleReceipt(), rules)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(109), points)
+
+	receipt := &models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items: []models.Item{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+			{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+			{ShortDescription: "Knorr Creamy Chicken", Price: "1.26"},
+			{ShortDescription: "Doritos Nacho Cheese", Price: "3.35"},
+			{ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Price: "12.00"},
+		},
+		Total: "35.35",
+	}
+	points, err = CalculatePointsWithRules(receipt, rules)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(28), points)
+}
+
+// Test on parsing a JSON configuration with custom params
+// expected: the params are applied, missing params keep their defaults
+func TestParseRuleConfig_JSON(t *testing.T) {
+	config, err := ParseRuleConfig([]byte(`{
+		"rules": [
+			{"type": "round-dollar-total", "params": {"points": 100}},
+			{"type": "purchase-time-window", "name": "happy-hour", "params": {"start": "17:00", "end": "19:00"}},
+			{"type": "purchase-time-window"}
+		]
+	}`))
+	assert.NoError(t, err)
+
+	rules, err := config.BuildRules()
+	assert.NoError(t, err)
+	assert.Equal(t, "round-dollar-total", rules[0].Name())
+	assert.Equal(t, "happy-hour", rules[1].Name())
+	assert.Equal(t, "purchase-time-window", rules[2].Name())
+
+	receipt := exampleReceipt()
+	receipt.PurchaseTime = "18:30"
+	points, err := CalculatePointsWithRules(receipt, rules)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(110), points) // 100 + 10 from happy hour + 0 from the default window
+}
+
+// Test on invalid configurations
+// expected: clear errors pointing at the invalid rule
+func TestBuildRules_InvalidConfig(t *testing.T) {
+	cases := map[string]string{
+		"no rules":         `rules: []`,
+		"unknown type":     "rules:\n  - type: unknown",
+		"duplicate name":   "rules:\n  - type: item-pairs\n  - type: item-pairs",
+		"unknown param":    "rules:\n  - type: item-pairs\n    params: {points_per_item: 5}",
+		"negative points":  "rules:\n  - type: odd-purchase-day\n    params: {points: -6}",
+		"invalid multiple": "rules:\n  - type: total-multiple\n    params: {multiple: \"0.00\"}",
+		"invalid time":     "rules:\n  - type: purchase-time-window\n    params: {start: \"2pm\"}",
+		"reversed window":  "rules:\n  - type: purchase-time-window\n    params: {start: \"16:00\", end: \"14:00\"}",
+		"zero length":      "rules:\n  - type: item-description\n    params: {length_multiple: 0}",
+		"long multiplier":  "rules:\n  - type: item-description\n    params: {price_multiplier: 0.1234567}",
+		"unknown check":    "rules:\n  - type: item-pairs\ntotal_check: {mode: lenient}",
+		"strict tolerance": "rules:\n  - type: item-pairs\ntotal_check: {mode: strict, tolerance: \"1.00\"}",
+		"invalid action":   "rules:\n  - type: item-pairs\ntotal_check: {mode: strict, on_mismatch: ignore}",
+		"wrong param type": "rules:\n  - type: item-pairs\n    params: {points_per_pair: five}",
+	}
+	for name, data := range cases {
+		config, err := ParseRuleConfig([]byte(data))
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+		_, err = config.BuildRuleSet()
+		assert.Error(t, err, name)
+	}
+
+	_, err := ParseRuleConfig([]byte(""))
+	assert.Error(t, err)
+
+	_, err = ParseRuleConfig([]byte("rule:\n  - type: item-pairs"))
+	assert.Error(t, err)
+
+	config, _ := ParseRuleConfig([]byte("rules:\n  - type: item-pairs\n  - type: odd-purchase-day\n    params: {points: -1}"))
+	_, err = config.BuildRules()
+	assert.ErrorContains(t, err, "rules[1] (odd-purchase-day)")
+}
+
+// Test on loading configuration files
+// expected: errors for missing or invalid files
+func TestLoadRules_Files(t *testing.T) {
+	_, err := LoadRules(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"rules": [{"type": "item-pairs", "params": {"points_per_pair": -5}}]}`), 0o644))
+	_, err = LoadRules(path)
+	assert.ErrorContains(t, err, "points_per_pair")
+}
+
+// Test on registering a custom rule type
+// expected: the type can be used from the configuration, and cannot be registered twice
+func TestRegisterRuleType(t *testing.T) {
+	factory := func(name string, decodeParams ParamsDecoder) (Rule, error) {
+		params := struct {
+			Bonus int64 `yaml:"bonus"`
+		}{}
+		if err := decodeParams(&params); err != nil {
+			return nil, err
+		}
+		return NewRule(name, "Flat bonus.", func(receipt *models.Receipt) (int64, error) {
+			return params.Bonus, nil
+		}), nil
+	}
+	assert.NoError(t, RegisterRuleType("test-flat-bonus", factory))
+	assert.Error(t, RegisterRuleType("test-flat-bonus", factory))
+	assert.Error(t, RegisterRuleType("", factory))
+
+	config, err := ParseRuleConfig([]byte("rules:\n  - type: test-flat-bonus\n    params: {bonus: 42}"))
+	assert.NoError(t, err)
+	rules, err := config.BuildRules()
+	assert.NoError(t, err)
+
+	points, err := CalculatePointsWithRules(exampleReceipt(), rules)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), points)
+}
+
+// Test on the total check of the configuration
+// expected: a mismatching total is refused or flagged, and only an enabled check changes the fingerprint
+func TestBuildRuleSet_TotalCheck(t *testing.T) {
+	receipt := exampleReceipt()
+	receipt.Total = "10.00" // the items sum up to 9.00
+
+	config := DefaultRuleConfig()
+	config.Version = ""
+	plain, err := config.BuildRuleSet()
+	assert.NoError(t, err)
+	assert.Equal(t, models.TotalCheckOff, plain.TotalCheck.Mode)
+
+	config.TotalCheck = &TotalCheckConfig{Mode: "off"}
+	off, err := config.BuildRuleSet()
+	assert.NoError(t, err)
+	assert.Equal(t, plain.Version, off.Version)
+
+	config.TotalCheck = &TotalCheckConfig{Mode: "strict"}
+	strict, err := config.BuildRuleSet()
+	assert.NoError(t, err)
+	assert.NotEqual(t, plain.Version, strict.Version)
+	_, err = strict.Score(receipt)
+	var fields models.FieldErrors
+	if assert.ErrorAs(t, err, &fields) {
+		assert.Equal(t, models.CodeTotalMismatch, fields[0].Code)
+	}
+
+	config.TotalCheck = &TotalCheckConfig{Mode: "strict", OnMismatch: "flag"}
+	flag, err := config.BuildRuleSet()
+	assert.NoError(t, err)
+	result, err := flag.Score(receipt)
+	assert.NoError(t, err)
+	expected, _ := plain.Score(receipt)
+	assert.Equal(t, expected.Points, result.Points)
+	assert.Equal(t, models.PointsBreakdownEntry{
+		RuleID: TotalCheckRuleID,
+		Reason: "Flagged for review: the total 10.00 does not match the sum of the item prices 9.00 (allowed difference 0.00)",
+		Points: 0,
+		Source: "total",
+	}, result.Breakdown[0])
+
+	config.TotalCheck = &TotalCheckConfig{Mode: "tolerant", Tolerance: "1.00"}
+	tolerant, err := config.BuildRuleSet()
+	assert.NoError(t, err)
+	result, err = tolerant.Score(receipt)
+	assert.NoError(t, err)
+	assert.Equal(t, expected.Breakdown, result.Breakdown)
+}
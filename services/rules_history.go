@@ -0,0 +1,169 @@
+// services/v1/rules_history.go
+// Persistent history of the rule set versions built from a rule configuration.
+// Every receipt records the version that scored it: keeping the configuration of each version across restarts
+// lets a receipt scored with an older version be re-scored (e.g. by a partial reversal) under its exact rules.
+
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuleVersionRecord is the configuration of a rule set version, as kept in the history file.
+type RuleVersionRecord struct {
+	Version     string    `json:"version"`
+	Fingerprint string    `json:"fingerprint"`
+	Config      string    `json:"config"` // YAML rule configuration the version was built from
+	ActivatedAt time.Time `json:"activatedAt"`
+}
+
+// RuleHistory keeps the rule set versions activated from a configuration in a JSON file.
+// The file is rewritten atomically (temporary file then rename) every time a version is added.
+// Versions made of programmatic rules (Register/Unregister) cannot be written to a file: they are refused with a history.
+type RuleHistory struct {
+	path    string
+	mu      sync.Mutex
+	records []RuleVersionRecord
+}
+
+// OpenRuleHistory
+// @Description    Open the history file at the given path, which is created when the first version is recorded.
+// @Param          path: string
+// @Return         pointer to the history: *RuleHistory, error: error (unreadable or invalid file)
+func OpenRuleHistory(path string) (*RuleHistory, error) {
+	history := &RuleHistory{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[OpenRuleHistory] Failed to read the rule history %v: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &history.records); err != nil {
+		return nil, fmt.Errorf("[OpenRuleHistory] Invalid rule history %v: %w", path, err)
+	}
+	return history, nil
+}
+
+// Records
+// @Description    Get the recorded versions, in activation order.
+// @Param          none
+// @Return         records: []RuleVersionRecord
+func (h *RuleHistory) Records() []RuleVersionRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]RuleVersionRecord{}, h.records...)
+}
+
+// record
+// @Description    Add the configuration of a rule set to the history and write the file. A version already recorded,
+//                 or a rule set without configuration, is skipped.
+// @Param          set: *RuleSet, at: time.Time
+// @Return         error: error (the history is then left untouched)
+func (h *RuleHistory) record(set *RuleSet, at time.Time) error {
+	if set.config == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, existing := range h.records {
+		if existing.Version == set.Version {
+			return nil
+		}
+	}
+
+	config, err := yaml.Marshal(set.config)
+	if err != nil {
+		return fmt.Errorf("[record] Failed to encode the configuration of version %v: %w", set.Version, err)
+	}
+	records := append(append([]RuleVersionRecord{}, h.records...), RuleVersionRecord{
+		Version:     set.Version,
+		Fingerprint: set.fingerprint,
+		Config:      string(config),
+		ActivatedAt: at.UTC(),
+	})
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("[record] Failed to encode the rule history: %w", err)
+	}
+	if err := writeFileAtomic(h.path, data); err != nil {
+		return fmt.Errorf("[record] Failed to write the rule history %v: %w", h.path, err)
+	}
+	h.records = records
+	return nil
+}
+
+// writeFileAtomic
+// @Description    Write a file through a synced temporary file renamed over it, so that a crash leaves either the old or the new file.
+// @Param          path: string, data: []byte
+// @Return         error: error
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// WithHistory
+// @Description    Restore the versions of the history into the registry (as historic versions, the active rule set does
+//                 not change), then record every new version activated from a configuration in the history. Activating a
+//                 version that cannot be recorded is refused, as are the programmatic changes (Register/Unregister),
+//                 so that no receipt is scored with rules lost on restart.
+// @Param          history: *RuleHistory
+// @Return         error: error (a recorded configuration is invalid, or conflicts with a known version)
+func (r *RuleRegistry) WithHistory(history *RuleHistory) error {
+	restored := []*RuleSet{}
+	for _, record := range history.Records() {
+		config, err := ParseRuleConfig([]byte(record.Config))
+		if err != nil {
+			return fmt.Errorf("[WithHistory] Rule set version %v: %w", record.Version, err)
+		}
+		set, err := config.BuildRuleSet()
+		if err != nil {
+			return fmt.Errorf("[WithHistory] Rule set version %v: %w", record.Version, err)
+		}
+		if set.Version != record.Version || set.fingerprint != record.Fingerprint {
+			return fmt.Errorf("[WithHistory] Rule set version %v no longer builds the recorded rules", record.Version)
+		}
+		restored = append(restored, set)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, set := range restored {
+		if existing, exists := r.history[set.Version]; exists && existing.fingerprint != set.fingerprint {
+			return fmt.Errorf("[WithHistory] Rule set version %v already exists with different rules", set.Version)
+		}
+	}
+	for _, set := range restored {
+		if _, exists := r.history[set.Version]; !exists {
+			r.history[set.Version] = set
+			r.versions = append(r.versions, set.Version)
+		}
+	}
+	r.recorder = history
+	return nil
+}
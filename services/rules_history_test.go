@@ -0,0 +1,86 @@
+// services/rules_history_test.go
+// Tests for the persistent history of the rule set versions.
+
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test on restarting with the rule history
+// expected: the versions activated from a configuration are restored as historic versions and score as before,
+// the programmatic versions are refused
+func TestRuleHistory_Restart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules-history.json")
+	rulesPath := filepath.Join(dir, "rules.yaml")
+
+	// 1. first run: a configured version, then programmatic changes, which cannot be recorded
+	history, err := OpenRuleHistory(path)
+	assert.NoError(t, err)
+	registry, _ := NewRuleRegistry(defaultRuleSet())
+	assert.NoError(t, registry.WithHistory(history))
+	assert.NoError(t, os.WriteFile(rulesPath, []byte("version: v2\n"+bonusRulesConfig), 0o644))
+	assert.NoError(t, NewRuleReloader(rulesPath, registry).Reload())
+	assert.Error(t, registry.Register(NewRule("bonus", "1 point", nil)))
+	assert.False(t, registry.Unregister(registry.Rules()[0].Name()))
+	assert.Equal(t, []string{DefaultRuleSetVersion, "v2"}, registry.Versions())
+	assert.Equal(t, "v2", registry.Active().Version)
+	assert.Len(t, history.Records(), 1)
+
+	// 2. restart with the built-in rules active: v2 is known again, without being active
+	history, err = OpenRuleHistory(path)
+	assert.NoError(t, err)
+	restarted, _ := NewRuleRegistry(defaultRuleSet())
+	assert.NoError(t, restarted.WithHistory(history))
+	assert.Equal(t, []string{DefaultRuleSetVersion, "v2"}, restarted.Versions())
+	assert.Equal(t, DefaultRuleSetVersion, restarted.Active().Version)
+	set, found := restarted.Version("v2")
+	assert.True(t, found)
+	points, err := CalculatePointsWithRules(exampleReceipt(), set.Rules)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), points)
+
+	// 3. the same configuration can be activated again, other rules under v2 are refused
+	assert.NoError(t, NewRuleReloader(rulesPath, restarted).Reload())
+	assert.Len(t, history.Records(), 1)
+	assert.NoError(t, os.WriteFile(rulesPath, []byte("version: v2\nrules:\n  - type: odd-purchase-day\n"), 0o644))
+	assert.Error(t, NewRuleReloader(rulesPath, restarted).Reload())
+}
+
+// Test on a history that does not match the known rules
+// expected: a corrupted file or a conflicting version is refused, a version that cannot be recorded is not activated
+func TestRuleHistory_Errors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules-history.json")
+
+	// corrupted file
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0o644))
+	_, err := OpenRuleHistory(path)
+	assert.Error(t, err)
+
+	// a recorded version conflicting with a known one
+	config, _ := ParseRuleConfig([]byte("version: builtin\n" + bonusRulesConfig))
+	set, err := config.BuildRuleSet()
+	assert.NoError(t, err)
+	history, _ := OpenRuleHistory(filepath.Join(dir, "conflict.json"))
+	assert.NoError(t, history.record(set, time.Now()))
+	registry, _ := NewRuleRegistry(defaultRuleSet())
+	assert.Error(t, registry.WithHistory(history))
+
+	// a history that cannot be written
+	history, _ = OpenRuleHistory(filepath.Join(dir, "missing", "rules-history.json"))
+	registry, _ = NewRuleRegistry(defaultRuleSet())
+	assert.NoError(t, registry.WithHistory(history))
+	config, _ = ParseRuleConfig([]byte(bonusRulesConfig))
+	set, _ = config.BuildRuleSet()
+	assert.Error(t, registry.Activate(set))
+	assert.Equal(t, DefaultRuleSetVersion, registry.Active().Version)
+	_, found := registry.Version(set.Version)
+	assert.False(t, found)
+}
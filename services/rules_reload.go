@@ -0,0 +1,127 @@
+// services/v1/rules_reload.go
+// Hot reload of the points rules from the rule configuration file.
+// A reload replaces all the rules of the registry at once: a calculation sees either the old or the new rules, never a mix.
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// RuleReloader reloads the rules of a registry from a rule configuration file.
+type RuleReloader struct {
+	path     string
+	registry *RuleRegistry
+
+	mu      sync.Mutex // serializes the reloads
+	modTime time.Time  // modification time of the last file read
+	status  ReloadStatus
+}
+
+// ReloadStatus reports the outcome of the last reload.
+type ReloadStatus struct {
+	LastAttempt time.Time // zero if never reloaded
+	LastSuccess time.Time // zero if never reloaded successfully
+	LastError   error     // nil if the last attempt succeeded
+	RuleCount   int       // number of active rules after the last successful reload
+	Version     string    // rule set version activated by the last successful reload
+}
+
+// NewRuleReloader
+// @Description    Create a reloader of the given registry from the given configuration file.
+//                 Nothing is loaded until Reload is called.
+// @Param          path: string, registry: *RuleRegistry
+// @Return         pointer to the reloader: *RuleReloader
+func NewRuleReloader(path string, registry *RuleRegistry) *RuleReloader {
+	return &RuleReloader{
+		path:     path,
+		registry: registry,
+	}
+}
+
+// Reload
+// @Description    Load and validate the configuration file, then activate its rule set in the registry.
+//                 If anything fails, the previous rules stay active and the error is returned (and kept in Status).
+// @Param          none
+// @Return         error: error
+func (r *RuleReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reloadLocked()
+}
+
+// reloadLocked
+// @Description    Reload implementation, the caller must hold r.mu.
+// @Param          none
+// @Return         error: error
+func (r *RuleReloader) reloadLocked() error {
+	r.status.LastAttempt = time.Now()
+
+	// the modification time is recorded even on failure, so a watcher does not retry the same broken file forever
+	if info, err := os.Stat(r.path); err == nil {
+		r.modTime = info.ModTime()
+	}
+
+	set, err := LoadRuleSet(r.path)
+	if err == nil {
+		err = r.registry.Activate(set)
+	}
+	if err != nil {
+		r.status.LastError = fmt.Errorf("[Reload] Keeping the previous rules: %w", err)
+		return r.status.LastError
+	}
+
+	r.status.LastError = nil
+	r.status.LastSuccess = r.status.LastAttempt
+	r.status.RuleCount = len(set.Rules)
+	r.status.Version = set.Version
+	return nil
+}
+
+// Status
+// @Description    Get the outcome of the last reload.
+// @Param          none
+// @Return         reload status: ReloadStatus
+func (r *RuleReloader) Status() ReloadStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// Watch
+// @Description    Poll the configuration file and reload it whenever its modification time changes, until ctx is done.
+//                 onReload (optional) is called with the outcome of every reload triggered by the watcher.
+// @Param          ctx: context.Context, interval: time.Duration, onReload: func(error)
+// @Return         none
+func (r *RuleReloader) Watch(ctx context.Context, interval time.Duration, onReload func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(r.path)
+			if err != nil {
+				// the file may be in the middle of being replaced, try again on the next tick
+				continue
+			}
+
+			r.mu.Lock()
+			changed := !info.ModTime().Equal(r.modTime)
+			if changed {
+				err = r.reloadLocked()
+			}
+			r.mu.Unlock()
+
+			if changed && onReload != nil {
+				onReload(err)
+			}
+		}
+	}
+}
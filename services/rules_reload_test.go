@@ -0,0 +1,117 @@
+// services/rules_reload_test.go
+// Tests for the hot reload of the points rules.
+
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const bonusRulesConfig = "rules:\n  - type: round-dollar-total\n    params: {points: 1000}\n"
+
+// Test on reloading the rules
+// expected: valid files replace the rules, invalid files keep the previous rules and report the error
+func TestRuleReloader_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	registry, _ := NewRuleRegistry(defaultRuleSet())
+	reloader := NewRuleReloader(path, registry)
+
+	// missing file
+	assert.Error(t, reloader.Reload())
+	assert.Len(t, registry.Rules(), 7)
+
+	// valid file
+	assert.NoError(t, os.WriteFile(path, []byte(bonusRulesConfig), 0o644))
+	assert.NoError(t, reloader.Reload())
+	points, err := CalculatePointsWithRules(exampleReceipt(), registry.Rules())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), points)
+	assert.Equal(t, 1, reloader.Status().RuleCount)
+	assert.NoError(t, reloader.Status().LastError)
+
+	// invalid file
+	assert.NoError(t, os.WriteFile(path, []byte("rules:\n  - type: round-dollar-total\n    params: {points: -1}\n"), 0o644))
+	err = reloader.Reload()
+	assert.ErrorContains(t, err, "params.points")
+	assert.Equal(t, err, reloader.Status().LastError)
+	points, err = CalculatePointsWithRules(exampleReceipt(), registry.Rules())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), points)
+}
+
+// Test on watching the configuration file
+// expected: a change of the file triggers a reload
+func TestRuleReloader_Watch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(bonusRulesConfig), 0o644))
+	registry, _ := NewRuleRegistry(defaultRuleSet())
+	reloader := NewRuleReloader(path, registry)
+	assert.NoError(t, reloader.Reload())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloaded := make(chan error, 1)
+	go reloader.Watch(ctx, 5*time.Millisecond, func(err error) { reloaded <- err })
+
+	assert.NoError(t, os.WriteFile(path, []byte("rules:\n  - type: round-dollar-total\n    params: {points: 2000}\n"), 0o644))
+	// make sure the modification time changes even on coarse grained file systems
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path, future, future))
+
+	select {
+	case err := <-reloaded:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the watcher did not reload the rules")
+	}
+	points, err := CalculatePointsWithRules(exampleReceipt(), registry.Rules())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2000), points)
+}
+
+// Test on calculations running during reloads
+// expected: every calculation sees either the old or the new rules, never a mix
+func TestRuleRegistry_ConcurrentReplace(t *testing.T) {
+	oldSet, _ := DefaultRuleConfig().BuildRuleSet()
+	config, _ := ParseRuleConfig([]byte(bonusRulesConfig))
+	newSet, _ := config.BuildRuleSet()
+	registry, _ := NewRuleRegistry(defaultRuleSet())
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				assert.NoError(t, registry.Activate(newSet))
+			} else {
+				assert.NoError(t, registry.Activate(oldSet))
+			}
+		}
+	}()
+
+	for i := 0; i < 2000; i++ {
+		result, err := registry.Active().Score(exampleReceipt())
+		assert.NoError(t, err)
+		if result.RuleVersion == newSet.Version {
+			assert.Equal(t, int64(1000), result.Points)
+		} else {
+			assert.Equal(t, int64(109), result.Points)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,217 @@
+// services/rules_test.go
+// Tests for the rule abstraction and registry.
+
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"receipt-processor/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// example receipt from the instruction, 109 points under the default rules
+func exampleReceipt() *models.Receipt {
+	return &models.Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Total:        "9.00",
+		Items: []models.Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+	}
+}
+
+// default rule set, built from the default configuration
+func defaultRuleSet() *RuleSet {
+	set, err := DefaultRuleConfig().BuildRuleSet()
+	if err != nil {
+		panic(err)
+	}
+	return set
+}
+
+// Test on the default rules
+// expected: seven rules with unique names, adding up to the same points as the original helpers
+func TestDefaultRules(t *testing.T) {
+	rules := DefaultRules()
+	assert.Len(t, rules, 7)
+
+	names := map[string]bool{}
+	for _, rule := range rules {
+		assert.NotEmpty(t, rule.Description())
+		assert.False(t, names[rule.Name()], "duplicate rule name %v", rule.Name())
+		names[rule.Name()] = true
+	}
+
+	points, err := CalculatePointsWithRules(exampleReceipt(), rules)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(109), points)
+}
+
+// Test on registering and unregistering rules
+// expected: custom rules are applied, duplicates are refused, and snapshots are not affected by later changes
+func TestRuleRegistry(t *testing.T) {
+	registry, err := NewRuleRegistry(defaultRuleSet())
+	assert.NoError(t, err)
+
+	promotion := NewRule("promotion", "100 bonus points for every receipt.", func(receipt *models.Receipt) (int64, error) {
+		return 100, nil
+	})
+	assert.NoError(t, registry.Register(promotion))
+	assert.Error(t, registry.Register(promotion))
+	assert.Error(t, registry.Register(nil))
+
+	snapshot := registry.Rules()
+	points, err := CalculatePointsWithRules(exampleReceipt(), snapshot)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(209), points)
+
+	assert.True(t, registry.Unregister("promotion"))
+	assert.False(t, registry.Unregister("promotion"))
+	assert.Len(t, snapshot, 8)
+
+	points, err = CalculatePointsWithRules(exampleReceipt(), registry.Rules())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(109), points)
+
+	_, err = NewRuleSet("test", promotion, promotion)
+	assert.Error(t, err)
+}
+
+// Test on a failing rule
+// expected: the calculation stops with 0 points and the rule error is wrapped
+func TestCalculatePointsWithRules_RuleError(t *testing.T) {
+	ruleErr := errors.New("rule failed")
+	failing := NewRule("failing", "Always fails.", func(receipt *models.Receipt) (int64, error) {
+		return 0, ruleErr
+	})
+
+	points, err := CalculatePointsWithRules(exampleReceipt(), append(DefaultRules(), failing))
+	assert.ErrorIs(t, err, ruleErr)
+	assert.Equal(t, int64(0), points)
+}
+
+// Test on the points breakdown
+// expected: an entry for every rule (one per item for the item description rule), summing up to the total points
+func TestCalculatePointsBreakdown(t *testing.T) {
+	receipt := exampleReceipt()
+	points, breakdown, err := CalculatePointsBreakdownWithRules(receipt, DefaultRules())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(109), points)
+	// 6 single entry rules + 4 items
+	assert.Len(t, breakdown, 10)
+
+	var sum int64 = 0
+	perRule := map[string]int64{}
+	for _, entry := range breakdown {
+		assert.NotEmpty(t, entry.Reason)
+		sum += entry.Points
+		perRule[entry.RuleID] += entry.Points
+	}
+	assert.Equal(t, points, sum)
+	assert.Equal(t, int64(14), perRule["retailer-name"])
+	assert.Equal(t, int64(50), perRule["round-dollar-total"])
+	assert.Equal(t, int64(25), perRule["quarter-multiple-total"])
+	assert.Equal(t, int64(10), perRule["item-pairs"])
+	assert.Equal(t, int64(0), perRule["item-description"])
+	assert.Equal(t, int64(0), perRule["odd-purchase-day"])
+	assert.Equal(t, int64(10), perRule["purchase-time-window"])
+
+	// per item contributions of rule 5
+	receipt.Items[1].ShortDescription = "abc"
+	points, breakdown, err = CalculatePointsBreakdownWithRules(receipt, DefaultRules())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(110), points)
+	for _, entry := range breakdown {
+		if entry.Source == "items[1]" {
+			assert.Equal(t, "item-description", entry.RuleID)
+			assert.Equal(t, int64(1), entry.Points)
+		}
+	}
+
+	// rules that are not itemized produce a single entry with their description
+	promotion := NewRule("promotion", "100 bonus points for every receipt.", func(receipt *models.Receipt) (int64, error) {
+		return 100, nil
+	})
+	points, breakdown, err = CalculatePointsBreakdownWithRules(receipt, []Rule{promotion})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), points)
+	assert.Equal(t, []models.PointsBreakdownEntry{{RuleID: "promotion", Reason: "100 bonus points for every receipt.", Points: 100}}, breakdown)
+
+	// invalid receipt
+	points, breakdown, err = CalculatePointsBreakdownWithRules(&models.Receipt{}, DefaultRules())
+	assert.Error(t, err)
+	assert.Equal(t, int64(0), points)
+	assert.Nil(t, breakdown)
+}
+
+// Test on the rule set versions
+// expected: every change produces a new version, and historic versions are kept for re-scoring
+func TestRuleRegistry_Versions(t *testing.T) {
+	registry, err := NewRuleRegistry(defaultRuleSet())
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultRuleSetVersion, registry.Active().Version)
+
+	// programmatic changes
+	promotion := NewRule("promotion", "100 bonus points for every receipt.", func(receipt *models.Receipt) (int64, error) {
+		return 100, nil
+	})
+	assert.NoError(t, registry.Register(promotion))
+	assert.Equal(t, DefaultRuleSetVersion+"+1", registry.Active().Version)
+
+	// configuration without version, identified by its rules
+	config, _ := ParseRuleConfig([]byte("rules:\n  - type: round-dollar-total\n    params: {points: 1000}\n"))
+	set, err := config.BuildRuleSet()
+	assert.NoError(t, err)
+	assert.Regexp(t, `^config-[0-9a-f]{12}$`, set.Version)
+	assert.NoError(t, registry.Activate(set))
+
+	// the same configuration can be activated again, a different one cannot reuse a version
+	again, _ := config.BuildRuleSet()
+	assert.NoError(t, registry.Activate(again))
+	config.Rules[0].Params["points"] = 2000
+	config.Version = DefaultRuleSetVersion
+	conflicting, err := config.BuildRuleSet()
+	assert.NoError(t, err)
+	assert.Error(t, registry.Activate(conflicting))
+	assert.Equal(t, set.Version, registry.Active().Version)
+	assert.Error(t, registry.Activate(nil))
+
+	assert.Equal(t, []string{DefaultRuleSetVersion, DefaultRuleSetVersion + "+1", set.Version}, registry.Versions())
+
+	// historic versions score with their own rules
+	for version, expected := range map[string]int64{DefaultRuleSetVersion: 109, DefaultRuleSetVersion + "+1": 209, set.Version: 1000} {
+		historic, found := registry.Version(version)
+		assert.True(t, found)
+		result, err := historic.Score(exampleReceipt())
+		assert.NoError(t, err)
+		assert.Equal(t, expected, result.Points)
+		assert.Equal(t, version, result.RuleVersion)
+	}
+	_, found := registry.Version("unknown")
+	assert.False(t, found)
+}
+
+// Test on re-scoring with the singleton registry
+// expected: the receipt is scored with the requested version, unknown versions are refused
+func TestRescoreReceipt(t *testing.T) {
+	result, err := ScoreReceipt(exampleReceipt())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(109), result.Points)
+	assert.Equal(t, GetRuleRegistry().Active().Version, result.RuleVersion)
+
+	result, err = RescoreReceipt(exampleReceipt(), DefaultRuleSetVersion)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(109), result.Points)
+	assert.Equal(t, DefaultRuleSetVersion, result.RuleVersion)
+
+	_, err = RescoreReceipt(exampleReceipt(), "unknown")
+	assert.Error(t, err)
+}
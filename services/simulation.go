@@ -0,0 +1,109 @@
+// services/v1/simulation.go
+// What-if comparison of two rule sets over a list of receipts, e.g. to evaluate a rule change before it goes live.
+
+package services
+
+import (
+	"sort"
+
+	"receipt-processor/models"
+)
+
+// SimulationReceipt is a receipt to re-score in a simulation, with its ID.
+type SimulationReceipt struct {
+	ID      string
+	Receipt models.Receipt
+}
+
+// ReceiptDelta is the outcome of a simulation for a single receipt.
+type ReceiptDelta struct {
+	ID              string `json:"id"`
+	CurrentPoints   int64  `json:"currentPoints"`
+	CandidatePoints int64  `json:"candidatePoints"`
+	Delta           int64  `json:"delta"`
+	Error           string `json:"error,omitempty"` // set if one of the rule sets failed to score the receipt
+}
+
+// SimulationReport compares the points issued under the current and the candidate rule sets.
+// The aggregates only consider the receipts scored successfully under both rule sets.
+type SimulationReport struct {
+	CurrentVersion       string         `json:"currentVersion"`
+	CandidateVersion     string         `json:"candidateVersion"`
+	ReceiptCount         int            `json:"receiptCount"`
+	AffectedCount        int            `json:"affectedCount"` // receipts whose points change
+	FailedCount          int            `json:"failedCount"`
+	CurrentTotalPoints   int64          `json:"currentTotalPoints"`
+	CandidateTotalPoints int64          `json:"candidateTotalPoints"`
+	MeanDelta            float64        `json:"meanDelta"`
+	MedianDelta          float64        `json:"medianDelta"`
+	Receipts             []ReceiptDelta `json:"receipts"`
+}
+
+// SimulateRules
+// @Description    Re-score every receipt under both rule sets, and report the per receipt deltas and aggregate stats.
+//                 Nothing is stored, and the candidate rule set does not need to be activated.
+// @Param          receipts: []SimulationReceipt, current: *RuleSet, candidate: *RuleSet
+// @Return         simulation report: SimulationReport
+func SimulateRules(receipts []SimulationReceipt, current *RuleSet, candidate *RuleSet) SimulationReport {
+	report := SimulationReport{
+		CurrentVersion:   current.Version,
+		CandidateVersion: candidate.Version,
+		ReceiptCount:     len(receipts),
+		Receipts:         make([]ReceiptDelta, 0, len(receipts)),
+	}
+
+	deltas := []int64{}
+	for _, simulated := range receipts {
+		delta := ReceiptDelta{ID: simulated.ID}
+
+		receipt := simulated.Receipt
+		currentResult, err := current.Score(&receipt)
+		if err == nil {
+			delta.CurrentPoints = currentResult.Points
+			var candidateResult PointsResult
+			candidateResult, err = candidate.Score(&receipt)
+			delta.CandidatePoints = candidateResult.Points
+		}
+		if err != nil {
+			delta.Error = err.Error()
+			report.FailedCount++
+			report.Receipts = append(report.Receipts, delta)
+			continue
+		}
+
+		delta.Delta = delta.CandidatePoints - delta.CurrentPoints
+		if delta.Delta != 0 {
+			report.AffectedCount++
+		}
+		report.CurrentTotalPoints += delta.CurrentPoints
+		report.CandidateTotalPoints += delta.CandidatePoints
+		deltas = append(deltas, delta.Delta)
+		report.Receipts = append(report.Receipts, delta)
+	}
+
+	report.MeanDelta, report.MedianDelta = meanAndMedian(deltas)
+	return report
+}
+
+// meanAndMedian
+// @Description    Compute the mean and the median of a list of values (0 for an empty list).
+// @Param          values: []int64 (sorted in place)
+// @Return         mean: float64, median: float64
+func meanAndMedian(values []int64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+
+	var sum int64 = 0
+	for _, value := range values {
+		sum += value
+	}
+	mean := float64(sum) / float64(len(values))
+
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	middle := len(values) / 2
+	if len(values) % 2 == 1 {
+		return mean, float64(values[middle])
+	}
+	return mean, float64(values[middle-1] + values[middle]) / 2
+}
@@ -0,0 +1,63 @@
+// services/simulation_test.go
+// Tests for the rule simulation.
+
+package services
+
+import (
+	"testing"
+
+	"receipt-processor/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test on simulating a rule change
+// expected: per receipt deltas, and aggregate stats over the receipts scored under both rule sets
+func TestSimulateRules(t *testing.T) {
+	current := defaultRuleSet()
+	config, _ := ParseRuleConfig([]byte("rules:\n  - type: retailer-name\n    params: {points_per_character: 2}\n"))
+	candidate, err := config.BuildRuleSet()
+	assert.NoError(t, err)
+
+	target := exampleReceipt()
+	target.Retailer = "Target"
+	target.Total = "9.01"
+	target.PurchaseTime = "10:00"
+	receipts := []SimulationReceipt{
+		{ID: "a", Receipt: *exampleReceipt()}, // 109 -> 28
+		{ID: "b", Receipt: *target},           // 16 -> 12
+		{ID: "c", Receipt: models.Receipt{}},  // invalid
+	}
+
+	report := SimulateRules(receipts, current, candidate)
+	assert.Equal(t, current.Version, report.CurrentVersion)
+	assert.Equal(t, candidate.Version, report.CandidateVersion)
+	assert.Equal(t, 3, report.ReceiptCount)
+	assert.Equal(t, 2, report.AffectedCount)
+	assert.Equal(t, 1, report.FailedCount)
+	assert.Equal(t, int64(125), report.CurrentTotalPoints)
+	assert.Equal(t, int64(40), report.CandidateTotalPoints)
+	assert.Equal(t, -42.5, report.MeanDelta)
+	assert.Equal(t, -42.5, report.MedianDelta)
+
+	assert.Len(t, report.Receipts, 3)
+	assert.Equal(t, ReceiptDelta{ID: "a", CurrentPoints: 109, CandidatePoints: 28, Delta: -81}, report.Receipts[0])
+	assert.Equal(t, ReceiptDelta{ID: "b", CurrentPoints: 16, CandidatePoints: 12, Delta: -4}, report.Receipts[1])
+	assert.NotEmpty(t, report.Receipts[2].Error)
+
+	// no receipts
+	report = SimulateRules(nil, current, candidate)
+	assert.Equal(t, 0, report.ReceiptCount)
+	assert.Equal(t, 0.0, report.MeanDelta)
+}
+
+// Test on the helper function `meanAndMedian`
+func TestMeanAndMedian(t *testing.T) {
+	mean, median := meanAndMedian([]int64{5, -1, 2})
+	assert.Equal(t, 2.0, mean)
+	assert.Equal(t, 2.0, median)
+
+	mean, median = meanAndMedian([]int64{4, 0, 1, 10})
+	assert.Equal(t, 3.75, mean)
+	assert.Equal(t, 2.5, median)
+}
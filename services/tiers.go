@@ -0,0 +1,418 @@
+// services/v1/tiers.go
+// Loyalty tiers: the tier of a user depends on the points they earned over a rolling window, and multiplies the points
+// of their next receipts. The tier applied to a receipt is recorded in its points breakdown.
+
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"receipt-processor/models"
+)
+
+// TierRuleID is the rule id of the breakdown entry recording the tier applied to a receipt.
+const TierRuleID = "tier"
+
+// DefaultTierWindowMonths is the default rolling window of the tier evaluation.
+const DefaultTierWindowMonths = 12
+
+// Tier is a level of the loyalty program.
+type Tier struct {
+	Name        string
+	MinPoints   int64           // rolling points needed to reach the tier
+	Multiplier  priceMultiplier // applied to the base points of the receipts, rounded down
+	BonusPoints int64           // added to the points of every receipt
+}
+
+// TierPolicy defines the tiers and how they are reached.
+type TierPolicy struct {
+	Tiers        []Tier // ordered by MinPoints, the first one is reached with 0 points
+	WindowMonths int    // rolling window: the base points of the receipts purchased in the last WindowMonths months count
+}
+
+// TierChangeEvent is emitted when the tier of a user changes.
+type TierChangeEvent struct {
+	UserID        string    `json:"userId"`
+	From          string    `json:"from"` // empty for the first receipt of the user
+	To            string    `json:"to"`
+	RollingPoints int64     `json:"rollingPoints"`
+	ReceiptID     string    `json:"receiptId"` // receipt that caused the change
+	At            time.Time `json:"at"`
+}
+
+// TierEventSink receives the tier changes, e.g. to publish them to a message broker.
+// Emit is called while the tracker is locked, in the order of the changes: it must not block nor call the tracker.
+type TierEventSink interface {
+	Emit(event TierChangeEvent)
+}
+
+// TierEventSinkFunc adapts a function to a TierEventSink.
+type TierEventSinkFunc func(event TierChangeEvent)
+
+// Emit
+// @Description    Call the function with the event.
+// @Param          event: TierChangeEvent
+// @Return         none
+func (f TierEventSinkFunc) Emit(event TierChangeEvent) {
+	f(event)
+}
+
+// TierStatus is the current tier of a user.
+type TierStatus struct {
+	UserID           string `json:"userId"`
+	Tier             string `json:"tier"`
+	RollingPoints    int64  `json:"rollingPoints"`      // base points of the receipts in the rolling window
+	NextTier         string `json:"nextTier,omitempty"` // empty at the highest tier
+	PointsToNextTier int64  `json:"pointsToNextTier,omitempty"`
+}
+
+// tierAccrual is the base points of a receipt of a user, counted in the rolling window of its accrual date.
+type tierAccrual struct {
+	receiptID string
+	points    int64
+	accruedAt time.Time
+}
+
+// tierAccount holds the receipts of a single user that count for their tier.
+type tierAccount struct {
+	accruals []tierAccrual
+	applied  map[string]PointsResult // result of each receipt with its tier, a receipt is evaluated at most once
+	tier     string                  // tier after the last receipt
+}
+
+// TierTracker evaluates the tiers of the users. It is safe for concurrent use.
+type TierTracker struct {
+	mu       sync.Mutex
+	policy   TierPolicy
+	sink     TierEventSink
+	accounts map[string]*tierAccount
+}
+
+// DefaultTierPolicy
+// @Description    Get the default tiers: Bronze (x1), Silver from 500 rolling points (x1.25) and Gold from 1500 (x1.5), over 12 months.
+// @Param          none
+// @Return         policy: TierPolicy
+func DefaultTierPolicy() TierPolicy {
+	return TierPolicy{
+		Tiers: []Tier{
+			{Name: "Bronze", MinPoints: 0, Multiplier: priceMultiplier{num: 1, den: 1}},
+			{Name: "Silver", MinPoints: 500, Multiplier: priceMultiplier{num: 125, den: 100}},
+			{Name: "Gold", MinPoints: 1500, Multiplier: priceMultiplier{num: 15, den: 10}},
+		},
+		WindowMonths: DefaultTierWindowMonths,
+	}
+}
+
+// ParseTiers
+// @Description    Parse a tier list "Name:minPoints:multiplier[:bonusPoints],...", e.g. "Bronze:0:1,Silver:500:1.25,Gold:1500:1.5:10".
+// @Param          spec: string
+// @Return         tiers: []Tier, error: error
+func ParseTiers(spec string) ([]Tier, error) {
+	tiers := []Tier{}
+	for _, field := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(field), ":")
+		if len(parts) != 3 && len(parts) != 4 {
+			return nil, fmt.Errorf("[ParseTiers] Invalid tier %q, expected Name:minPoints:multiplier[:bonusPoints]", field)
+		}
+		tier := Tier{Name: parts[0]}
+		var err error
+		if tier.MinPoints, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("[ParseTiers] Invalid minimum points of tier %q: %w", tier.Name, err)
+		}
+		multiplier, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("[ParseTiers] Invalid multiplier of tier %q: %w", tier.Name, err)
+		}
+		if tier.Multiplier, err = parsePriceMultiplier(multiplier); err != nil {
+			return nil, fmt.Errorf("[ParseTiers] Invalid multiplier of tier %q: %w", tier.Name, err)
+		}
+		if len(parts) == 4 {
+			if tier.BonusPoints, err = strconv.ParseInt(parts[3], 10, 64); err != nil {
+				return nil, fmt.Errorf("[ParseTiers] Invalid bonus points of tier %q: %w", tier.Name, err)
+			}
+		}
+		tiers = append(tiers, tier)
+	}
+	return tiers, nil
+}
+
+// Validate
+// @Description    Check a tier policy: named tiers with increasing minimum points starting at 0, no negative bonus, a positive window.
+// @Param          none
+// @Return         error: error
+func (p TierPolicy) Validate() error {
+	if len(p.Tiers) == 0 {
+		return fmt.Errorf("[Validate] At least one tier is required")
+	}
+	if p.WindowMonths <= 0 {
+		return fmt.Errorf("[Validate] The tier window must be a positive number of months, got %d", p.WindowMonths)
+	}
+	names := map[string]bool{}
+	for i, tier := range p.Tiers {
+		switch {
+		case tier.Name == "" || names[tier.Name]:
+			return fmt.Errorf("[Validate] Tier %d must have a unique name, got %q", i, tier.Name)
+		case i == 0 && tier.MinPoints != 0:
+			return fmt.Errorf("[Validate] The first tier %q must start at 0 points, got %d", tier.Name, tier.MinPoints)
+		case i > 0 && tier.MinPoints <= p.Tiers[i-1].MinPoints:
+			return fmt.Errorf("[Validate] The minimum points of tier %q must be greater than those of tier %q", tier.Name, p.Tiers[i-1].Name)
+		case tier.Multiplier.den == 0 || tier.BonusPoints < 0:
+			return fmt.Errorf("[Validate] Tier %q must have a multiplier and no negative bonus", tier.Name)
+		}
+		names[tier.Name] = true
+	}
+	return nil
+}
+
+// NewTierTracker
+// @Description    Create a tier tracker without users.
+// @Param          policy: TierPolicy (valid), sink: TierEventSink (optional, receives the tier changes)
+// @Return         pointer to the tracker: *TierTracker
+func NewTierTracker(policy TierPolicy, sink TierEventSink) *TierTracker {
+	return &TierTracker{
+		policy:   policy,
+		sink:     sink,
+		accounts: map[string]*tierAccount{},
+	}
+}
+
+// Apply
+// @Description    Apply the tier of a user to the base result of a receipt and record it at once: see Quote and Record.
+//                 Callers that store the receipt in between must call Quote, then Record once the receipt is stored.
+// @Param          userID: string, receiptID: string, accruedAt: time.Time, base: PointsResult (scored by the rules), at: time.Time
+// @Return         result with the tier: PointsResult, error: error
+func (t *TierTracker) Apply(userID string, receiptID string, accruedAt time.Time, base PointsResult, at time.Time) (PointsResult, error) {
+	result, err := t.Quote(userID, receiptID, accruedAt, base)
+	if err != nil {
+		return PointsResult{}, fmt.Errorf("[Apply] %w", err)
+	}
+	t.Record(userID, receiptID, accruedAt, result, at)
+	return result, nil
+}
+
+// Quote
+// @Description    Apply the tier of a user to the base result of a receipt, without counting the receipt for the tier:
+//                 the tier is evaluated from the base points of the previous receipts of the user purchased in the rolling
+//                 window ending at the accrual date of the receipt, so the same history always gives the same tier.
+//                 The multiplied points are recorded in the breakdown (a "tier" entry, even without extra points).
+//                 Quoting a receipt already recorded returns its recorded result.
+// @Param          userID: string, receiptID: string, accruedAt: time.Time, base: PointsResult (scored by the rules)
+// @Return         result with the tier: PointsResult, error: error
+func (t *TierTracker) Quote(userID string, receiptID string, accruedAt time.Time, base PointsResult) (PointsResult, error) {
+	if userID == "" || receiptID == "" {
+		return PointsResult{}, fmt.Errorf("[Quote] User ID and receipt ID are required")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var rolling int64
+	if account, exists := t.accounts[userID]; exists {
+		if result, applied := account.applied[receiptID]; applied {
+			return result, nil
+		}
+		rolling = account.rollingPoints(accruedAt, t.policy.WindowMonths)
+	}
+	tier := t.policy.tierOf(rolling)
+	points, err := tier.Multiplier.applyFloor(base.Points)
+	if err != nil {
+		return PointsResult{}, fmt.Errorf("[Quote] Failed to multiply the points of receipt %v: %w", receiptID, err)
+	}
+	points += tier.BonusPoints
+
+	result := base
+	result.Points = points
+	result.Breakdown = append(append([]models.PointsBreakdownEntry{}, base.Breakdown...), models.PointsBreakdownEntry{
+		RuleID: TierRuleID,
+		Reason: tier.describe(rolling, t.policy.WindowMonths),
+		Points: points - base.Points,
+		Source: "userId",
+	})
+	return result, nil
+}
+
+// Record
+// @Description    Count a quoted receipt for the tier of a user (see Quote), once it is stored: its base points count for
+//                 the next evaluations, and a tier change is emitted. A receipt is recorded only once.
+// @Param          userID: string, receiptID: string, accruedAt: time.Time, result: PointsResult (quoted), at: time.Time
+// @Return         true if the receipt was recorded now: bool
+func (t *TierTracker) Record(userID string, receiptID string, accruedAt time.Time, result PointsResult, at time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	account := t.accountLocked(userID)
+	if _, applied := account.applied[receiptID]; applied {
+		return false
+	}
+	t.recordLocked(userID, account, receiptID, result.Points-tierPointsOf(result.Breakdown), accruedAt, at)
+	account.applied[receiptID] = result
+	return true
+}
+
+// Restore
+// @Description    Count a receipt already processed for the tier of a user (e.g. rebuilding the tracker from the stored receipts),
+//                 without applying the tier again nor emitting events.
+// @Param          userID: string, receiptID: string, accruedAt: time.Time, result: PointsResult (stored, with its tier entry)
+// @Return         none
+func (t *TierTracker) Restore(userID string, receiptID string, accruedAt time.Time, result PointsResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	account := t.accountLocked(userID)
+	if _, applied := account.applied[receiptID]; applied {
+		return
+	}
+	base := result.Points - tierPointsOf(result.Breakdown)
+	account.accruals = append(account.accruals, tierAccrual{receiptID: receiptID, points: base, accruedAt: accruedAt})
+	account.tier = t.policy.tierOf(account.rollingPoints(accruedAt.AddDate(0, 0, 1), t.policy.WindowMonths)).Name
+	account.applied[receiptID] = result
+}
+
+// Reverse
+// @Description    Count only the remaining base points of a reversed receipt for the tier of a user (see ReversedReceiptPoints).
+//                 The results already applied are not changed and no event is emitted: a lower tier applies from the next receipt.
+// @Param          userID: string, receiptID: string, base: int64 (remaining base points, 0 for a full reversal)
+// @Return         true if the receipt counted for the tier of the user: bool
+func (t *TierTracker) Reverse(userID string, receiptID string, base int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	account, exists := t.accounts[userID]
+	if !exists {
+		return false
+	}
+	for i := range account.accruals {
+		if account.accruals[i].receiptID == receiptID {
+			account.accruals[i].points = min(account.accruals[i].points, max(base, 0))
+			return true
+		}
+	}
+	return false
+}
+
+// Forget
+// @Description    Stop counting a deleted receipt for the tier of a user, as if it was never recorded. No event is emitted.
+// @Param          userID: string, receiptID: string
+// @Return         true if the receipt was recorded: bool
+func (t *TierTracker) Forget(userID string, receiptID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	account, exists := t.accounts[userID]
+	if !exists {
+		return false
+	}
+	_, recorded := account.applied[receiptID]
+	delete(account.applied, receiptID)
+	for i, accrual := range account.accruals {
+		if accrual.receiptID == receiptID {
+			account.accruals = append(account.accruals[:i], account.accruals[i+1:]...)
+			break
+		}
+	}
+	return recorded
+}
+
+// Status
+// @Description    Get the current tier of a user, from the base points of their receipts purchased in the rolling window ending at now.
+// @Param          userID: string, now: time.Time
+// @Return         status: TierStatus
+func (t *TierTracker) Status(userID string, now time.Time) TierStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var rolling int64
+	if account, exists := t.accounts[userID]; exists {
+		rolling = account.rollingPoints(now, t.policy.WindowMonths)
+	}
+	status := TierStatus{UserID: userID, Tier: t.policy.tierOf(rolling).Name, RollingPoints: rolling}
+	for _, tier := range t.policy.Tiers {
+		if tier.MinPoints > rolling {
+			status.NextTier = tier.Name
+			status.PointsToNextTier = tier.MinPoints - rolling
+			break
+		}
+	}
+	return status
+}
+
+// EraseUser
+// @Description    Remove the tier history of a user (e.g. GDPR erasure request).
+// @Param          userID: string
+// @Return         none
+func (t *TierTracker) EraseUser(userID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.accounts, userID)
+}
+
+// accountLocked
+// @Description    Get the tier account of a user, creating it if needed. The caller must hold t.mu.
+// @Param          userID: string
+// @Return         account: *tierAccount
+func (t *TierTracker) accountLocked(userID string) *tierAccount {
+	account, exists := t.accounts[userID]
+	if !exists {
+		account = &tierAccount{applied: map[string]PointsResult{}}
+		t.accounts[userID] = account
+	}
+	return account
+}
+
+// recordLocked
+// @Description    Count the base points of a receipt for the tier of a user, and emit an event if the tier changes. The caller must hold t.mu.
+// @Param          userID: string, account: *tierAccount, receiptID: string, points: int64, accruedAt: time.Time, at: time.Time
+// @Return         none
+func (t *TierTracker) recordLocked(userID string, account *tierAccount, receiptID string, points int64, accruedAt time.Time, at time.Time) {
+	account.accruals = append(account.accruals, tierAccrual{receiptID: receiptID, points: points, accruedAt: accruedAt})
+
+	// tier of the user right after the receipt, the receipt included
+	rolling := account.rollingPoints(accruedAt.AddDate(0, 0, 1), t.policy.WindowMonths)
+	tier := t.policy.tierOf(rolling).Name
+	if tier != account.tier {
+		if t.sink != nil {
+			t.sink.Emit(TierChangeEvent{UserID: userID, From: account.tier, To: tier, RollingPoints: rolling, ReceiptID: receiptID, At: at})
+		}
+		account.tier = tier
+	}
+}
+
+// rollingPoints
+// @Description    Sum the base points of the receipts accrued in the window of windowMonths months before end (end excluded).
+// @Param          end: time.Time, windowMonths: int
+// @Return         rolling points: int64
+func (a *tierAccount) rollingPoints(end time.Time, windowMonths int) int64 {
+	start := end.AddDate(0, -windowMonths, 0)
+	var rolling int64
+	for _, accrual := range a.accruals {
+		if !accrual.accruedAt.Before(start) && accrual.accruedAt.Before(end) {
+			rolling += accrual.points
+		}
+	}
+	return rolling
+}
+
+// tierOf
+// @Description    Get the highest tier reached with the rolling points.
+// @Param          rolling: int64
+// @Return         tier: Tier
+func (p TierPolicy) tierOf(rolling int64) Tier {
+	tier := p.Tiers[0]
+	for _, candidate := range p.Tiers[1:] {
+		if rolling >= candidate.MinPoints {
+			tier = candidate
+		}
+	}
+	return tier
+}
+
+// describe
+// @Description    Describe the tier applied to a receipt, for the points breakdown.
+// @Param          rolling: int64, windowMonths: int
+// @Return         reason: string
+func (tier Tier) describe(rolling int64, windowMonths int) string {
+	reason := fmt.Sprintf("%v tier (%d points in the last %d months): x%v multiplier", tier.Name, rolling, windowMonths, tier.Multiplier)
+	if tier.BonusPoints > 0 {
+		reason += fmt.Sprintf(" and %d bonus points", tier.BonusPoints)
+	}
+	return reason
+}
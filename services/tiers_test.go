@@ -0,0 +1,237 @@
+// services/tiers_test.go
+// Tests for the loyalty tiers.
+
+package services
+
+import (
+	"testing"
+	"time"
+
+	"receipt-processor/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// baseResult is the result of the rules for a receipt worth points.
+func baseResult(points int64) PointsResult {
+	return PointsResult{
+		Points:      points,
+		Breakdown:   []models.PointsBreakdownEntry{{RuleID: "retailer-name", Reason: "test", Points: points}},
+		RuleVersion: "v1",
+	}
+}
+
+// Test on applying the tiers to the receipts of a user
+// expected: the tier comes from the base points of the previous receipts in the window, and multiplies the base points
+func TestTierTracker_Apply(t *testing.T) {
+	var events []TierChangeEvent
+	tracker := NewTierTracker(DefaultTierPolicy(), TierEventSinkFunc(func(event TierChangeEvent) { events = append(events, event) }))
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// 1. Bronze: the tier is recorded in the breakdown, without extra points
+	result, err := tracker.Apply("alice", "receipt-1", date(2024, 1, 10), baseResult(500), at)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(500), result.Points)
+	if assert.Len(t, result.Breakdown, 2) {
+		assert.Equal(t, models.PointsBreakdownEntry{
+			RuleID: TierRuleID, Reason: "Bronze tier (0 points in the last 12 months): x1 multiplier", Points: 0, Source: "userId",
+		}, result.Breakdown[1])
+	}
+
+	// 2. Silver from the next receipt, with 500 rolling points: 101 base points x1.25 = 126.25, rounded down
+	result, err = tracker.Apply("alice", "receipt-2", date(2024, 2, 10), baseResult(101), at)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(126), result.Points)
+	assert.Equal(t, "Silver tier (500 points in the last 12 months): x1.25 multiplier", result.Breakdown[1].Reason)
+	assert.Equal(t, int64(25), result.Breakdown[1].Points)
+	assert.Equal(t, "v1", result.RuleVersion)
+
+	// 3. applying a receipt again returns the first result, even after the tier changed
+	again, err := tracker.Apply("alice", "receipt-1", date(2024, 1, 10), baseResult(500), at)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(500), again.Points)
+
+	// 4. the receipts out of the rolling window do not count: back to Bronze 12 months later
+	result, err = tracker.Apply("alice", "receipt-3", date(2025, 2, 11), baseResult(10), at)
+	assert.NoError(t, err)
+	assert.Equal(t, "Bronze tier (0 points in the last 12 months): x1 multiplier", result.Breakdown[1].Reason)
+
+	// the tier changes are emitted in order, with the receipt that caused them
+	assert.Equal(t, []TierChangeEvent{
+		{UserID: "alice", From: "", To: "Silver", RollingPoints: 500, ReceiptID: "receipt-1", At: at},
+		{UserID: "alice", From: "Silver", To: "Bronze", RollingPoints: 10, ReceiptID: "receipt-3", At: at},
+	}, events)
+
+	_, err = tracker.Apply("", "receipt-4", date(2024, 1, 10), baseResult(1), at)
+	assert.Error(t, err)
+}
+
+// Test on the determinism of the tier evaluation
+// expected: the same history gives the same tier, whatever the order of the receipts purchased the same day
+func TestTierTracker_Deterministic(t *testing.T) {
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, order := range [][]string{{"receipt-1", "receipt-2"}, {"receipt-2", "receipt-1"}} {
+		tracker := NewTierTracker(DefaultTierPolicy(), nil)
+		tracker.Apply("alice", "receipt-0", date(2024, 1, 1), baseResult(1600), at)
+		results := map[string]PointsResult{}
+		for _, receiptID := range order {
+			results[receiptID], _ = tracker.Apply("alice", receiptID, date(2024, 3, 1), baseResult(100), at)
+		}
+		assert.Equal(t, int64(150), results["receipt-1"].Points)
+		assert.Equal(t, int64(150), results["receipt-2"].Points)
+	}
+}
+
+// Test on restoring the tiers and on the status of a user
+// expected: the base points of the stored receipts count again, the tier entry excluded
+func TestTierTracker_RestoreStatus(t *testing.T) {
+	policy := TierPolicy{
+		Tiers: []Tier{
+			{Name: "Bronze", MinPoints: 0, Multiplier: priceMultiplier{num: 1, den: 1}},
+			{Name: "Gold", MinPoints: 100, Multiplier: priceMultiplier{num: 2, den: 1}, BonusPoints: 5},
+		},
+		WindowMonths: 6,
+	}
+	var events []TierChangeEvent
+	tracker := NewTierTracker(policy, TierEventSinkFunc(func(event TierChangeEvent) { events = append(events, event) }))
+	stored := baseResult(80)
+	stored.Points = 165
+	stored.Breakdown = append(stored.Breakdown, models.PointsBreakdownEntry{RuleID: TierRuleID, Points: 85})
+	tracker.Restore("alice", "receipt-1", date(2024, 1, 1), stored)
+	tracker.Restore("alice", "receipt-1", date(2024, 1, 1), stored)
+	assert.Empty(t, events)
+
+	assert.Equal(t, TierStatus{UserID: "alice", Tier: "Bronze", RollingPoints: 80, NextTier: "Gold", PointsToNextTier: 20}, tracker.Status("alice", date(2024, 3, 1)))
+	assert.Equal(t, TierStatus{UserID: "alice", Tier: "Bronze", RollingPoints: 0, NextTier: "Gold", PointsToNextTier: 100}, tracker.Status("alice", date(2024, 8, 1)))
+
+	result, _ := tracker.Apply("alice", "receipt-2", date(2024, 2, 1), baseResult(30), time.Now())
+	assert.Equal(t, int64(30), result.Points)
+	result, _ = tracker.Apply("alice", "receipt-3", date(2024, 3, 1), baseResult(10), time.Now())
+	assert.Equal(t, int64(25), result.Points)
+	assert.Equal(t, "Gold tier (110 points in the last 6 months): x2 multiplier and 5 bonus points", result.Breakdown[1].Reason)
+	assert.Equal(t, []TierChangeEvent{{UserID: "alice", From: "Bronze", To: "Gold", RollingPoints: 110, ReceiptID: "receipt-2", At: events[0].At}}, events)
+	assert.Equal(t, TierStatus{UserID: "alice", Tier: "Gold", RollingPoints: 120}, tracker.Status("alice", date(2024, 3, 2)))
+
+	tracker.EraseUser("alice")
+	assert.Equal(t, "Bronze", tracker.Status("alice", date(2024, 3, 2)).Tier)
+}
+
+// Test on ParseTiers function and TierPolicy validation
+// expected: the tiers are parsed with exact multipliers, inconsistent tiers are refused
+func TestParseTiers(t *testing.T) {
+	tiers, err := ParseTiers("Bronze:0:1, Silver:500:1.25,Gold:1500:1.5:10")
+	assert.NoError(t, err)
+	assert.Equal(t, []Tier{
+		{Name: "Bronze", MinPoints: 0, Multiplier: priceMultiplier{num: 1, den: 1}},
+		{Name: "Silver", MinPoints: 500, Multiplier: priceMultiplier{num: 125, den: 100}},
+		{Name: "Gold", MinPoints: 1500, Multiplier: priceMultiplier{num: 15, den: 10}, BonusPoints: 10},
+	}, tiers)
+	assert.NoError(t, TierPolicy{Tiers: tiers, WindowMonths: 12}.Validate())
+	assert.NoError(t, DefaultTierPolicy().Validate())
+
+	for _, spec := range []string{"Bronze", "Bronze:zero:1", "Bronze:0:x", "Bronze:0:-1", "Bronze:0:1:x", "Bronze:0:1:2:3"} {
+		_, err := ParseTiers(spec)
+		assert.Error(t, err, spec)
+	}
+
+	for _, spec := range []string{"Bronze:10:1", "Bronze:0:1,Silver:0:2", "Bronze:0:1,Bronze:10:2", ":0:1", "Bronze:0:1:-5"} {
+		tiers, err := ParseTiers(spec)
+		assert.NoError(t, err, spec)
+		assert.Error(t, TierPolicy{Tiers: tiers, WindowMonths: 12}.Validate(), spec)
+	}
+	assert.Error(t, TierPolicy{Tiers: tiers, WindowMonths: 0}.Validate())
+	assert.Error(t, TierPolicy{WindowMonths: 12}.Validate())
+}
+
+// Test on the multiplier helpers
+// expected: exact multiplication rounded down, decimal formatting
+func TestPriceMultiplier_ApplyFloor(t *testing.T) {
+	points, err := priceMultiplier{num: 125, den: 100}.applyFloor(101)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(126), points)
+	_, err = priceMultiplier{num: 2, den: 1}.applyFloor(1 << 62)
+	assert.Error(t, err)
+
+	assert.Equal(t, "1.25", priceMultiplier{num: 125, den: 100}.String())
+	assert.Equal(t, "1.5", priceMultiplier{num: 15, den: 10}.String())
+	assert.Equal(t, "2", priceMultiplier{num: 20, den: 10}.String())
+	assert.Equal(t, "0.05", priceMultiplier{num: 5, den: 100}.String())
+}
+
+// Test on reversing a receipt counted for the tier of a user
+// expected: only the remaining base points count for the next evaluations
+func TestTierTracker_Reverse(t *testing.T) {
+	tracker := NewTierTracker(DefaultTierPolicy(), nil)
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	_, err := tracker.Apply("alice", "receipt-1", date(2024, 1, 10), baseResult(600), at)
+	assert.NoError(t, err)
+	assert.Equal(t, "Silver", tracker.Status("alice", at).Tier)
+
+	assert.True(t, tracker.Reverse("alice", "receipt-1", 200))
+	status := tracker.Status("alice", at)
+	assert.Equal(t, "Bronze", status.Tier)
+	assert.Equal(t, int64(200), status.RollingPoints)
+
+	// a reversal never adds points
+	assert.True(t, tracker.Reverse("alice", "receipt-1", 400))
+	assert.Equal(t, int64(200), tracker.Status("alice", at).RollingPoints)
+
+	assert.False(t, tracker.Reverse("alice", "receipt-2", 0))
+	assert.False(t, tracker.Reverse("bob", "receipt-1", 0))
+}
+
+// Test on quoting a receipt before recording it
+// expected: the quote does not count the receipt, recording it does (once), with the quoted result
+func TestTierTracker_QuoteRecord(t *testing.T) {
+	var events []TierChangeEvent
+	tracker := NewTierTracker(DefaultTierPolicy(), TierEventSinkFunc(func(event TierChangeEvent) { events = append(events, event) }))
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	quote, err := tracker.Quote("alice", "receipt-1", date(2024, 1, 10), baseResult(600))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(600), quote.Points)
+	assert.Equal(t, int64(0), tracker.Status("alice", at).RollingPoints)
+	assert.Empty(t, events)
+
+	// a quote that is never recorded (e.g. the receipt could not be stored) leaves no trace
+	again, err := tracker.Quote("alice", "receipt-1", date(2024, 1, 10), baseResult(600))
+	assert.NoError(t, err)
+	assert.Equal(t, quote, again)
+
+	assert.True(t, tracker.Record("alice", "receipt-1", date(2024, 1, 10), quote, at))
+	assert.False(t, tracker.Record("alice", "receipt-1", date(2024, 1, 10), quote, at))
+	assert.Equal(t, int64(600), tracker.Status("alice", at).RollingPoints)
+	assert.Len(t, events, 1)
+
+	// the next receipt is quoted at Silver, a recorded receipt is quoted with its recorded result
+	quote, err = tracker.Quote("alice", "receipt-2", date(2024, 2, 10), baseResult(100))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(125), quote.Points)
+	recorded, err := tracker.Quote("alice", "receipt-1", date(2024, 1, 10), baseResult(1))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(600), recorded.Points)
+
+	_, err = tracker.Quote("", "receipt-3", date(2024, 1, 10), baseResult(1))
+	assert.Error(t, err)
+}
+
+// Test on forgetting a deleted receipt
+// expected: the receipt no longer counts for the tier, and can be recorded again
+func TestTierTracker_Forget(t *testing.T) {
+	tracker := NewTierTracker(DefaultTierPolicy(), nil)
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	_, err := tracker.Apply("alice", "receipt-1", date(2024, 1, 10), baseResult(600), at)
+	assert.NoError(t, err)
+	_, err = tracker.Apply("alice", "receipt-2", date(2024, 1, 11), baseResult(100), at)
+	assert.NoError(t, err)
+
+	assert.True(t, tracker.Forget("alice", "receipt-1"))
+	assert.Equal(t, int64(100), tracker.Status("alice", at).RollingPoints)
+	assert.False(t, tracker.Forget("alice", "receipt-1"))
+	assert.False(t, tracker.Forget("bob", "receipt-1"))
+
+	result, err := tracker.Apply("alice", "receipt-1", date(2024, 1, 10), baseResult(600), at)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(600), result.Points)
+	assert.Equal(t, int64(700), tracker.Status("alice", at).RollingPoints)
+}
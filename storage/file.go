@@ -0,0 +1,581 @@
+// storage/file.go
+// Durable file-backed storage: append-only log with fsync, compacted into snapshots, replayed on startup.
+
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*
+	--- File layout ---
+	<dir>/receipts.snapshot   JSON object with all the receipts, tombstones and redemptions at the time of the last compaction (written atomically).
+	<dir>/receipts.log        Changes since the snapshot, one record per change:
+	                              [payload length: uint32 big endian][CRC-32 of the payload: uint32 big endian][payload: JSON logRecord]
+
+	Records are idempotent (they carry the full state of a receipt or a redemption), so replaying the log over a snapshot
+	that already includes some of its records gives the same result.
+	A crash in the middle of an append leaves a torn record at the end of the log: it is detected
+	(short read or checksum mismatch) and truncated on startup, the previous records are kept.
+
+	Erasing receipts or redemptions compacts the log right away, so the erased data does not stay on disk in the old records.
+*/
+
+const (
+	snapshotFileName = "receipts.snapshot"
+	logFileName      = "receipts.log"
+
+	recordHeaderSize = 8
+	maxRecordSize    = 16 << 20 // larger lengths can only come from a corrupted header
+
+	// DefaultCompactEvery is the default number of log records after which the log is compacted.
+	DefaultCompactEvery = 1000
+)
+
+// log record operations
+const (
+	opPut    = "put"
+	opDelete = "delete"
+	opErase  = "erase"
+
+	opRedemption       = "redemption"        // Redemption is the new state of the redemption
+	opEraseRedemptions = "erase-redemptions" // ID is the user whose redemptions are erased
+)
+
+// logRecord is a single change of the storage, as written in the log.
+type logRecord struct {
+	Op         string       `json:"op"`
+	ID         string       `json:"id"`
+	Data       *ReceiptData `json:"data,omitempty"`
+	Tombstone  *Tombstone   `json:"tombstone,omitempty"`
+	Redemption *Redemption  `json:"redemption,omitempty"`
+}
+
+// fileSnapshot is the content of the snapshot.
+type fileSnapshot struct {
+	Receipts    []ReceiptData `json:"receipts"`
+	Tombstones  []Tombstone   `json:"tombstones"`
+	Redemptions []Redemption  `json:"redemptions,omitempty"`
+}
+
+// FileStoreOptions configures a FileStore.
+type FileStoreOptions struct {
+	// CompactEvery is the number of log records after which the log is compacted into a new snapshot.
+	// Defaults to DefaultCompactEvery if 0, compaction only happens on Compact/Close if negative.
+	CompactEvery int
+}
+
+// logFile is the append-only log, an *os.File (replaced in the tests to simulate failed writes).
+type logFile interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+	Sync() error
+	Stat() (os.FileInfo, error)
+	Close() error
+}
+
+// FileStore is the durable ReceiptStore, every change is fsynced to an append-only log before being acknowledged.
+// Reads are served from memory.
+type FileStore struct {
+	mu           sync.Mutex // serializes the writes to the files
+	dir          string
+	log          logFile
+	records      int // records in the log since the last compaction
+	compactEvery int
+	memory       *MemoryStore
+	failed       error // set when a failed write could not be rolled back, every later write is refused
+}
+
+// OpenFileStore
+// @Description    Open (or create) the file storage in the given directory: load the snapshot,
+//                 replay the log (truncating a torn record at its end) and open the log for appending.
+// @Param          dir: string, options: FileStoreOptions
+// @Return         pointer to the storage: *FileStore, error: error
+func OpenFileStore(dir string, options FileStoreOptions) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("[OpenFileStore] Failed to create the storage directory %v: %w", dir, err)
+	}
+
+	store := &FileStore{
+		dir:          dir,
+		compactEvery: options.CompactEvery,
+		memory:       NewMemoryStore(),
+	}
+	if store.compactEvery == 0 {
+		store.compactEvery = DefaultCompactEvery
+	}
+
+	if err := store.loadSnapshot(); err != nil {
+		return nil, err
+	}
+
+	log, err := os.OpenFile(filepath.Join(dir, logFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("[OpenFileStore] Failed to open the log: %w", err)
+	}
+	if err := store.replayLog(log); err != nil {
+		log.Close()
+		return nil, err
+	}
+	store.log = log
+	return store, nil
+}
+
+// loadSnapshot
+// @Description    Load the receipts of the snapshot, if any, into memory.
+// @Param          none
+// @Return         error: error
+func (s *FileStore) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("[loadSnapshot] Failed to read the snapshot: %w", err)
+	}
+
+	var snapshot fileSnapshot
+	if len(data) > 0 && data[0] == '[' {
+		// snapshots written before the tombstones are a plain array of receipts
+		err = json.Unmarshal(data, &snapshot.Receipts)
+	} else {
+		err = json.Unmarshal(data, &snapshot)
+	}
+	if err != nil {
+		// the snapshot is written atomically, a corrupted snapshot is not a crash we can recover from
+		return fmt.Errorf("[loadSnapshot] Corrupted snapshot: %w", err)
+	}
+	for _, receipt := range snapshot.Receipts {
+		s.memory.SaveReceipt(receipt.ID, receipt)
+	}
+	for _, tombstone := range snapshot.Tombstones {
+		s.memory.restoreTombstone(tombstone)
+	}
+	for _, redemption := range snapshot.Redemptions {
+		s.memory.SaveRedemption(redemption)
+	}
+	return nil
+}
+
+// replayLog
+// @Description    Apply the records of the log to memory, truncate the log after the last valid record,
+//                 and position the file at its end for appending.
+// @Param          log: *os.File
+// @Return         error: error
+func (s *FileStore) replayLog(log *os.File) error {
+	reader := bufio.NewReader(log)
+	var offset int64 = 0
+
+	for {
+		record, size, err := readRecord(reader)
+		if err != nil {
+			// io.EOF is a clean end of the log, anything else is a torn or corrupted tail
+			break
+		}
+		s.apply(record)
+		s.records++
+		offset += size
+	}
+
+	if err := log.Truncate(offset); err != nil {
+		return fmt.Errorf("[replayLog] Failed to truncate the log: %w", err)
+	}
+	if _, err := log.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("[replayLog] Failed to seek the log: %w", err)
+	}
+	if err := log.Sync(); err != nil {
+		return fmt.Errorf("[replayLog] Failed to sync the log: %w", err)
+	}
+	return nil
+}
+
+// readRecord
+// @Description    Read and check a single record of the log.
+// @Param          reader: io.Reader
+// @Return         record: logRecord, size read: int64, error: error (io.EOF at the clean end of the log)
+func readRecord(reader io.Reader) (logRecord, int64, error) {
+	var record logRecord
+
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return record, 0, io.EOF
+		}
+		return record, 0, fmt.Errorf("[readRecord] Torn record header: %w", err)
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	if length > maxRecordSize {
+		return record, 0, fmt.Errorf("[readRecord] Invalid record length %d", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return record, 0, fmt.Errorf("[readRecord] Torn record payload: %w", err)
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return record, 0, fmt.Errorf("[readRecord] Record checksum mismatch")
+	}
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return record, 0, fmt.Errorf("[readRecord] Invalid record: %w", err)
+	}
+	return record, int64(recordHeaderSize) + int64(length), nil
+}
+
+// apply
+// @Description    Apply a record to memory.
+// @Param          record: logRecord
+// @Return         none
+func (s *FileStore) apply(record logRecord) {
+	switch record.Op {
+	case opPut:
+		if record.Data != nil {
+			s.memory.SaveReceipt(record.ID, *record.Data)
+		}
+	case opDelete:
+		s.memory.DeleteReceipt(record.ID)
+	case opErase:
+		if record.Tombstone != nil {
+			s.memory.restoreTombstone(*record.Tombstone)
+		}
+	case opRedemption:
+		if record.Redemption != nil {
+			s.memory.SaveRedemption(*record.Redemption)
+		}
+	case opEraseRedemptions:
+		s.memory.EraseRedemptions(record.ID)
+	}
+}
+
+// appendLocked
+// @Description    Append a record to the log and fsync it, then apply it to memory and compact if needed.
+//                 The caller must hold s.mu.
+// @Param          record: logRecord
+// @Return         error: error (the record is not applied)
+func (s *FileStore) appendLocked(record logRecord) error {
+	if s.log == nil {
+		return fmt.Errorf("[appendLocked] The storage is closed")
+	}
+	if s.failed != nil {
+		return fmt.Errorf("[appendLocked] The storage failed, reopen it: %w", s.failed)
+	}
+
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("[appendLocked] Failed to encode the record: %w", err)
+	}
+	buffer := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buffer[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buffer[4:8], crc32.ChecksumIEEE(payload))
+	buffer = append(buffer, payload...)
+
+	// a failed write may leave a torn record at the end of the log: it is removed, otherwise the replay would stop there
+	// and drop the records acknowledged after it
+	offset, err := s.log.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("[appendLocked] Failed to get the log offset: %w", err)
+	}
+	if _, err := s.log.Write(buffer); err != nil {
+		return s.rollbackLocked(offset, fmt.Errorf("[appendLocked] Failed to write the log: %w", err))
+	}
+	if err := s.log.Sync(); err != nil {
+		return s.rollbackLocked(offset, fmt.Errorf("[appendLocked] Failed to sync the log: %w", err))
+	}
+
+	s.apply(record)
+	s.records++
+	if s.compactEvery > 0 && s.records >= s.compactEvery {
+		// the record is durable at this point, a failed compaction is retried on the next append
+		s.compactLocked()
+	}
+	return nil
+}
+
+// rollbackLocked
+// @Description    Truncate the log back to offset after a failed write. If the log cannot be truncated, the storage is marked
+//                 as failed so that no write is acknowledged after the torn record.
+// @Param          offset: int64 (end of the log before the write), cause: error (the failed write)
+// @Return         error: error (cause, wrapped with the rollback error if any)
+func (s *FileStore) rollbackLocked(offset int64, cause error) error {
+	err := s.log.Truncate(offset)
+	if err == nil {
+		_, err = s.log.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		s.failed = fmt.Errorf("%w (rollback failed: %v)", cause, err)
+		return s.failed
+	}
+	return cause
+}
+
+// SaveReceipt
+// @Description    Save a receipt and its calculated points, durably
+// @Param          id: string, data: ReceiptData (its ID is set to id)
+// @Return         error: error
+func (s *FileStore) SaveReceipt(id string, data ReceiptData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data.ID = id
+	return s.appendLocked(logRecord{Op: opPut, ID: id, Data: &data})
+}
+
+// GetReceiptData
+// @Description    Retrieve the receipt data based on the receipt ID
+// @Param          id: string
+// @Return         receipt data: ReceiptData, found: bool, error: error
+func (s *FileStore) GetReceiptData(id string) (ReceiptData, bool, error) {
+	return s.memory.GetReceiptData(id)
+}
+
+// ListReceipts
+// @Description    Retrieve all the receipt data, ordered by receipt ID
+// @Param          none
+// @Return         receipt data: []ReceiptData, error: error
+func (s *FileStore) ListReceipts() ([]ReceiptData, error) {
+	return s.memory.ListReceipts()
+}
+
+// FindReceipts
+// @Description    Retrieve the receipt data selected by the filter, ordered by receipt ID
+// @Param          filter: ReceiptFilter
+// @Return         receipt data: []ReceiptData, error: error
+func (s *FileStore) FindReceipts(filter ReceiptFilter) ([]ReceiptData, error) {
+	return s.memory.FindReceipts(filter)
+}
+
+// QueryReceipts
+// @Description    Retrieve a page of the receipt data selected by the filter, in the query order
+// @Param          query: ReceiptQuery
+// @Return         page of receipts: ReceiptPage, error: error
+func (s *FileStore) QueryReceipts(query ReceiptQuery) (ReceiptPage, error) {
+	return s.memory.QueryReceipts(query)
+}
+
+// DeleteReceipt
+// @Description    Remove a receipt based on the receipt ID, durably
+// @Param          id: string
+// @Return         found: bool, error: error
+func (s *FileStore) DeleteReceipt(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found, _ := s.memory.GetReceiptData(id); !found {
+		return false, nil
+	}
+	if err := s.appendLocked(logRecord{Op: opDelete, ID: id}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// EraseReceipts
+// @Description    Remove the receipts durably, record a tombstone for each of them,
+//                 then compact the log so the erased data is no longer on disk.
+// @Param          ids: []string (unknown IDs are skipped), reason: string, at: time.Time
+// @Return         tombstones of the erased receipts: []Tombstone, error: error
+func (s *FileStore) EraseReceipts(ids []string, reason string, at time.Time) ([]Tombstone, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tombstones := []Tombstone{}
+	for _, id := range ids {
+		if _, found, _ := s.memory.GetReceiptData(id); !found {
+			continue
+		}
+		tombstone := Tombstone{ID: id, Reason: reason, DeletedAt: at.UTC()}
+		if err := s.appendLocked(logRecord{Op: opErase, ID: id, Tombstone: &tombstone}); err != nil {
+			return tombstones, err
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+
+	if len(tombstones) > 0 {
+		if err := s.compactLocked(); err != nil {
+			return tombstones, fmt.Errorf("[EraseReceipts] The receipts are erased but still on disk: %w", err)
+		}
+	}
+	return tombstones, nil
+}
+
+// GetTombstone
+// @Description    Retrieve the tombstone of an erased receipt
+// @Param          id: string
+// @Return         tombstone: Tombstone, found: bool, error: error
+func (s *FileStore) GetTombstone(id string) (Tombstone, bool, error) {
+	return s.memory.GetTombstone(id)
+}
+
+// ListTombstones
+// @Description    Retrieve all the tombstones, ordered by erasure time and receipt ID
+// @Param          none
+// @Return         tombstones: []Tombstone, error: error
+func (s *FileStore) ListTombstones() ([]Tombstone, error) {
+	return s.memory.ListTombstones()
+}
+
+// SaveRedemption
+// @Description    Save a redemption durably, replacing its previous state
+// @Param          redemption: Redemption
+// @Return         error: error
+func (s *FileStore) SaveRedemption(redemption Redemption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.appendLocked(logRecord{Op: opRedemption, ID: redemption.ID, Redemption: &redemption})
+}
+
+// ListRedemptions
+// @Description    Retrieve all the redemptions, ordered by creation time and redemption ID
+// @Param          none
+// @Return         redemptions: []Redemption, error: error
+func (s *FileStore) ListRedemptions() ([]Redemption, error) {
+	return s.memory.ListRedemptions()
+}
+
+// EraseRedemptions
+// @Description    Remove the redemptions of a user durably, then compact the log so the erased data is no longer on disk.
+// @Param          userID: string
+// @Return         error: error
+func (s *FileStore) EraseRedemptions(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	redemptions, _ := s.memory.ListRedemptions()
+	erased := false
+	for _, redemption := range redemptions {
+		erased = erased || redemption.UserID == userID
+	}
+	if !erased {
+		return nil
+	}
+	if err := s.appendLocked(logRecord{Op: opEraseRedemptions, ID: userID}); err != nil {
+		return err
+	}
+	if err := s.compactLocked(); err != nil {
+		return fmt.Errorf("[EraseRedemptions] The redemptions are erased but still on disk: %w", err)
+	}
+	return nil
+}
+
+// Compact
+// @Description    Write all the receipts to a new snapshot and empty the log.
+// @Param          none
+// @Return         error: error
+func (s *FileStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return fmt.Errorf("[Compact] The storage is closed")
+	}
+	return s.compactLocked()
+}
+
+// compactLocked
+// @Description    Compact implementation, the caller must hold s.mu.
+//                 The snapshot is written to a temporary file, fsynced and renamed over the previous one,
+//                 only then the log is truncated: a crash at any point keeps a consistent snapshot + log.
+// @Param          none
+// @Return         error: error
+func (s *FileStore) compactLocked() error {
+	receipts, _ := s.memory.ListReceipts()
+	tombstones, _ := s.memory.ListTombstones()
+	redemptions, _ := s.memory.ListRedemptions()
+	data, err := json.Marshal(fileSnapshot{Receipts: receipts, Tombstones: tombstones, Redemptions: redemptions})
+	if err != nil {
+		return fmt.Errorf("[Compact] Failed to encode the snapshot: %w", err)
+	}
+
+	snapshotPath := filepath.Join(s.dir, snapshotFileName)
+	temporaryPath := snapshotPath + ".tmp"
+	if err := writeFileSync(temporaryPath, data); err != nil {
+		return fmt.Errorf("[Compact] Failed to write the snapshot: %w", err)
+	}
+	if err := os.Rename(temporaryPath, snapshotPath); err != nil {
+		return fmt.Errorf("[Compact] Failed to replace the snapshot: %w", err)
+	}
+	if err := syncDir(s.dir); err != nil {
+		return fmt.Errorf("[Compact] Failed to sync the storage directory: %w", err)
+	}
+
+	if err := s.log.Truncate(0); err != nil {
+		return fmt.Errorf("[Compact] Failed to truncate the log: %w", err)
+	}
+	if _, err := s.log.Seek(0, io.SeekStart); err != nil {
+		s.failed = fmt.Errorf("[Compact] Failed to seek the log: %w", err)
+		return s.failed
+	}
+	// the log is empty: a torn record left by a failed write is gone
+	s.failed = nil
+	if err := s.log.Sync(); err != nil {
+		return fmt.Errorf("[Compact] Failed to sync the log: %w", err)
+	}
+	s.records = 0
+	return nil
+}
+
+// Close
+// @Description    Compact the log and close the files. The storage cannot be used afterwards.
+// @Param          none
+// @Return         error: error
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return nil
+	}
+
+	compactErr := s.compactLocked()
+	closeErr := s.log.Close()
+	s.log = nil
+	if compactErr != nil {
+		return compactErr
+	}
+	return closeErr
+}
+
+
+////////////////////////
+//    FILE HELPERS    //
+////////////////////////
+
+// writeFileSync
+// @Description    Write a file and fsync it before closing.
+// @Param          path: string, data: []byte
+// @Return         error: error
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir
+// @Description    Fsync a directory, making the renames in it durable.
+// @Param          dir: string
+// @Return         error: error
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
@@ -0,0 +1,275 @@
+// storage/file_test.go
+// Tests for the durable file storage, including recovery from crashes in the middle of a write.
+
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test on the file storage
+func TestFileStore(t *testing.T) {
+	store, err := OpenFileStore(t.TempDir(), FileStoreOptions{})
+	assert.NoError(t, err)
+	testReceiptStore(t, store)
+	assert.NoError(t, store.Close())
+
+	store, err = OpenFileStore(t.TempDir(), FileStoreOptions{})
+	assert.NoError(t, err)
+	testFindReceipts(t, store)
+	assert.NoError(t, store.Close())
+
+	store, err = OpenFileStore(t.TempDir(), FileStoreOptions{})
+	assert.NoError(t, err)
+	testQueryReceipts(t, store)
+	assert.NoError(t, store.Close())
+
+	store, err = OpenFileStore(t.TempDir(), FileStoreOptions{})
+	assert.NoError(t, err)
+	testEraseReceipts(t, store)
+	assert.NoError(t, store.Close())
+
+	store, err = OpenFileStore(t.TempDir(), FileStoreOptions{})
+	assert.NoError(t, err)
+	testRedemptions(t, store)
+	assert.NoError(t, store.Close())
+}
+
+// Test on restarting the file storage
+// expected: the receipts saved and deleted, and the redemptions saved before the restart are replayed from the log
+func TestFileStore_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir, FileStoreOptions{CompactEvery: -1})
+	assert.NoError(t, err)
+	assert.NoError(t, store.SaveReceipt("a", testReceiptData("Target", 6)))
+	assert.NoError(t, store.SaveReceipt("b", testReceiptData("Walmart", 7)))
+	_, err = store.DeleteReceipt("a")
+	assert.NoError(t, err)
+	assert.NoError(t, store.SaveRedemption(Redemption{ID: "x", UserID: "alice", Points: 3, Status: "confirmed", Lots: []RedemptionLot{{ReceiptID: "b", Points: 3}}}))
+	// simulate a crash: the files are not compacted nor closed properly
+	assert.NoError(t, store.log.Close())
+
+	store, err = OpenFileStore(dir, FileStoreOptions{CompactEvery: -1})
+	assert.NoError(t, err)
+	defer store.Close()
+	_, found, _ := store.GetReceiptData("a")
+	assert.False(t, found)
+	data, found, _ := store.GetReceiptData("b")
+	assert.True(t, found)
+	assert.Equal(t, int64(7), data.Points)
+	redemptions, _ := store.ListRedemptions()
+	assert.Len(t, redemptions, 1)
+	assert.Equal(t, "confirmed", redemptions[0].Status)
+}
+
+// Test on compacting the log
+// expected: the log is emptied into the snapshot, and the log written after the snapshot is replayed over it
+func TestFileStore_Compact(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir, FileStoreOptions{CompactEvery: 3})
+	assert.NoError(t, err)
+	assert.NoError(t, store.SaveReceipt("a", testReceiptData("Target", 1)))
+	assert.NoError(t, store.SaveReceipt("b", testReceiptData("Target", 2)))
+	assert.NoError(t, store.SaveReceipt("c", testReceiptData("Target", 3))) // compacts
+	info, err := os.Stat(filepath.Join(dir, logFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), info.Size())
+
+	assert.NoError(t, store.SaveReceipt("a", testReceiptData("Target", 10)))
+	_, err = store.DeleteReceipt("b")
+	assert.NoError(t, err)
+	assert.NoError(t, store.log.Close())
+
+	store, err = OpenFileStore(dir, FileStoreOptions{})
+	assert.NoError(t, err)
+	list, _ := store.ListReceipts()
+	assert.Len(t, list, 2)
+	assert.Equal(t, int64(10), list[0].Points)
+	assert.Equal(t, "c", list[1].ID)
+
+	// close compacts, the snapshot alone holds everything
+	assert.NoError(t, store.Close())
+	info, _ = os.Stat(filepath.Join(dir, logFileName))
+	assert.Equal(t, int64(0), info.Size())
+	store, err = OpenFileStore(dir, FileStoreOptions{})
+	assert.NoError(t, err)
+	defer store.Close()
+	list, _ = store.ListReceipts()
+	assert.Len(t, list, 2)
+}
+
+// Test on a crash in the middle of a write: the log is truncated at every byte of its last record
+// expected: the previous records are recovered, the torn record is dropped, and new writes are readable after a restart
+func TestFileStore_TornRecord(t *testing.T) {
+	// write a log of two records, and remember where the second one starts
+	source := t.TempDir()
+	store, err := OpenFileStore(source, FileStoreOptions{CompactEvery: -1})
+	assert.NoError(t, err)
+	assert.NoError(t, store.SaveReceipt("a", testReceiptData("Target", 6)))
+	info, _ := store.log.Stat()
+	firstRecordEnd := info.Size()
+	assert.NoError(t, store.SaveReceipt("b", testReceiptData("Walmart", 7)))
+	assert.NoError(t, store.log.Close())
+	log, err := os.ReadFile(filepath.Join(source, logFileName))
+	assert.NoError(t, err)
+
+	for size := firstRecordEnd; size < int64(len(log)); size++ {
+		dir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, logFileName), log[:size], 0o644))
+
+		store, err := OpenFileStore(dir, FileStoreOptions{CompactEvery: -1})
+		if !assert.NoError(t, err, size) {
+			continue
+		}
+		list, _ := store.ListReceipts()
+		assert.Len(t, list, 1, size)
+		assert.Equal(t, "a", list[0].ID, size)
+
+		// the torn tail is gone, so a new record is not lost behind it
+		assert.NoError(t, store.SaveReceipt("c", testReceiptData("Costco", 8)))
+		assert.NoError(t, store.log.Close())
+		store, err = OpenFileStore(dir, FileStoreOptions{CompactEvery: -1})
+		assert.NoError(t, err)
+		list, _ = store.ListReceipts()
+		assert.Len(t, list, 2, size)
+		assert.NoError(t, store.Close())
+	}
+}
+
+// faultyLog is a log whose writes stop after writeLimit bytes (a torn write), and whose truncation can fail.
+type faultyLog struct {
+	logFile
+	writeLimit   int
+	failTruncate bool
+}
+
+func (l *faultyLog) Write(data []byte) (int, error) {
+	written, _ := l.logFile.Write(data[:min(len(data), l.writeLimit)])
+	return written, errors.New("disk full")
+}
+
+func (l *faultyLog) Truncate(size int64) error {
+	if l.failTruncate {
+		return errors.New("I/O error")
+	}
+	return l.logFile.Truncate(size)
+}
+
+// Test on a write failing in the middle of a record
+// expected: the torn record is removed from the log, so the records acknowledged after it survive a restart;
+// if it cannot be removed, the writes are refused until the log is compacted
+func TestFileStore_FailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir, FileStoreOptions{CompactEvery: -1})
+	assert.NoError(t, err)
+	assert.NoError(t, store.SaveReceipt("a", testReceiptData("Target", 6)))
+	log := store.log
+
+	// 1. the torn record is rolled back
+	store.log = &faultyLog{logFile: log, writeLimit: 10}
+	assert.Error(t, store.SaveReceipt("b", testReceiptData("Walmart", 7)))
+	_, found, _ := store.GetReceiptData("b")
+	assert.False(t, found)
+	store.log = log
+	assert.NoError(t, store.SaveReceipt("c", testReceiptData("Costco", 8)))
+
+	// 2. the torn record cannot be rolled back
+	store.log = &faultyLog{logFile: log, writeLimit: 10, failTruncate: true}
+	assert.Error(t, store.SaveReceipt("d", testReceiptData("Kroger", 9)))
+	store.log = log
+	assert.Error(t, store.SaveReceipt("d", testReceiptData("Kroger", 9)))
+	assert.NoError(t, store.Compact())
+	assert.NoError(t, store.SaveReceipt("d", testReceiptData("Kroger", 9)))
+
+	// simulate a crash: the acknowledged receipts are replayed
+	assert.NoError(t, store.log.Close())
+	store, err = OpenFileStore(dir, FileStoreOptions{CompactEvery: -1})
+	assert.NoError(t, err)
+	defer store.Close()
+	list, _ := store.ListReceipts()
+	ids := []string{}
+	for _, data := range list {
+		ids = append(ids, data.ID)
+	}
+	assert.Equal(t, []string{"a", "c", "d"}, ids)
+}
+
+// Test on a corrupted record at the end of the log
+// expected: the checksum mismatch is detected and the record is dropped
+func TestFileStore_CorruptedRecord(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir, FileStoreOptions{CompactEvery: -1})
+	assert.NoError(t, err)
+	assert.NoError(t, store.SaveReceipt("a", testReceiptData("Target", 6)))
+	assert.NoError(t, store.SaveReceipt("b", testReceiptData("Walmart", 7)))
+	assert.NoError(t, store.log.Close())
+
+	path := filepath.Join(dir, logFileName)
+	log, _ := os.ReadFile(path)
+	log[len(log)-2] ^= 0xff
+	assert.NoError(t, os.WriteFile(path, log, 0o644))
+
+	store, err = OpenFileStore(dir, FileStoreOptions{CompactEvery: -1})
+	assert.NoError(t, err)
+	defer store.Close()
+	list, _ := store.ListReceipts()
+	assert.Len(t, list, 1)
+	assert.Equal(t, "a", list[0].ID)
+}
+
+// Test on a corrupted snapshot
+// expected: the storage refuses to start instead of silently losing the receipts
+func TestFileStore_CorruptedSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, snapshotFileName), []byte("[{"), 0o644))
+	_, err := OpenFileStore(dir, FileStoreOptions{})
+	assert.ErrorContains(t, err, "Corrupted snapshot")
+}
+
+// Test on erasing receipts and redemptions
+// expected: the erased data is no longer in the files, and the tombstones survive a restart
+func TestFileStore_Erase(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir, FileStoreOptions{CompactEvery: -1})
+	assert.NoError(t, err)
+	assert.NoError(t, store.SaveReceipt("a", testReceiptData("Secret Retailer", 6)))
+	assert.NoError(t, store.SaveReceipt("b", testReceiptData("Target", 7)))
+	assert.NoError(t, store.SaveRedemption(Redemption{ID: "x", UserID: "secret-user", Points: 3, Status: "reserved"}))
+	_, err = store.EraseReceipts([]string{"a"}, TombstoneDeleted, time.Now())
+	assert.NoError(t, err)
+	assert.NoError(t, store.EraseRedemptions("secret-user"))
+
+	for _, name := range []string{logFileName, snapshotFileName} {
+		content, _ := os.ReadFile(filepath.Join(dir, name))
+		assert.NotContains(t, string(content), "Secret Retailer", name)
+		assert.NotContains(t, string(content), "secret-user", name)
+	}
+
+	assert.NoError(t, store.log.Close())
+	store, err = OpenFileStore(dir, FileStoreOptions{})
+	assert.NoError(t, err)
+	defer store.Close()
+	_, found, _ := store.GetReceiptData("a")
+	assert.False(t, found)
+	_, found, _ = store.GetTombstone("a")
+	assert.True(t, found)
+}
+
+// Test on a snapshot written before the tombstones (plain array of receipts)
+// expected: the receipts are loaded
+func TestFileStore_LegacySnapshot(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, snapshotFileName), []byte(`[{"ID": "a", "Points": 6}]`), 0o644))
+	store, err := OpenFileStore(dir, FileStoreOptions{})
+	assert.NoError(t, err)
+	defer store.Close()
+	data, found, _ := store.GetReceiptData("a")
+	assert.True(t, found)
+	assert.Equal(t, int64(6), data.Points)
+}
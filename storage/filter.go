@@ -0,0 +1,74 @@
+// storage/filter.go
+// Filtering of the stored receipts
+
+package storage
+
+import (
+	"strings"
+
+	"receipt-processor/models"
+)
+
+// ReceiptFilter selects receipts, the zero value matches every receipt.
+// The bounds are inclusive, empty (or nil) bounds are not checked.
+type ReceiptFilter struct {
+	UserID           string // exact user ID
+	Retailer         string // exact retailer name, case insensitive
+	PurchaseDateFrom string // YYYY-MM-DD
+	PurchaseDateTo   string // YYYY-MM-DD
+	MinTotalCents    *int64
+	MaxTotalCents    *int64
+	MinPoints        *int64
+	MaxPoints        *int64
+}
+
+// Matches
+// @Description    Check if the receipt data is selected by the filter
+// @Param          data: ReceiptData
+// @Return         true if selected, false otherwise: bool
+func (f ReceiptFilter) Matches(data ReceiptData) bool {
+	receipt := data.Receipt
+	if f.UserID != "" && receipt.UserID != f.UserID {
+		return false
+	}
+	if f.Retailer != "" && !strings.EqualFold(receipt.Retailer, f.Retailer) {
+		return false
+	}
+	// dates in the YYYY-MM-DD format are ordered like strings
+	if f.PurchaseDateFrom != "" && receipt.PurchaseDate < f.PurchaseDateFrom {
+		return false
+	}
+	if f.PurchaseDateTo != "" && receipt.PurchaseDate > f.PurchaseDateTo {
+		return false
+	}
+	if f.MinTotalCents != nil || f.MaxTotalCents != nil {
+		// a receipt without a valid total is not in any total range
+		total, err := models.ParseCents(receipt.Total)
+		if err != nil ||
+			(f.MinTotalCents != nil && total < *f.MinTotalCents) ||
+			(f.MaxTotalCents != nil && total > *f.MaxTotalCents) {
+			return false
+		}
+	}
+	if f.MinPoints != nil && data.Points < *f.MinPoints {
+		return false
+	}
+	if f.MaxPoints != nil && data.Points > *f.MaxPoints {
+		return false
+	}
+	return true
+}
+
+// filterReceipts
+// @Description    Keep the receipt data selected by the filter, in the same order
+// @Param          list: []ReceiptData, filter: ReceiptFilter
+// @Return         selected receipt data: []ReceiptData
+func filterReceipts(list []ReceiptData, filter ReceiptFilter) []ReceiptData {
+	selected := make([]ReceiptData, 0, len(list))
+	for _, data := range list {
+		if filter.Matches(data) {
+			selected = append(selected, data)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,223 @@
+// storage/memory.go
+// In memory storage for data
+
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is the in-memory ReceiptStore, everything is lost on restart.
+type MemoryStore struct {
+	mu sync.RWMutex
+	data map[string]ReceiptData
+	tombstones map[string]Tombstone
+	redemptions map[string]Redemption
+}
+
+// NewMemoryStore
+// @Description    Create an empty in-memory storage
+// @Param          none
+// @Return         pointer to the storage: *MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		data: make(map[string]ReceiptData),
+		tombstones: make(map[string]Tombstone),
+		redemptions: make(map[string]Redemption),
+	}
+}
+
+// SaveReceipt
+// @Description    Save a receipt and its calculated points to the storage
+// @Param          id: string, data: ReceiptData (its ID is set to id)
+// @Return         error: error (always nil)
+func (s *MemoryStore) SaveReceipt(id string, data ReceiptData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data.ID = id
+	s.data[id] = data
+	return nil
+}
+
+// GetReceiptData
+// @Description    Retrieve the receipt data from the storage based on the receipt ID
+// @Param          id: string
+// @Return         receipt data: ReceiptData, found: bool, error: error (always nil)
+func (s *MemoryStore) GetReceiptData(id string) (ReceiptData, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, found := s.data[id]
+	return data, found, nil
+}
+
+// ListReceipts
+// @Description    Retrieve all the receipt data from the storage, ordered by receipt ID
+// @Param          none
+// @Return         receipt data: []ReceiptData, error: error (always nil)
+func (s *MemoryStore) ListReceipts() ([]ReceiptData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]ReceiptData, 0, len(s.data))
+	for _, data := range s.data {
+		list = append(list, data)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// FindReceipts
+// @Description    Retrieve the receipt data selected by the filter, ordered by receipt ID
+// @Param          filter: ReceiptFilter
+// @Return         receipt data: []ReceiptData, error: error (always nil)
+func (s *MemoryStore) FindReceipts(filter ReceiptFilter) ([]ReceiptData, error) {
+	list, _ := s.ListReceipts()
+	return filterReceipts(list, filter), nil
+}
+
+// QueryReceipts
+// @Description    Retrieve a page of the receipt data selected by the filter, in the query order
+// @Param          query: ReceiptQuery
+// @Return         page of receipts: ReceiptPage, error: error (always nil)
+func (s *MemoryStore) QueryReceipts(query ReceiptQuery) (ReceiptPage, error) {
+	list, _ := s.ListReceipts()
+	return queryReceipts(list, query), nil
+}
+
+// DeleteReceipt
+// @Description    Remove a receipt from the storage based on the receipt ID
+// @Param          id: string
+// @Return         found: bool, error: error (always nil)
+func (s *MemoryStore) DeleteReceipt(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, found := s.data[id]
+	delete(s.data, id)
+	return found, nil
+}
+
+// EraseReceipts
+// @Description    Remove the receipts from the storage, and record a tombstone for each of them
+// @Param          ids: []string (unknown IDs are skipped), reason: string, at: time.Time
+// @Return         tombstones of the erased receipts: []Tombstone, error: error (always nil)
+func (s *MemoryStore) EraseReceipts(ids []string, reason string, at time.Time) ([]Tombstone, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tombstones := []Tombstone{}
+	for _, id := range ids {
+		if _, found := s.data[id]; !found {
+			continue
+		}
+		delete(s.data, id)
+		tombstone := Tombstone{ID: id, Reason: reason, DeletedAt: at.UTC()}
+		s.tombstones[id] = tombstone
+		tombstones = append(tombstones, tombstone)
+	}
+	return tombstones, nil
+}
+
+// restoreTombstone
+// @Description    Record a tombstone as is, used to reload a persisted storage
+// @Param          tombstone: Tombstone
+// @Return         none
+func (s *MemoryStore) restoreTombstone(tombstone Tombstone) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, tombstone.ID)
+	s.tombstones[tombstone.ID] = tombstone
+}
+
+// GetTombstone
+// @Description    Retrieve the tombstone of an erased receipt
+// @Param          id: string
+// @Return         tombstone: Tombstone, found: bool, error: error (always nil)
+func (s *MemoryStore) GetTombstone(id string) (Tombstone, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tombstone, found := s.tombstones[id]
+	return tombstone, found, nil
+}
+
+// ListTombstones
+// @Description    Retrieve all the tombstones, ordered by erasure time and receipt ID
+// @Param          none
+// @Return         tombstones: []Tombstone, error: error (always nil)
+func (s *MemoryStore) ListTombstones() ([]Tombstone, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Tombstone, 0, len(s.tombstones))
+	for _, tombstone := range s.tombstones {
+		list = append(list, tombstone)
+	}
+	sortTombstones(list)
+	return list, nil
+}
+
+// sortTombstones
+// @Description    Sort tombstones by erasure time and receipt ID
+// @Param          list: []Tombstone
+// @Return         none
+func sortTombstones(list []Tombstone) {
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].DeletedAt.Equal(list[j].DeletedAt) {
+			return list[i].DeletedAt.Before(list[j].DeletedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+}
+
+// SaveRedemption
+// @Description    Save a redemption to the storage, replacing its previous state
+// @Param          redemption: Redemption
+// @Return         error: error (always nil)
+func (s *MemoryStore) SaveRedemption(redemption Redemption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	redemption.Lots = append([]RedemptionLot{}, redemption.Lots...)
+	s.redemptions[redemption.ID] = redemption
+	return nil
+}
+
+// ListRedemptions
+// @Description    Retrieve all the redemptions, ordered by creation time and redemption ID
+// @Param          none
+// @Return         redemptions: []Redemption, error: error (always nil)
+func (s *MemoryStore) ListRedemptions() ([]Redemption, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Redemption, 0, len(s.redemptions))
+	for _, redemption := range s.redemptions {
+		list = append(list, redemption)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// EraseRedemptions
+// @Description    Remove the redemptions of a user from the storage
+// @Param          userID: string
+// @Return         error: error (always nil)
+func (s *MemoryStore) EraseRedemptions(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, redemption := range s.redemptions {
+		if redemption.UserID == userID {
+			delete(s.redemptions, id)
+		}
+	}
+	return nil
+}
+
+// Close
+// @Description    Nothing to release for the in-memory storage
+// @Param          none
+// @Return         error: error (always nil)
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,103 @@
+// storage/query.go
+// Ordered, paginated queries of the stored receipts
+
+package storage
+
+import (
+	"sort"
+	"strings"
+)
+
+// ReceiptSort is the order of the receipts returned by QueryReceipts, ties are broken by receipt ID.
+type ReceiptSort string
+
+const (
+	SortByPurchaseDate ReceiptSort = "purchaseDate" // purchase date, then purchase time
+	SortByPoints       ReceiptSort = "points"
+)
+
+// ReceiptCursor is the position of a receipt in a query order (keyset pagination):
+// the next page starts right after it, so receipts added or removed meanwhile do not shift the pages.
+type ReceiptCursor struct {
+	PurchaseDate string `json:"purchaseDate,omitempty"` // YYYY-MM-DDTHH:MM, used by SortByPurchaseDate
+	Points       int64  `json:"points,omitempty"`       // used by SortByPoints
+	ID           string `json:"id"`
+}
+
+// ReceiptQuery selects a page of receipts.
+type ReceiptQuery struct {
+	Filter     ReceiptFilter
+	Sort       ReceiptSort // defaults to SortByPurchaseDate
+	Descending bool
+	After      *ReceiptCursor // nil for the first page
+	Limit      int            // maximum number of receipts in the page, no limit if 0
+}
+
+// ReceiptPage is a page of receipts returned by QueryReceipts.
+type ReceiptPage struct {
+	Receipts []ReceiptData
+	Next     *ReceiptCursor // cursor of the next page, nil on the last page
+}
+
+// CursorOf
+// @Description    Get the cursor of a receipt in the query order
+// @Param          data: ReceiptData, sortBy: ReceiptSort
+// @Return         cursor: ReceiptCursor
+func CursorOf(data ReceiptData, sortBy ReceiptSort) ReceiptCursor {
+	cursor := ReceiptCursor{ID: data.ID}
+	if sortBy == SortByPoints {
+		cursor.Points = data.Points
+	} else {
+		cursor.PurchaseDate = data.Receipt.PurchaseDate + "T" + data.Receipt.PurchaseTime
+	}
+	return cursor
+}
+
+// compareCursors
+// @Description    Compare two cursors in the ascending query order
+// @Param          a: ReceiptCursor, b: ReceiptCursor, sortBy: ReceiptSort
+// @Return         -1 if a is first, 1 if b is first, 0 if equal: int
+func compareCursors(a ReceiptCursor, b ReceiptCursor, sortBy ReceiptSort) int {
+	if sortBy == SortByPoints {
+		if a.Points != b.Points {
+			if a.Points < b.Points {
+				return -1
+			}
+			return 1
+		}
+	} else if c := strings.Compare(a.PurchaseDate, b.PurchaseDate); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// queryReceipts
+// @Description    Run a query over a list of receipt data, for the backends that keep everything in memory
+// @Param          list: []ReceiptData, query: ReceiptQuery
+// @Return         page of receipts: ReceiptPage
+func queryReceipts(list []ReceiptData, query ReceiptQuery) ReceiptPage {
+	direction := 1
+	if query.Descending {
+		direction = -1
+	}
+	before := func(a ReceiptData, b ReceiptData) bool {
+		return direction*compareCursors(CursorOf(a, query.Sort), CursorOf(b, query.Sort), query.Sort) < 0
+	}
+
+	selected := filterReceipts(list, query.Filter)
+	sort.Slice(selected, func(i, j int) bool { return before(selected[i], selected[j]) })
+
+	start := 0
+	if query.After != nil {
+		start = sort.Search(len(selected), func(i int) bool {
+			return direction*compareCursors(CursorOf(selected[i], query.Sort), *query.After, query.Sort) > 0
+		})
+	}
+	page := ReceiptPage{Receipts: selected[start:]}
+	if query.Limit > 0 && len(page.Receipts) > query.Limit {
+		page.Receipts = page.Receipts[:query.Limit]
+		next := CursorOf(page.Receipts[query.Limit-1], query.Sort)
+		page.Next = &next
+	}
+	return page
+}
@@ -0,0 +1,708 @@
+// storage/sql.go
+// Embedded SQL storage (SQLite, pure Go driver): receipts can be queried ad hoc with any SQLite client.
+
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"receipt-processor/models"
+
+	_ "modernc.org/sqlite"
+)
+
+// migrations of the schema, applied in order at startup. The version of a migration is its index + 1.
+// Never edit an applied migration, append a new one instead.
+var migrations = []string{
+	// 1: receipts, their items, and their points breakdown
+	`CREATE TABLE receipts (
+		id            TEXT PRIMARY KEY,
+		source_id     TEXT NOT NULL DEFAULT '', -- id field of the submitted receipt
+		retailer      TEXT NOT NULL,
+		purchase_date TEXT NOT NULL,            -- YYYY-MM-DD
+		purchase_time TEXT NOT NULL,            -- HH:MM
+		total         TEXT NOT NULL,
+		points        INTEGER NOT NULL,
+		rule_version  TEXT NOT NULL
+	);
+	CREATE INDEX receipts_retailer ON receipts (retailer COLLATE NOCASE);
+	CREATE INDEX receipts_purchase_date ON receipts (purchase_date);
+	CREATE INDEX receipts_points ON receipts (points);
+	CREATE TABLE items (
+		receipt_id        TEXT NOT NULL REFERENCES receipts (id) ON DELETE CASCADE,
+		position          INTEGER NOT NULL,
+		short_description TEXT NOT NULL,
+		price             TEXT NOT NULL,
+		PRIMARY KEY (receipt_id, position)
+	);
+	CREATE TABLE points (
+		receipt_id TEXT NOT NULL REFERENCES receipts (id) ON DELETE CASCADE,
+		position   INTEGER NOT NULL,
+		rule_id    TEXT NOT NULL,
+		reason     TEXT NOT NULL,
+		points     INTEGER NOT NULL,
+		source     TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (receipt_id, position)
+	);`,
+	// 2: total in cents, for the total range filters (NULL if the total is not a valid amount)
+	`ALTER TABLE receipts ADD COLUMN total_cents INTEGER;
+	UPDATE receipts SET total_cents = CAST(ROUND(CAST(total AS REAL) * 100) AS INTEGER) WHERE total GLOB '[0-9]*.[0-9][0-9]';
+	CREATE INDEX receipts_total_cents ON receipts (total_cents);`,
+	// 3: processing time (RFC 3339 in UTC, empty if unknown)
+	`ALTER TABLE receipts ADD COLUMN processed_at TEXT NOT NULL DEFAULT '';`,
+	// 4: owner of the receipts, and tombstones of the erased receipts (no personal data)
+	`ALTER TABLE receipts ADD COLUMN user_id TEXT NOT NULL DEFAULT '';
+	CREATE INDEX receipts_user_id ON receipts (user_id);
+	CREATE TABLE tombstones (
+		id         TEXT PRIMARY KEY,
+		reason     TEXT NOT NULL,
+		deleted_at TEXT NOT NULL
+	);`,
+	// 5: reversals of the receipts (refunds)
+	`CREATE TABLE reversals (
+		receipt_id  TEXT NOT NULL REFERENCES receipts (id) ON DELETE CASCADE,
+		position    INTEGER NOT NULL,
+		items       TEXT NOT NULL, -- JSON array of the reversed item indexes, [] for a full reversal
+		points      INTEGER NOT NULL,
+		base_points INTEGER NOT NULL,
+		reason      TEXT NOT NULL DEFAULT '',
+		reversed_at TEXT NOT NULL,
+		PRIMARY KEY (receipt_id, position)
+	);`,
+	// 6: redemptions of points (the receipts they are taken from may be deleted, no foreign key)
+	`CREATE TABLE redemptions (
+		id         TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL,
+		points     INTEGER NOT NULL,
+		status     TEXT NOT NULL,   -- reserved, confirmed or cancelled
+		lots       TEXT NOT NULL,   -- JSON array of the points taken from each receipt
+		created_at TEXT NOT NULL,
+		settled_at TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX redemptions_user_id ON redemptions (user_id);`,
+}
+
+// SQLStore is the ReceiptStore backed by an embedded SQLite database file.
+type SQLStore struct {
+	db *sql.DB
+}
+
+// OpenSQLStore
+// @Description    Open (or create) the SQLite database at the given path, and apply the pending migrations.
+// @Param          path: string
+// @Return         pointer to the storage: *SQLStore, error: error
+func OpenSQLStore(path string) (*SQLStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=secure_delete(1)")
+	if err != nil {
+		return nil, fmt.Errorf("[OpenSQLStore] Failed to open the database %v: %w", path, err)
+	}
+	// secure_delete overwrites the deleted content instead of leaving it in the free pages of the file.
+	// SQLite allows a single writer, a single connection avoids busy errors between our own writes
+	db.SetMaxOpenConns(1)
+
+	store := &SQLStore{db: db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// migrate
+// @Description    Apply the migrations that are not recorded in the schema_migrations table yet, each in its own transaction.
+// @Param          none
+// @Return         error: error
+func (s *SQLStore) migrate() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("[migrate] Failed to create the schema_migrations table: %w", err)
+	}
+
+	var current int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("[migrate] Failed to read the schema version: %w", err)
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("[migrate] The database schema version %d is newer than this binary (%d)", current, len(migrations))
+	}
+
+	for version := current + 1; version <= len(migrations); version++ {
+		err := s.inTransaction(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(migrations[version-1]); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, version, time.Now().UTC().Format(time.RFC3339))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("[migrate] Failed to apply migration %d: %w", version, err)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion
+// @Description    Get the version of the last migration applied to the database.
+// @Param          none
+// @Return         version: int, error: error
+func (s *SQLStore) SchemaVersion() (int, error) {
+	var version int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("[SchemaVersion] %w", err)
+	}
+	return version, nil
+}
+
+// inTransaction
+// @Description    Run fn in a transaction, committed if fn succeeds and rolled back otherwise.
+// @Param          fn: func(*sql.Tx) error
+// @Return         error: error
+func (s *SQLStore) inTransaction(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkpoint
+// @Description    Copy the write-ahead log into the database and truncate it, so that erased data no longer is in the log.
+//                 A checkpoint blocked by a reader is reported by SQLite as busy, not as an error.
+// @Param          none
+// @Return         error: error (also when the checkpoint is busy)
+func (s *SQLStore) checkpoint() error {
+	var busy, logFrames, checkpointed int
+	if err := s.db.QueryRow(`PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logFrames, &checkpointed); err != nil {
+		return err
+	}
+	if busy != 0 {
+		return fmt.Errorf("checkpoint blocked by a reader (%d of %d frames copied)", checkpointed, logFrames)
+	}
+	return nil
+}
+
+// SaveReceipt
+// @Description    Save a receipt and its calculated points to the database, replacing its previous items and points
+// @Param          id: string, data: ReceiptData (its ID is set to id)
+// @Return         error: error
+func (s *SQLStore) SaveReceipt(id string, data ReceiptData) error {
+	receipt := data.Receipt
+	totalCents := sql.NullInt64{}
+	if cents, err := models.ParseCents(receipt.Total); err == nil {
+		totalCents = sql.NullInt64{Int64: cents, Valid: true}
+	}
+	err := s.inTransaction(func(tx *sql.Tx) error {
+		// deleting the row cascades to its items and points
+		if _, err := tx.Exec(`DELETE FROM receipts WHERE id = ?`, id); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO receipts (id, source_id, user_id, retailer, purchase_date, purchase_time, total, total_cents, points, rule_version, processed_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, receipt.ID, receipt.UserID, receipt.Retailer, receipt.PurchaseDate, receipt.PurchaseTime, receipt.Total, totalCents, data.Points, data.RuleVersion, formatTime(data.ProcessedAt))
+		if err != nil {
+			return err
+		}
+		for i, item := range receipt.Items {
+			_, err := tx.Exec(`INSERT INTO items (receipt_id, position, short_description, price) VALUES (?, ?, ?, ?)`,
+				id, i, item.ShortDescription, item.Price)
+			if err != nil {
+				return err
+			}
+		}
+		for i, entry := range data.Breakdown {
+			_, err := tx.Exec(`INSERT INTO points (receipt_id, position, rule_id, reason, points, source) VALUES (?, ?, ?, ?, ?, ?)`,
+				id, i, entry.RuleID, entry.Reason, entry.Points, entry.Source)
+			if err != nil {
+				return err
+			}
+		}
+		for i, reversal := range data.Reversals {
+			items, err := json.Marshal(append([]int{}, reversal.Items...))
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`INSERT INTO reversals (receipt_id, position, items, points, base_points, reason, reversed_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				id, i, string(items), reversal.Points, reversal.BasePoints, reversal.Reason, formatTime(reversal.ReversedAt))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("[SaveReceipt] %w", err)
+	}
+	return nil
+}
+
+// GetReceiptData
+// @Description    Retrieve the receipt data from the database based on the receipt ID
+// @Param          id: string
+// @Return         receipt data: ReceiptData, found: bool, error: error
+func (s *SQLStore) GetReceiptData(id string) (ReceiptData, bool, error) {
+	list, err := s.query(`WHERE id = ?`, id)
+	if err != nil {
+		return ReceiptData{}, false, fmt.Errorf("[GetReceiptData] %w", err)
+	}
+	if len(list) == 0 {
+		return ReceiptData{}, false, nil
+	}
+	return list[0], true, nil
+}
+
+// ListReceipts
+// @Description    Retrieve all the receipt data from the database, ordered by receipt ID
+// @Param          none
+// @Return         receipt data: []ReceiptData, error: error
+func (s *SQLStore) ListReceipts() ([]ReceiptData, error) {
+	list, err := s.query(`ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("[ListReceipts] %w", err)
+	}
+	return list, nil
+}
+
+// FindReceipts
+// @Description    Retrieve the receipt data selected by the filter, ordered by receipt ID. The filter runs in SQL.
+// @Param          filter: ReceiptFilter
+// @Return         receipt data: []ReceiptData, error: error
+func (s *SQLStore) FindReceipts(filter ReceiptFilter) ([]ReceiptData, error) {
+	conditions, args := filterConditions(filter)
+	list, err := s.query(where(conditions)+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("[FindReceipts] %w", err)
+	}
+	return list, nil
+}
+
+// QueryReceipts
+// @Description    Retrieve a page of the receipt data selected by the filter, in the query order.
+//                 The filter, the order and the keyset pagination run in SQL.
+// @Param          query: ReceiptQuery
+// @Return         page of receipts: ReceiptPage, error: error
+func (s *SQLStore) QueryReceipts(query ReceiptQuery) (ReceiptPage, error) {
+	conditions, args := filterConditions(query.Filter)
+
+	key := `purchase_date || 'T' || purchase_time`
+	if query.Sort == SortByPoints {
+		key = `points`
+	}
+	comparison, direction := `>`, `ASC`
+	if query.Descending {
+		comparison, direction = `<`, `DESC`
+	}
+	if query.After != nil {
+		conditions = append(conditions, `(`+key+`, id) `+comparison+` (?, ?)`)
+		if query.Sort == SortByPoints {
+			args = append(args, query.After.Points, query.After.ID)
+		} else {
+			args = append(args, query.After.PurchaseDate, query.After.ID)
+		}
+	}
+
+	clauses := where(conditions) + ` ORDER BY ` + key + ` ` + direction + `, id ` + direction
+	if query.Limit > 0 {
+		// one more receipt tells if there is a next page
+		clauses += ` LIMIT ?`
+		args = append(args, query.Limit+1)
+	}
+	list, err := s.query(clauses, args...)
+	if err != nil {
+		return ReceiptPage{}, fmt.Errorf("[QueryReceipts] %w", err)
+	}
+
+	page := ReceiptPage{Receipts: list}
+	if query.Limit > 0 && len(list) > query.Limit {
+		page.Receipts = list[:query.Limit]
+		next := CursorOf(page.Receipts[query.Limit-1], query.Sort)
+		page.Next = &next
+	}
+	return page, nil
+}
+
+// filterConditions
+// @Description    Translate a filter to SQL conditions on the receipts table.
+// @Param          filter: ReceiptFilter
+// @Return         conditions: []string, arguments of the conditions: []any
+func filterConditions(filter ReceiptFilter) ([]string, []any) {
+	conditions := []string{}
+	args := []any{}
+	if filter.UserID != "" {
+		conditions = append(conditions, `user_id = ?`)
+		args = append(args, filter.UserID)
+	}
+	if filter.Retailer != "" {
+		conditions = append(conditions, `retailer = ? COLLATE NOCASE`)
+		args = append(args, filter.Retailer)
+	}
+	if filter.PurchaseDateFrom != "" {
+		conditions = append(conditions, `purchase_date >= ?`)
+		args = append(args, filter.PurchaseDateFrom)
+	}
+	if filter.PurchaseDateTo != "" {
+		conditions = append(conditions, `purchase_date <= ?`)
+		args = append(args, filter.PurchaseDateTo)
+	}
+	if filter.MinTotalCents != nil {
+		conditions = append(conditions, `total_cents >= ?`)
+		args = append(args, *filter.MinTotalCents)
+	}
+	if filter.MaxTotalCents != nil {
+		conditions = append(conditions, `total_cents <= ?`)
+		args = append(args, *filter.MaxTotalCents)
+	}
+	if filter.MinPoints != nil {
+		conditions = append(conditions, `points >= ?`)
+		args = append(args, *filter.MinPoints)
+	}
+	if filter.MaxPoints != nil {
+		conditions = append(conditions, `points <= ?`)
+		args = append(args, *filter.MaxPoints)
+	}
+	return conditions, args
+}
+
+// where
+// @Description    Build the WHERE clause of the conditions, empty if there is no condition.
+// @Param          conditions: []string
+// @Return         where clause: string
+func where(conditions []string) string {
+	if len(conditions) == 0 {
+		return ``
+	}
+	return `WHERE ` + strings.Join(conditions, ` AND `)
+}
+
+// query
+// @Description    Load the receipts selected by the clauses (WHERE, ORDER BY, LIMIT), with their items and points.
+// @Param          clauses: string, args: ...any
+// @Return         receipt data: []ReceiptData, error: error
+func (s *SQLStore) query(clauses string, args ...any) ([]ReceiptData, error) {
+	rows, err := s.db.Query(`SELECT id, source_id, user_id, retailer, purchase_date, purchase_time, total, points, rule_version, processed_at
+		FROM receipts `+clauses, args...)
+	if err != nil {
+		return nil, err
+	}
+	list := []ReceiptData{}
+	for rows.Next() {
+		var data ReceiptData
+		var processedAt string
+		receipt := &data.Receipt
+		err := rows.Scan(&data.ID, &receipt.ID, &receipt.UserID, &receipt.Retailer, &receipt.PurchaseDate, &receipt.PurchaseTime, &receipt.Total, &data.Points, &data.RuleVersion, &processedAt)
+		if err == nil {
+			data.ProcessedAt, err = parseTime(processedAt)
+		}
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		list = append(list, data)
+	}
+	// the rows must be closed before loading the details, the database has a single connection
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range list {
+		if err := s.loadDetails(&list[i]); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// loadDetails
+// @Description    Load the items, the points breakdown and the reversals of a receipt.
+// @Param          data: *ReceiptData
+// @Return         error: error
+func (s *SQLStore) loadDetails(data *ReceiptData) error {
+	rows, err := s.db.Query(`SELECT short_description, price FROM items WHERE receipt_id = ? ORDER BY position`, data.ID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var item models.Item
+		if err := rows.Scan(&item.ShortDescription, &item.Price); err != nil {
+			rows.Close()
+			return err
+		}
+		data.Receipt.Items = append(data.Receipt.Items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = s.db.Query(`SELECT rule_id, reason, points, source FROM points WHERE receipt_id = ? ORDER BY position`, data.ID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var entry models.PointsBreakdownEntry
+		if err := rows.Scan(&entry.RuleID, &entry.Reason, &entry.Points, &entry.Source); err != nil {
+			rows.Close()
+			return err
+		}
+		data.Breakdown = append(data.Breakdown, entry)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = s.db.Query(`SELECT items, points, base_points, reason, reversed_at FROM reversals WHERE receipt_id = ? ORDER BY position`, data.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reversal Reversal
+		var items, reversedAt string
+		if err := rows.Scan(&items, &reversal.Points, &reversal.BasePoints, &reversal.Reason, &reversedAt); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(items), &reversal.Items); err != nil {
+			return err
+		}
+		if len(reversal.Items) == 0 {
+			reversal.Items = nil
+		}
+		if reversal.ReversedAt, err = parseTime(reversedAt); err != nil {
+			return err
+		}
+		data.Reversals = append(data.Reversals, reversal)
+	}
+	return rows.Err()
+}
+
+// sqlTimeFormat is RFC 3339 with a fixed number of decimals, so the formatted times are ordered like strings.
+const sqlTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// formatTime
+// @Description    Format a time for the database, in UTC. The zero time is stored as an empty string.
+// @Param          value: time.Time
+// @Return         formatted time: string
+func formatTime(value time.Time) string {
+	if value.IsZero() {
+		return ""
+	}
+	return value.UTC().Format(sqlTimeFormat)
+}
+
+// parseTime
+// @Description    Parse a time formatted by formatTime.
+// @Param          value: string
+// @Return         time: time.Time, error: error
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// DeleteReceipt
+// @Description    Remove a receipt, its items and its points from the database based on the receipt ID
+// @Param          id: string
+// @Return         found: bool, error: error
+func (s *SQLStore) DeleteReceipt(id string) (bool, error) {
+	result, err := s.db.Exec(`DELETE FROM receipts WHERE id = ?`, id)
+	if err != nil {
+		return false, fmt.Errorf("[DeleteReceipt] %w", err)
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("[DeleteReceipt] %w", err)
+	}
+	return count > 0, nil
+}
+
+// EraseReceipts
+// @Description    Remove the receipts, their items and their points from the database, and record a tombstone for each of them.
+//                 The write-ahead log is checkpointed so the erased data is no longer in the database files.
+// @Param          ids: []string (unknown IDs are skipped), reason: string, at: time.Time
+// @Return         tombstones of the erased receipts: []Tombstone, error: error
+func (s *SQLStore) EraseReceipts(ids []string, reason string, at time.Time) ([]Tombstone, error) {
+	tombstones := []Tombstone{}
+	err := s.inTransaction(func(tx *sql.Tx) error {
+		for _, id := range ids {
+			result, err := tx.Exec(`DELETE FROM receipts WHERE id = ?`, id)
+			if err != nil {
+				return err
+			}
+			count, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				continue
+			}
+			tombstone := Tombstone{ID: id, Reason: reason, DeletedAt: at.UTC()}
+			_, err = tx.Exec(`INSERT OR REPLACE INTO tombstones (id, reason, deleted_at) VALUES (?, ?, ?)`,
+				tombstone.ID, tombstone.Reason, formatTime(tombstone.DeletedAt))
+			if err != nil {
+				return err
+			}
+			tombstones = append(tombstones, tombstone)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[EraseReceipts] %w", err)
+	}
+
+	if err := s.checkpoint(); err != nil {
+		return tombstones, fmt.Errorf("[EraseReceipts] The receipts are erased but still in the write-ahead log: %w", err)
+	}
+	return tombstones, nil
+}
+
+// GetTombstone
+// @Description    Retrieve the tombstone of an erased receipt
+// @Param          id: string
+// @Return         tombstone: Tombstone, found: bool, error: error
+func (s *SQLStore) GetTombstone(id string) (Tombstone, bool, error) {
+	list, err := s.queryTombstones(`WHERE id = ?`, id)
+	if err != nil {
+		return Tombstone{}, false, fmt.Errorf("[GetTombstone] %w", err)
+	}
+	if len(list) == 0 {
+		return Tombstone{}, false, nil
+	}
+	return list[0], true, nil
+}
+
+// ListTombstones
+// @Description    Retrieve all the tombstones, ordered by erasure time and receipt ID
+// @Param          none
+// @Return         tombstones: []Tombstone, error: error
+func (s *SQLStore) ListTombstones() ([]Tombstone, error) {
+	list, err := s.queryTombstones(`ORDER BY deleted_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("[ListTombstones] %w", err)
+	}
+	return list, nil
+}
+
+// queryTombstones
+// @Description    Load the tombstones selected by the clauses.
+// @Param          clauses: string, args: ...any
+// @Return         tombstones: []Tombstone, error: error
+func (s *SQLStore) queryTombstones(clauses string, args ...any) ([]Tombstone, error) {
+	rows, err := s.db.Query(`SELECT id, reason, deleted_at FROM tombstones `+clauses, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Tombstone{}
+	for rows.Next() {
+		var tombstone Tombstone
+		var deletedAt string
+		if err := rows.Scan(&tombstone.ID, &tombstone.Reason, &deletedAt); err != nil {
+			return nil, err
+		}
+		if tombstone.DeletedAt, err = parseTime(deletedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, tombstone)
+	}
+	return list, rows.Err()
+}
+
+// SaveRedemption
+// @Description    Save a redemption to the database, replacing its previous state
+// @Param          redemption: Redemption
+// @Return         error: error
+func (s *SQLStore) SaveRedemption(redemption Redemption) error {
+	lots, err := json.Marshal(append([]RedemptionLot{}, redemption.Lots...))
+	if err != nil {
+		return fmt.Errorf("[SaveRedemption] %w", err)
+	}
+	settledAt := ""
+	if redemption.SettledAt != nil {
+		settledAt = formatTime(*redemption.SettledAt)
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO redemptions (id, user_id, points, status, lots, created_at, settled_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		redemption.ID, redemption.UserID, redemption.Points, redemption.Status, string(lots), formatTime(redemption.CreatedAt), settledAt)
+	if err != nil {
+		return fmt.Errorf("[SaveRedemption] %w", err)
+	}
+	return nil
+}
+
+// ListRedemptions
+// @Description    Retrieve all the redemptions, ordered by creation time and redemption ID
+// @Param          none
+// @Return         redemptions: []Redemption, error: error
+func (s *SQLStore) ListRedemptions() ([]Redemption, error) {
+	rows, err := s.db.Query(`SELECT id, user_id, points, status, lots, created_at, settled_at FROM redemptions ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("[ListRedemptions] %w", err)
+	}
+	defer rows.Close()
+
+	list := []Redemption{}
+	for rows.Next() {
+		var redemption Redemption
+		var lots, createdAt, settledAt string
+		if err := rows.Scan(&redemption.ID, &redemption.UserID, &redemption.Points, &redemption.Status, &lots, &createdAt, &settledAt); err != nil {
+			return nil, fmt.Errorf("[ListRedemptions] %w", err)
+		}
+		if err := json.Unmarshal([]byte(lots), &redemption.Lots); err != nil {
+			return nil, fmt.Errorf("[ListRedemptions] Redemption %v: %w", redemption.ID, err)
+		}
+		if redemption.CreatedAt, err = parseTime(createdAt); err != nil {
+			return nil, fmt.Errorf("[ListRedemptions] Redemption %v: %w", redemption.ID, err)
+		}
+		if settledAt != "" {
+			settled, err := parseTime(settledAt)
+			if err != nil {
+				return nil, fmt.Errorf("[ListRedemptions] Redemption %v: %w", redemption.ID, err)
+			}
+			redemption.SettledAt = &settled
+		}
+		list = append(list, redemption)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[ListRedemptions] %w", err)
+	}
+	return list, nil
+}
+
+// EraseRedemptions
+// @Description    Remove the redemptions of a user from the database.
+//                 The write-ahead log is checkpointed so the erased data is no longer in the database files.
+// @Param          userID: string
+// @Return         error: error
+func (s *SQLStore) EraseRedemptions(userID string) error {
+	if _, err := s.db.Exec(`DELETE FROM redemptions WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("[EraseRedemptions] %w", err)
+	}
+	if err := s.checkpoint(); err != nil {
+		return fmt.Errorf("[EraseRedemptions] The redemptions are erased but still in the write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// Close
+// @Description    Close the database
+// @Param          none
+// @Return         error: error
+func (s *SQLStore) Close() error {
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("[Close] %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+// storage/sql_test.go
+// Tests for the embedded SQL storage and its migrations.
+
+package storage
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test on the SQL storage
+func TestSQLStore(t *testing.T) {
+	store, err := OpenSQLStore(filepath.Join(t.TempDir(), "receipts.db"))
+	assert.NoError(t, err)
+	testReceiptStore(t, store)
+	assert.NoError(t, store.Close())
+
+	store, err = OpenSQLStore(filepath.Join(t.TempDir(), "receipts.db"))
+	assert.NoError(t, err)
+	testFindReceipts(t, store)
+	assert.NoError(t, store.Close())
+
+	store, err = OpenSQLStore(filepath.Join(t.TempDir(), "receipts.db"))
+	assert.NoError(t, err)
+	testQueryReceipts(t, store)
+	assert.NoError(t, store.Close())
+
+	store, err = OpenSQLStore(filepath.Join(t.TempDir(), "receipts.db"))
+	assert.NoError(t, err)
+	testEraseReceipts(t, store)
+	assert.NoError(t, store.Close())
+
+	store, err = OpenSQLStore(filepath.Join(t.TempDir(), "receipts.db"))
+	assert.NoError(t, err)
+	testRedemptions(t, store)
+	assert.NoError(t, store.Close())
+}
+
+// Test on reopening the database
+// expected: the receipts are kept, and the migrations are applied only once
+func TestSQLStore_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.db")
+	store, err := OpenSQLStore(path)
+	assert.NoError(t, err)
+	version, err := store.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), version)
+	assert.NoError(t, store.SaveReceipt("a", testReceiptData("Target", 6)))
+	assert.NoError(t, store.Close())
+
+	store, err = OpenSQLStore(path)
+	assert.NoError(t, err)
+	defer store.Close()
+	var count int
+	assert.NoError(t, store.db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&count))
+	assert.Equal(t, len(migrations), count)
+	data, found, err := store.GetReceiptData("a")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, int64(6), data.Points)
+}
+
+// Test on deleting a receipt
+// expected: its items and points are deleted with it
+func TestSQLStore_DeleteCascade(t *testing.T) {
+	store, err := OpenSQLStore(filepath.Join(t.TempDir(), "receipts.db"))
+	assert.NoError(t, err)
+	defer store.Close()
+	data := testReceiptData("Target", 6)
+	data.Reversals = []Reversal{{Points: 6, ReversedAt: time.Now().UTC()}}
+	assert.NoError(t, store.SaveReceipt("a", data))
+	_, err = store.DeleteReceipt("a")
+	assert.NoError(t, err)
+
+	for _, table := range []string{"items", "points", "reversals"} {
+		var count int
+		assert.NoError(t, store.db.QueryRow(`SELECT COUNT(*) FROM `+table).Scan(&count))
+		assert.Equal(t, 0, count, table)
+	}
+}
+
+// Test on a database migrated by a newer version of the service
+// expected: the storage refuses to start
+func TestSQLStore_NewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.db")
+	store, err := OpenSQLStore(path)
+	assert.NoError(t, err)
+	_, err = store.db.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, '')`, len(migrations)+1)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Close())
+
+	_, err = OpenSQLStore(path)
+	assert.ErrorContains(t, err, "newer than this binary")
+}
+
+// Test on migrating a database created by the first version of the schema
+// expected: the total in cents is filled for the existing receipts
+func TestSQLStore_MigrateTotalCents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.db")
+	db, err := sql.Open("sqlite", "file:"+path)
+	assert.NoError(t, err)
+	for _, statement := range []string{
+		`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, applied_at TEXT NOT NULL)`,
+		migrations[0],
+		`INSERT INTO schema_migrations (version, applied_at) VALUES (1, '')`,
+		`INSERT INTO receipts (id, retailer, purchase_date, purchase_time, total, points, rule_version)
+			VALUES ('a', 'Target', '2022-01-01', '13:01', '35.35', 28, 'v1')`,
+	} {
+		_, err := db.Exec(statement)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, db.Close())
+
+	store, err := OpenSQLStore(path)
+	assert.NoError(t, err)
+	defer store.Close()
+	total := int64(3535)
+	list, err := store.FindReceipts(ReceiptFilter{MinTotalCents: &total, MaxTotalCents: &total})
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+}
+
+// Test on erasing receipts
+// expected: the erased data is no longer in the database files
+func TestSQLStore_Erase(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenSQLStore(filepath.Join(dir, "receipts.db"))
+	assert.NoError(t, err)
+	defer store.Close()
+	data := testReceiptData("Secret Retailer", 6)
+	data.Receipt.Items[0].ShortDescription = "Secret Item"
+	assert.NoError(t, store.SaveReceipt("a", data))
+	assert.NoError(t, store.SaveReceipt("b", testReceiptData("Target", 7)))
+	_, err = store.EraseReceipts([]string{"a"}, TombstoneDeleted, time.Now())
+	assert.NoError(t, err)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "receipts.db*"))
+	assert.NotEmpty(t, files)
+	for _, file := range files {
+		content, _ := os.ReadFile(file)
+		assert.NotContains(t, string(content), "Secret Retailer", file)
+		assert.NotContains(t, string(content), "Secret Item", file)
+	}
+}
+
+// Test on erasing data while another connection reads the database
+// expected: the data is erased, but the blocked checkpoint is reported as an error
+func TestSQLStore_EraseBusyCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.db")
+	store, err := OpenSQLStore(path)
+	assert.NoError(t, err)
+	defer store.Close()
+	// one connection without busy timeout, so that the blocked checkpoint returns at once
+	store.db.SetMaxOpenConns(1)
+	_, err = store.db.Exec(`PRAGMA busy_timeout = 0`)
+	assert.NoError(t, err)
+	data := testReceiptData("Target", 6)
+	data.Receipt.UserID = "alice"
+	assert.NoError(t, store.SaveReceipt("a", data))
+	assert.NoError(t, store.SaveRedemption(Redemption{ID: "r", UserID: "alice", Points: 1, Status: "confirmed", CreatedAt: time.Now()}))
+
+	reader, err := sql.Open("sqlite", "file:"+path)
+	assert.NoError(t, err)
+	defer reader.Close()
+	tx, err := reader.Begin()
+	assert.NoError(t, err)
+	defer tx.Rollback()
+	var count int
+	assert.NoError(t, tx.QueryRow(`SELECT COUNT(*) FROM receipts`).Scan(&count))
+
+	tombstones, err := store.EraseReceipts([]string{"a"}, TombstoneDeleted, time.Now())
+	assert.ErrorContains(t, err, "write-ahead log")
+	assert.Len(t, tombstones, 1)
+	_, found, _ := store.GetReceiptData("a")
+	assert.False(t, found)
+	assert.ErrorContains(t, store.EraseRedemptions("alice"), "write-ahead log")
+	redemptions, _ := store.ListRedemptions()
+	assert.Empty(t, redemptions)
+
+	// the reader is done, the checkpoint succeeds
+	assert.NoError(t, tx.Rollback())
+	assert.NoError(t, store.EraseRedemptions("alice"))
+}
@@ -1,67 +1,142 @@
 // storage/storage.go
-// In memory storage for data
+// Storage abstraction for data
 
-// Storage package provides in-memory storage for the application.
+// Storage package provides the receipt storage of the application, and its backends.
 package storage
 
 import (
-	"sync"
+	"time"
 
 	"receipt-processor/models"
 )
 
 // ReceiptData is a struct that holds the receipt info and the calculated points associated with it.
 type ReceiptData struct {
+	ID string
 	Receipt models.Receipt
 	Points int64
+	Breakdown []models.PointsBreakdownEntry // per rule points, sums up to Points
+	RuleVersion string // version of the rule set that scored the receipt
+	ProcessedAt time.Time // when the receipt was processed, UTC
+	Reversals []Reversal // reversals of the receipt (refunds), oldest first; Points stays the original points
 }
 
-// Storage is where we map receipt IDs to their data.
-// We do not store invalid receipts in the storage
-type Storage struct {
-	mu sync.RWMutex
-	data map[string]ReceiptData
+// Reversal records the reversal of a receipt, or of some of its items (e.g. after a refund), and the points taken back.
+type Reversal struct {
+	Items      []int     `json:"items,omitempty"` // indexes of the reversed items in the stored receipt, empty for a full reversal
+	Points     int64     `json:"points"`          // points taken back, not negative
+	BasePoints int64     `json:"basePoints"`      // points of the remaining items before the tier of the user, counted for the tier
+	Reason     string    `json:"reason,omitempty"`
+	ReversedAt time.Time `json:"reversedAt"`
 }
 
-// ensuring the singleton pattern
-var (
-	storageInstance *Storage
-	once sync.Once
-)
+// ReversedPoints
+// @Description    Get the points taken back by the reversals of the receipt.
+// @Param          none
+// @Return         reversed points: int64
+func (d *ReceiptData) ReversedPoints() int64 {
+	var reversed int64
+	for _, reversal := range d.Reversals {
+		reversed += reversal.Points
+	}
+	return reversed
+}
+
+// NetPoints
+// @Description    Get the points the receipt is worth after its reversals.
+// @Param          none
+// @Return         net points: int64
+func (d *ReceiptData) NetPoints() int64 {
+	return d.Points - d.ReversedPoints()
+}
 
-// GetStorageInstance
-// @Description    Get the singleton instance of the storage
+// FullyReversed
+// @Description    Check if the whole receipt was reversed: by a reversal without items, or item by item.
 // @Param          none
-// @Return         pointer to the storage instance: *Storage
-func GetStorageInstance() *Storage {
-	once.Do(func() {
-		storageInstance = &Storage{
-			data: make(map[string]ReceiptData),
+// @Return         true if the reversals cover the whole receipt: bool
+func (d *ReceiptData) FullyReversed() bool {
+	for _, reversal := range d.Reversals {
+		if len(reversal.Items) == 0 {
+			return true
 		}
-	})
-	return storageInstance
+	}
+	return len(d.Reversals) > 0 && len(d.ReversedItems()) >= len(d.Receipt.Items)
 }
 
-// SaveReceipt
-// @Description    Save a receipt and its calculated points to the storage
-// @Param          id: string, receipt: models.Receipt, points: int64
-// @Return         none
-func (s *Storage) SaveReceipt(id string, receipt models.Receipt, points int64) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.data[id] = ReceiptData{
-		Receipt: receipt,
-		Points: points,
+// ReversedItems
+// @Description    Get the indexes of the items reversed by the partial reversals of the receipt.
+// @Param          none
+// @Return         reversed item indexes: map[int]bool
+func (d *ReceiptData) ReversedItems() map[int]bool {
+	reversed := map[int]bool{}
+	for _, reversal := range d.Reversals {
+		for _, index := range reversal.Items {
+			reversed[index] = true
+		}
 	}
+	return reversed
 }
 
-// GetReceiptData
-// @Description    Retrieve the receipt data from the storage based on the receipt ID
-// @Param          id: string
-// @Return         receipt data: ReceiptData, found: bool
-func (s *Storage) GetReceiptData(id string) (ReceiptData, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	data, found := s.data[id]
-	return data, found
-}
\ No newline at end of file
+// Tombstone records the erasure of a receipt for auditing. It holds no personal data from the receipt.
+type Tombstone struct {
+	ID        string    `json:"id"`
+	Reason    string    `json:"reason"` // e.g. TombstoneDeleted, TombstoneUserErasure
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// tombstone reasons
+const (
+	TombstoneDeleted      = "deleted"      // the receipt was deleted by ID
+	TombstoneUserErasure  = "user-erasure" // the receipt was erased with all the receipts of its user
+)
+
+// Redemption records a spending of points by a user, reserved then confirmed or cancelled, so that it survives a restart.
+type Redemption struct {
+	ID        string          `json:"id"`
+	UserID    string          `json:"userId"`
+	Points    int64           `json:"points"`
+	Status    string          `json:"status"` // reserved, confirmed or cancelled
+	Lots      []RedemptionLot `json:"lots"`   // points taken from the lot of each receipt, oldest first
+	CreatedAt time.Time       `json:"createdAt"`
+	SettledAt *time.Time      `json:"settledAt,omitempty"` // time of the confirmation or cancellation
+}
+
+// RedemptionLot is the points a redemption takes from the points earned by a receipt.
+type RedemptionLot struct {
+	ReceiptID string `json:"receiptId"`
+	Points    int64  `json:"points"`
+}
+
+// ReceiptStore is where we map receipt IDs to their data, implemented by the storage backends.
+// We do not store invalid receipts in the storage.
+// Implementations must be safe for concurrent use.
+type ReceiptStore interface {
+	// SaveReceipt saves (or replaces) the data of a receipt, its ID is set to id.
+	SaveReceipt(id string, data ReceiptData) error
+	// GetReceiptData retrieves the data of a receipt, found is false if there is no receipt with that ID.
+	GetReceiptData(id string) (data ReceiptData, found bool, err error)
+	// ListReceipts retrieves the data of all the receipts, ordered by receipt ID.
+	ListReceipts() ([]ReceiptData, error)
+	// FindReceipts retrieves the data of the receipts selected by the filter, ordered by receipt ID.
+	FindReceipts(filter ReceiptFilter) ([]ReceiptData, error)
+	// QueryReceipts retrieves a page of the receipts selected by the filter, in the query order.
+	QueryReceipts(query ReceiptQuery) (ReceiptPage, error)
+	// DeleteReceipt removes a receipt without leaving a trace, found is false if there was no receipt with that ID.
+	DeleteReceipt(id string) (found bool, err error)
+	// EraseReceipts removes the receipts and records a tombstone for each of them (unknown IDs are skipped).
+	// Once it returns, the erased data is gone from the backend files, not only from its indexes.
+	EraseReceipts(ids []string, reason string, at time.Time) ([]Tombstone, error)
+	// GetTombstone retrieves the tombstone of an erased receipt, found is false if the receipt was not erased.
+	GetTombstone(id string) (tombstone Tombstone, found bool, err error)
+	// ListTombstones retrieves all the tombstones, ordered by erasure time and receipt ID.
+	ListTombstones() ([]Tombstone, error)
+	// SaveRedemption saves (or replaces) a redemption, by its ID.
+	SaveRedemption(redemption Redemption) error
+	// ListRedemptions retrieves all the redemptions, ordered by creation time and redemption ID.
+	ListRedemptions() ([]Redemption, error)
+	// EraseRedemptions removes the redemptions of a user.
+	// Once it returns, the erased data is gone from the backend files, not only from its indexes.
+	EraseRedemptions(userID string) error
+	// Close releases the resources of the backend.
+	Close() error
+}
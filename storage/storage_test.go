@@ -0,0 +1,305 @@
+// storage/storage_test.go
+// Tests for the storage backends, every backend must pass testReceiptStore.
+
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"receipt-processor/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// receipt data used by the storage tests
+func testReceiptData(retailer string, points int64) ReceiptData {
+	return ReceiptData{
+		Receipt: models.Receipt{
+			Retailer:     retailer,
+			PurchaseDate: "2022-03-20",
+			PurchaseTime: "14:33",
+			Total:        "2.25",
+			Items: []models.Item{
+				{ShortDescription: "Gatorade", Price: "2.25"},
+			},
+		},
+		Points: points,
+		Breakdown: []models.PointsBreakdownEntry{
+			{RuleID: "retailer-name", Reason: "test", Points: points, Source: "retailer"},
+		},
+		RuleVersion: "v1",
+	}
+}
+
+// testReceiptStore
+// @Description    Common behavior expected from every ReceiptStore implementation, starting from an empty store.
+// @Param          t: *testing.T, store: ReceiptStore
+// @Return         none
+func testReceiptStore(t *testing.T, store ReceiptStore) {
+	// empty store
+	_, found, err := store.GetReceiptData("a")
+	assert.NoError(t, err)
+	assert.False(t, found)
+	list, err := store.ListReceipts()
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+
+	// save and get
+	assert.NoError(t, store.SaveReceipt("b", testReceiptData("Target", 6)))
+	assert.NoError(t, store.SaveReceipt("a", testReceiptData("Walmart", 7)))
+	data, found, err := store.GetReceiptData("a")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	expected := testReceiptData("Walmart", 7)
+	expected.ID = "a"
+	assert.Equal(t, expected, data)
+
+	// processing time
+	withTime := testReceiptData("Costco", 9)
+	withTime.ProcessedAt = time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)
+	assert.NoError(t, store.SaveReceipt("c", withTime))
+	data, _, _ = store.GetReceiptData("c")
+	assert.Equal(t, withTime.ProcessedAt, data.ProcessedAt)
+	store.DeleteReceipt("c")
+
+	// reversals
+	reversed := testReceiptData("Costco", 9)
+	reversed.Reversals = []Reversal{
+		{Items: []int{1, 0}, Points: 3, BasePoints: 4, Reason: "refund", ReversedAt: time.Date(2024, 5, 7, 8, 0, 0, 0, time.UTC)},
+		{Points: 6, ReversedAt: time.Date(2024, 5, 8, 8, 0, 0, 0, time.UTC)},
+	}
+	assert.NoError(t, store.SaveReceipt("c", reversed))
+	data, _, _ = store.GetReceiptData("c")
+	assert.Equal(t, reversed.Reversals, data.Reversals)
+	store.DeleteReceipt("c")
+
+	// replace
+	assert.NoError(t, store.SaveReceipt("a", testReceiptData("Walmart", 8)))
+	data, _, _ = store.GetReceiptData("a")
+	assert.Equal(t, int64(8), data.Points)
+
+	// list, ordered by ID
+	list, err = store.ListReceipts()
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, "a", list[0].ID)
+	assert.Equal(t, "b", list[1].ID)
+
+	// delete
+	found, err = store.DeleteReceipt("a")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	found, err = store.DeleteReceipt("a")
+	assert.NoError(t, err)
+	assert.False(t, found)
+	_, found, _ = store.GetReceiptData("a")
+	assert.False(t, found)
+	list, _ = store.ListReceipts()
+	assert.Len(t, list, 1)
+}
+
+// testFindReceipts
+// @Description    Common filtering behavior expected from every ReceiptStore implementation, starting from an empty store.
+// @Param          t: *testing.T, store: ReceiptStore
+// @Return         none
+func testFindReceipts(t *testing.T, store ReceiptStore) {
+	for i, retailer := range []string{"Target", "Walmart", "target", "Costco"} {
+		data := testReceiptData(retailer, int64(10*i))
+		data.Receipt.PurchaseDate = fmt.Sprintf("2022-03-%02d", 10+i)
+		assert.NoError(t, store.SaveReceipt(fmt.Sprintf("r%d", i), data))
+	}
+	ids := func(filter ReceiptFilter) []string {
+		list, err := store.FindReceipts(filter)
+		assert.NoError(t, err)
+		ids := []string{}
+		for _, data := range list {
+			ids = append(ids, data.ID)
+		}
+		return ids
+	}
+	points := func(value int64) *int64 { return &value }
+
+	assert.Equal(t, []string{"r0", "r1", "r2", "r3"}, ids(ReceiptFilter{}))
+	assert.Equal(t, []string{"r0", "r2"}, ids(ReceiptFilter{Retailer: "TARGET"}))
+	assert.Equal(t, []string{"r1", "r2"}, ids(ReceiptFilter{PurchaseDateFrom: "2022-03-11", PurchaseDateTo: "2022-03-12"}))
+	assert.Equal(t, []string{"r2", "r3"}, ids(ReceiptFilter{MinPoints: points(20)}))
+	assert.Equal(t, []string{"r0"}, ids(ReceiptFilter{Retailer: "target", MaxPoints: points(10)}))
+	assert.Equal(t, []string{"r0", "r1", "r2", "r3"}, ids(ReceiptFilter{MinTotalCents: points(225), MaxTotalCents: points(225)}))
+	assert.Equal(t, []string{}, ids(ReceiptFilter{MinTotalCents: points(226)}))
+	assert.Equal(t, []string{}, ids(ReceiptFilter{Retailer: "Aldi"}))
+
+	// the data is complete
+	list, _ := store.FindReceipts(ReceiptFilter{Retailer: "Walmart"})
+	expected := testReceiptData("Walmart", 10)
+	expected.ID = "r1"
+	expected.Receipt.PurchaseDate = "2022-03-11"
+	assert.Equal(t, []ReceiptData{expected}, list)
+}
+
+// testQueryReceipts
+// @Description    Common ordering and pagination behavior expected from every ReceiptStore implementation, starting from an empty store.
+// @Param          t: *testing.T, store: ReceiptStore
+// @Return         none
+func testQueryReceipts(t *testing.T, store ReceiptStore) {
+	// r0..r5, dates and points not in ID order, with ties
+	dates := []string{"2022-03-12", "2022-03-10", "2022-03-12", "2022-03-11", "2022-03-10", "2022-03-13"}
+	points := []int64{5, 30, 5, 20, 10, 0}
+	for i := range dates {
+		data := testReceiptData("Target", points[i])
+		data.Receipt.PurchaseDate = dates[i]
+		data.Receipt.Total = fmt.Sprintf("%d.00", i)
+		assert.NoError(t, store.SaveReceipt(fmt.Sprintf("r%d", i), data))
+	}
+
+	// pages of the query, as IDs
+	pages := func(query ReceiptQuery) [][]string {
+		result := [][]string{}
+		for {
+			page, err := store.QueryReceipts(query)
+			if !assert.NoError(t, err) {
+				return result
+			}
+			ids := []string{}
+			for _, data := range page.Receipts {
+				ids = append(ids, data.ID)
+			}
+			result = append(result, ids)
+			if page.Next == nil {
+				return result
+			}
+			query.After = page.Next
+		}
+	}
+
+	assert.Equal(t, [][]string{{"r1", "r4", "r3"}, {"r0", "r2", "r5"}}, pages(ReceiptQuery{Sort: SortByPurchaseDate, Limit: 3}))
+	assert.Equal(t, [][]string{{"r5", "r2", "r0", "r3"}, {"r4", "r1"}}, pages(ReceiptQuery{Descending: true, Limit: 4}))
+	assert.Equal(t, [][]string{{"r5", "r0"}, {"r2", "r4"}, {"r3", "r1"}}, pages(ReceiptQuery{Sort: SortByPoints, Limit: 2}))
+	assert.Equal(t, [][]string{{"r1", "r3", "r4", "r2", "r0", "r5"}}, pages(ReceiptQuery{Sort: SortByPoints, Descending: true}))
+	minTotal := int64(200)
+	assert.Equal(t, [][]string{{"r5", "r2"}, {"r4", "r3"}}, pages(ReceiptQuery{Filter: ReceiptFilter{MinTotalCents: &minTotal}, Sort: SortByPoints, Limit: 2}))
+	assert.Equal(t, [][]string{{}}, pages(ReceiptQuery{Filter: ReceiptFilter{Retailer: "Aldi"}, Limit: 2}))
+
+	// a receipt added before the cursor does not shift the next page
+	page, _ := store.QueryReceipts(ReceiptQuery{Sort: SortByPoints, Limit: 2})
+	data := testReceiptData("Target", 1)
+	assert.NoError(t, store.SaveReceipt("r6", data))
+	page, _ = store.QueryReceipts(ReceiptQuery{Sort: SortByPoints, Limit: 2, After: page.Next})
+	assert.Equal(t, "r2", page.Receipts[0].ID)
+}
+
+// testEraseReceipts
+// @Description    Common erasure behavior expected from every ReceiptStore implementation, starting from an empty store.
+// @Param          t: *testing.T, store: ReceiptStore
+// @Return         none
+func testEraseReceipts(t *testing.T, store ReceiptStore) {
+	for i, user := range []string{"alice", "bob", "alice"} {
+		data := testReceiptData("Target", int64(i))
+		data.Receipt.UserID = user
+		assert.NoError(t, store.SaveReceipt(fmt.Sprintf("r%d", i), data))
+	}
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// erase the receipts of a user
+	list, err := store.FindReceipts(ReceiptFilter{UserID: "alice"})
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	tombstones, err := store.EraseReceipts([]string{list[0].ID, list[1].ID, "unknown"}, TombstoneUserErasure, at)
+	assert.NoError(t, err)
+	assert.Equal(t, []Tombstone{
+		{ID: "r0", Reason: TombstoneUserErasure, DeletedAt: at},
+		{ID: "r2", Reason: TombstoneUserErasure, DeletedAt: at},
+	}, tombstones)
+	_, found, _ := store.GetReceiptData("r0")
+	assert.False(t, found)
+	list, _ = store.FindReceipts(ReceiptFilter{UserID: "alice"})
+	assert.Empty(t, list)
+
+	// the tombstones
+	tombstone, found, err := store.GetTombstone("r2")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, Tombstone{ID: "r2", Reason: TombstoneUserErasure, DeletedAt: at}, tombstone)
+	_, found, _ = store.GetTombstone("r1")
+	assert.False(t, found)
+
+	// erasing again is a no-op
+	tombstones, err = store.EraseReceipts([]string{"r0"}, TombstoneDeleted, at.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Empty(t, tombstones)
+
+	// erase by ID, listed after the previous tombstones
+	tombstones, err = store.EraseReceipts([]string{"r1"}, TombstoneDeleted, at.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, tombstones, 1)
+	all, err := store.ListTombstones()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"r0", "r2", "r1"}, []string{all[0].ID, all[1].ID, all[2].ID})
+}
+
+// testRedemptions
+// @Description    Common redemption behavior expected from every ReceiptStore implementation, starting from an empty store.
+// @Param          t: *testing.T, store: ReceiptStore
+// @Return         none
+func testRedemptions(t *testing.T, store ReceiptStore) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	reserved := Redemption{ID: "b", UserID: "alice", Points: 30, Status: "reserved", Lots: []RedemptionLot{{ReceiptID: "r0", Points: 30}}, CreatedAt: at}
+	assert.NoError(t, store.SaveRedemption(reserved))
+	assert.NoError(t, store.SaveRedemption(Redemption{ID: "a", UserID: "bob", Points: 5, Status: "reserved", Lots: []RedemptionLot{}, CreatedAt: at.Add(time.Hour)}))
+
+	// the new state replaces the previous one
+	settledAt := at.Add(time.Minute)
+	confirmed := reserved
+	confirmed.Status = "confirmed"
+	confirmed.SettledAt = &settledAt
+	assert.NoError(t, store.SaveRedemption(confirmed))
+	list, err := store.ListRedemptions()
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, confirmed, list[0])
+	assert.Equal(t, "a", list[1].ID)
+
+	// erase the redemptions of a user
+	assert.NoError(t, store.EraseRedemptions("alice"))
+	assert.NoError(t, store.EraseRedemptions("unknown"))
+	list, _ = store.ListRedemptions()
+	assert.Len(t, list, 1)
+	assert.Equal(t, "bob", list[0].UserID)
+}
+
+// Test on the in-memory storage
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	testReceiptStore(t, store)
+	assert.NoError(t, store.Close())
+
+	testFindReceipts(t, NewMemoryStore())
+	testQueryReceipts(t, NewMemoryStore())
+	testEraseReceipts(t, NewMemoryStore())
+	testRedemptions(t, NewMemoryStore())
+}
+
+// Test on the points of a receipt after its reversals
+// expected: the net points are the original points less the reversed points, a reversal without items is a full reversal
+func TestReceiptData_Reversals(t *testing.T) {
+	data := testReceiptData("Target", 10)
+	data.Receipt.Items = append(data.Receipt.Items, models.Item{ShortDescription: "Gum", Price: "0.50"}, models.Item{ShortDescription: "Tea", Price: "1.00"})
+	assert.Equal(t, int64(10), data.NetPoints())
+	assert.False(t, data.FullyReversed())
+	assert.Empty(t, data.ReversedItems())
+
+	data.Reversals = []Reversal{{Items: []int{0, 2}, Points: 4}}
+	assert.Equal(t, int64(4), data.ReversedPoints())
+	assert.Equal(t, int64(6), data.NetPoints())
+	assert.False(t, data.FullyReversed())
+	assert.Equal(t, map[int]bool{0: true, 2: true}, data.ReversedItems())
+
+	data.Reversals = append(data.Reversals, Reversal{Points: 6})
+	assert.Equal(t, int64(0), data.NetPoints())
+	assert.True(t, data.FullyReversed())
+
+	// every item reversed one by one
+	data.Reversals = []Reversal{{Items: []int{0, 2}, Points: 4}, {Items: []int{1}, Points: 6}}
+	assert.True(t, data.FullyReversed())
+}